@@ -18,9 +18,28 @@ const JobTasksTable string = "job_tasks"
 // TasksTable stores metadata about task
 const TasksTable string = "tasks"
 
+// JobTemplatesTable stores the named job templates
+const JobTemplatesTable string = "job_templates"
+
+// CampaignsTable stores the campaigns grouping related jobs
+const CampaignsTable string = "campaigns"
+
+// ProbeGroupsTable stores the named probe groups
+const ProbeGroupsTable string = "probe_groups"
+
+// ProbeGroupMembersTable stores the probes added to probe groups
+const ProbeGroupMembersTable string = "probe_group_members"
+
+// IdempotencyKeysTable stores the responses to requests with an
+// Idempotency-Key
+const IdempotencyKeysTable string = "idempotency_keys"
+
 // AccountsTable stores account information
 const AccountsTable string = "accounts"
 
+// AuditLogTable stores the append only log of administrative actions
+const AuditLogTable string = "audit_log"
+
 // CollectorsTable stores collector information
 const CollectorsTable string = "collectors"
 
@@ -95,3 +114,13 @@ var AllCountryCodes = mapStrStruct{
 	"VC": {}, "VE": {}, "VG": {}, "VI": {}, "VN": {}, "VU": {},
 	"WF": {}, "WS": {}, "YD": {}, "YE": {}, "YT": {}, "YU": {},
 	"ZA": {}, "ZM": {}, "ZR": {}, "ZW": {}, "XX": {}}
+
+// RevokedTokensTable stores the revoked tokens by jti
+const RevokedTokensTable string = "revoked_tokens"
+
+// RevokedUsersTable stores the time before which all the tokens of a user
+// are revoked
+const RevokedUsersTable string = "revoked_users"
+
+// RefreshTokensTable stores the hashes of the refresh tokens
+const RefreshTokensTable string = "refresh_tokens"
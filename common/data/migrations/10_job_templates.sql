@@ -0,0 +1,20 @@
+-- +migrate Down
+-- +migrate StatementBegin
+
+DROP TABLE IF EXISTS job_templates;
+
+-- +migrate StatementEnd
+
+-- +migrate Up
+-- +migrate StatementBegin
+
+CREATE TABLE IF NOT EXISTS job_templates
+(
+    name VARCHAR PRIMARY KEY NOT NULL,
+    description VARCHAR,
+    job JSONB NOT NULL,
+    creation_time TIMESTAMP WITH TIME ZONE,
+    last_updated TIMESTAMP WITH TIME ZONE
+);
+
+-- +migrate StatementEnd
@@ -0,0 +1,23 @@
+-- +migrate Down
+-- +migrate StatementBegin
+
+DROP TABLE IF EXISTS idempotency_keys;
+
+-- +migrate StatementEnd
+
+-- +migrate Up
+-- +migrate StatementBegin
+
+CREATE TABLE IF NOT EXISTS idempotency_keys
+(
+    scope VARCHAR NOT NULL,
+    key VARCHAR NOT NULL,
+    request_hash VARCHAR NOT NULL,
+    status_code INT,
+    response JSONB,
+    creation_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (scope, key)
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_creation_time_idx ON idempotency_keys (creation_time);
+
+-- +migrate StatementEnd
@@ -0,0 +1,15 @@
+-- +migrate Down
+-- Values cannot be removed from an enum type, so paused jobs are put back
+-- in the active state instead.
+-- +migrate StatementBegin
+
+UPDATE jobs SET state = 'active' WHERE state = 'paused';
+
+-- +migrate StatementEnd
+
+-- +migrate Up notransaction
+-- +migrate StatementBegin
+
+ALTER TYPE JOB_STATE ADD VALUE IF NOT EXISTS 'paused';
+
+-- +migrate StatementEnd
@@ -0,0 +1,18 @@
+-- +migrate Down
+-- +migrate StatementBegin
+
+DROP INDEX IF EXISTS jobs_name_idx;
+ALTER TABLE jobs DROP COLUMN IF EXISTS name;
+
+-- +migrate StatementEnd
+
+-- +migrate Up
+-- +migrate StatementBegin
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS name VARCHAR;
+-- Deleted jobs keep their name, so that a name can be reused once the job
+-- holding it is deleted
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_name_idx ON jobs (name)
+    WHERE state IS DISTINCT FROM 'deleted';
+
+-- +migrate StatementEnd
@@ -0,0 +1,27 @@
+-- +migrate Down
+-- +migrate StatementBegin
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS campaign_id;
+DROP TABLE IF EXISTS campaigns;
+
+-- +migrate StatementEnd
+
+-- +migrate Up
+-- +migrate StatementBegin
+
+CREATE TABLE IF NOT EXISTS campaigns
+(
+    id UUID PRIMARY KEY NOT NULL,
+    name VARCHAR UNIQUE NOT NULL,
+    description VARCHAR,
+    start_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    end_time TIMESTAMP WITH TIME ZONE,
+    creation_time TIMESTAMP WITH TIME ZONE,
+    last_updated TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS campaign_id UUID
+    REFERENCES campaigns (id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS jobs_campaign_id_idx ON jobs (campaign_id);
+
+-- +migrate StatementEnd
@@ -0,0 +1,35 @@
+-- +migrate Down
+-- +migrate StatementBegin
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS target_groups;
+DROP TABLE IF EXISTS probe_group_members;
+DROP TABLE IF EXISTS probe_groups;
+
+-- +migrate StatementEnd
+
+-- +migrate Up
+-- +migrate StatementBegin
+
+CREATE TABLE IF NOT EXISTS probe_groups
+(
+    name VARCHAR PRIMARY KEY NOT NULL,
+    description VARCHAR,
+    -- filter is an optional saved probe filter. The probes matching it are
+    -- members of the group along with the ones added explicitly.
+    filter JSONB,
+    creation_time TIMESTAMP WITH TIME ZONE,
+    last_updated TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE IF NOT EXISTS probe_group_members
+(
+    group_name VARCHAR NOT NULL
+        REFERENCES probe_groups (name) ON DELETE CASCADE,
+    probe_id UUID NOT NULL,
+    added_time TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (group_name, probe_id)
+);
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS target_groups VARCHAR[];
+
+-- +migrate StatementEnd
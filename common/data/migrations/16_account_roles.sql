@@ -0,0 +1,29 @@
+-- +migrate Down
+-- Values cannot be removed from an enum type, so the operator and viewer
+-- accounts are turned back into user accounts instead.
+-- +migrate StatementBegin
+
+UPDATE accounts SET role = 'user' WHERE role IN ('operator', 'viewer');
+ALTER TABLE jobs DROP COLUMN owner;
+
+-- +migrate StatementEnd
+
+-- +migrate Up notransaction
+-- +migrate StatementBegin
+
+ALTER TYPE ACCOUNT_ROLE ADD VALUE IF NOT EXISTS 'operator';
+
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+
+ALTER TYPE ACCOUNT_ROLE ADD VALUE IF NOT EXISTS 'viewer';
+
+-- +migrate StatementEnd
+
+-- +migrate StatementBegin
+
+ALTER TABLE jobs ADD COLUMN owner VARCHAR;
+CREATE INDEX jobs_owner_idx ON jobs (owner);
+
+-- +migrate StatementEnd
@@ -0,0 +1,38 @@
+-- +migrate Down
+-- +migrate StatementBegin
+
+DROP TABLE IF EXISTS audit_log;
+DROP FUNCTION IF EXISTS audit_log_append_only();
+
+-- +migrate StatementEnd
+
+-- +migrate Up
+-- +migrate StatementBegin
+
+CREATE TABLE audit_log
+(
+    id BIGSERIAL PRIMARY KEY NOT NULL,
+    time TIMESTAMP WITH TIME ZONE NOT NULL,
+    actor VARCHAR,
+    role VARCHAR,
+    action VARCHAR NOT NULL,
+    target_id VARCHAR,
+    body_digest VARCHAR,
+    source_ip VARCHAR
+);
+CREATE INDEX audit_log_actor_idx ON audit_log (actor);
+CREATE INDEX audit_log_target_id_idx ON audit_log (target_id);
+
+-- The audit log is append only, rows can be neither changed nor removed
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only();
+
+-- +migrate StatementEnd
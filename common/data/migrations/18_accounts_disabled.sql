@@ -0,0 +1,15 @@
+-- +migrate Down
+-- +migrate StatementBegin
+
+DROP INDEX IF EXISTS accounts_username_uindex;
+ALTER TABLE accounts DROP COLUMN disabled;
+
+-- +migrate StatementEnd
+
+-- +migrate Up
+-- +migrate StatementBegin
+
+ALTER TABLE accounts ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE UNIQUE INDEX IF NOT EXISTS accounts_username_uindex ON accounts (username);
+
+-- +migrate StatementEnd
@@ -0,0 +1,30 @@
+-- +migrate Down
+-- +migrate StatementBegin
+
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS revoked_users;
+
+-- +migrate StatementEnd
+
+-- +migrate Up
+-- +migrate StatementBegin
+
+CREATE TABLE IF NOT EXISTS revoked_tokens
+(
+    jti VARCHAR PRIMARY KEY NOT NULL,
+    username VARCHAR,
+    revoked_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    -- The revocation can be dropped once the token can no longer be used
+    -- or refreshed
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS revoked_users
+(
+    username VARCHAR PRIMARY KEY NOT NULL,
+    -- The tokens of the user issued before this time are revoked
+    revoked_before TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- +migrate StatementEnd
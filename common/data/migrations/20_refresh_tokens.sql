@@ -0,0 +1,30 @@
+-- +migrate Down
+-- +migrate StatementBegin
+
+DROP TABLE IF EXISTS refresh_tokens;
+
+-- +migrate StatementEnd
+
+-- +migrate Up
+-- +migrate StatementBegin
+
+CREATE TABLE IF NOT EXISTS refresh_tokens
+(
+    -- The hex encoded SHA256 of the token, the token itself is not stored
+    token_hash VARCHAR PRIMARY KEY NOT NULL,
+    -- Every refresh replaces the token with a new one of the same family.
+    -- The family ID is the jti of the access tokens issued with it.
+    family_id UUID NOT NULL,
+    username VARCHAR NOT NULL,
+    role ACCOUNT_ROLE NOT NULL,
+    issued_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    -- Set when the token is replaced, using it again revokes the family
+    used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_username_idx ON refresh_tokens (username);
+CREATE INDEX refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
+
+-- +migrate StatementEnd
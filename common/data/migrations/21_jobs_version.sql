@@ -0,0 +1,15 @@
+-- +migrate Down
+-- +migrate StatementBegin
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS "version";
+
+-- +migrate StatementEnd
+
+-- +migrate Up
+-- +migrate StatementBegin
+
+-- Bumped every time the definition of the job changes, so that the running
+-- schedulers reload it
+ALTER TABLE jobs ADD COLUMN "version" INTEGER NOT NULL DEFAULT 0;
+
+-- +migrate StatementEnd
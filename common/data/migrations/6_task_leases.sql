@@ -0,0 +1,15 @@
+-- +migrate Down
+-- +migrate StatementBegin
+
+DROP INDEX IF EXISTS tasks_probe_id_state_idx;
+ALTER TABLE tasks DROP COLUMN IF EXISTS "lease_expiry";
+
+-- +migrate StatementEnd
+
+-- +migrate Up
+-- +migrate StatementBegin
+
+ALTER TABLE tasks ADD COLUMN "lease_expiry" TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS tasks_probe_id_state_idx ON tasks (probe_id, state);
+
+-- +migrate StatementEnd
@@ -0,0 +1,15 @@
+-- +migrate Down
+-- Values cannot be removed from an enum type, so cancelled tasks are
+-- marked as rejected instead.
+-- +migrate StatementBegin
+
+UPDATE tasks SET state = 'rejected' WHERE state = 'cancelled';
+
+-- +migrate StatementEnd
+
+-- +migrate Up notransaction
+-- +migrate StatementBegin
+
+ALTER TYPE TASK_STATE ADD VALUE IF NOT EXISTS 'cancelled';
+
+-- +migrate StatementEnd
@@ -0,0 +1,13 @@
+-- +migrate Down
+-- +migrate StatementBegin
+
+DROP INDEX IF EXISTS tasks_job_id_creation_time_idx;
+
+-- +migrate StatementEnd
+
+-- +migrate Up
+-- +migrate StatementBegin
+
+CREATE INDEX IF NOT EXISTS tasks_job_id_creation_time_idx ON tasks (job_id, creation_time, id);
+
+-- +migrate StatementEnd
@@ -0,0 +1,13 @@
+-- +migrate Down
+-- +migrate StatementBegin
+
+ALTER TABLE jobs DROP COLUMN IF EXISTS "end_time";
+
+-- +migrate StatementEnd
+
+-- +migrate Up
+-- +migrate StatementBegin
+
+ALTER TABLE jobs ADD COLUMN "end_time" TIMESTAMP WITH TIME ZONE;
+
+-- +migrate StatementEnd
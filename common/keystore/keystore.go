@@ -30,6 +30,9 @@ var (
 	yubikeyKeymode = KeymodeTouch | KeymodePinAlways
 )
 
+// KeyLabel is the label of the private key imported with AddKey
+const KeyLabel = "orchestrate-key"
+
 // SetupHSM will initialize a pkcs11 context based on the path to the pkcs11
 // library
 func SetupHSM(libPath string) (*pkcs11.Ctx, pkcs11.SessionHandle, error) {
@@ -158,22 +161,6 @@ func AddKey(libPath string, privKey *rsa.PrivateKey, certBytes []byte) error {
 	defer ctx.Logout(session)
 	// XXX check if the key is already on the token
 
-	privTemplate := []*pkcs11.Attribute{
-		// Taken from: http://docs.oasis-open.org/pkcs11/pkcs11-base/v2.40/os/pkcs11-base-v2.40-os.html#_toc416959720
-		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
-		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
-		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
-		pkcs11.NewAttribute(pkcs11.CKA_LABEL, []byte("orchestrate-key")),
-
-		pkcs11.NewAttribute(pkcs11.CKA_ID, keyID),
-		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, big.NewInt(int64(privKey.PublicKey.E)).Bytes()), // XXX this is a big ghetto
-		pkcs11.NewAttribute(pkcs11.CKA_PRIME_1, privKey.Primes[0].Bytes()),
-		pkcs11.NewAttribute(pkcs11.CKA_PRIME_2, privKey.Primes[1].Bytes()),
-		pkcs11.NewAttribute(pkcs11.CKA_EXPONENT_1, privKey.Precomputed.Dp.Bytes()),
-		pkcs11.NewAttribute(pkcs11.CKA_EXPONENT_2, privKey.Precomputed.Dq.Bytes()),
-		pkcs11.NewAttribute(pkcs11.CKA_COEFFICIENT, privKey.Precomputed.Qinv.Bytes()),
-		pkcs11.NewAttribute(pkcs11.CKA_VENDOR_DEFINED, yubikeyKeymode),
-	}
 	certTemplate := []*pkcs11.Attribute{
 		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
 		pkcs11.NewAttribute(pkcs11.CKA_VALUE, certBytes),
@@ -184,59 +171,41 @@ func AddKey(libPath string, privKey *rsa.PrivateKey, certBytes []byte) error {
 		return fmt.Errorf("error importing: %v", err)
 	}
 
-	_, err = ctx.CreateObject(session, privTemplate)
-	if err != nil {
-		return fmt.Errorf("error importing key: %v", err)
-	}
-	return nil
+	_, err = importKey(ctx, session, KeyLabel, keyID, privKey,
+		pkcs11.NewAttribute(pkcs11.CKA_VENDOR_DEFINED, yubikeyKeymode))
+	return err
 }
 
-// ListKeys will list all the keys on the device
-func ListKeys(libPath string) error {
-	fmt.Println("Listing keys")
-	ctx, session, err := SetupHSM(libPath)
-	if err != nil {
-		return err
-	}
-	defer ctx.Destroy()
-	defer ctx.Finalize()
-	defer ctx.CloseSession(session)
-
-	if err = LoginPrompt(ctx, session, pkcs11.CKU_SO); err != nil {
-		return err
-	}
-
-	findTemplate := []*pkcs11.Attribute{
+// importKey creates the private key object on the token. It can only be
+// used to sign.
+func importKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, label string, keyID interface{},
+	privKey *rsa.PrivateKey, extra ...*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	privKey.Precompute()
+	privTemplate := []*pkcs11.Attribute{
+		// Taken from: http://docs.oasis-open.org/pkcs11/pkcs11-base/v2.40/os/pkcs11-base-v2.40-os.html#_toc416959720
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
 		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
-	}
-	attrTemplate := []*pkcs11.Attribute{
-		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, []byte{0}),
-	}
-
-	if err = ctx.FindObjectsInit(session, findTemplate); err != nil {
-		return err
-	}
-	objs, _, err := ctx.FindObjects(session, 100)
-	if err != nil {
-		return err
-	}
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, []byte(label)),
 
-	if err = ctx.FindObjectsFinal(session); err != nil {
-		return err
-	}
-	fmt.Printf("Len: %d\n", len(objs))
-	if len(objs) != 1 {
-		fmt.Printf("Len: %d\n", len(objs))
+		pkcs11.NewAttribute(pkcs11.CKA_ID, keyID),
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, privKey.N.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, big.NewInt(int64(privKey.PublicKey.E)).Bytes()), // XXX this is a big ghetto
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE_EXPONENT, privKey.D.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIME_1, privKey.Primes[0].Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIME_2, privKey.Primes[1].Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_EXPONENT_1, privKey.Precomputed.Dp.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_EXPONENT_2, privKey.Precomputed.Dq.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_COEFFICIENT, privKey.Precomputed.Qinv.Bytes()),
 	}
-
-	attr, err := ctx.GetAttributeValue(session, objs[0], attrTemplate)
+	privTemplate = append(privTemplate, extra...)
+	obj, err := ctx.CreateObject(session, privTemplate)
 	if err != nil {
-		fmt.Printf("Failed to get Attribute for: %v (%v)\n", objs[0], err)
-		return err
-	}
-	for _, a := range attr {
-		fmt.Printf("%v: %v\n", a.Type, a.Value)
+		return obj, fmt.Errorf("error importing key: %v", err)
 	}
-
-	return nil
+	return obj, nil
 }
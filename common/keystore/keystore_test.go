@@ -0,0 +1,133 @@
+package keystore
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hellais/jwt-go"
+	"github.com/ooni/orchestra/common/middleware"
+)
+
+const testPIN = "123456"
+
+// setupSoftHSM initialises a token in a temporary SoftHSM store. The tests
+// are skipped unless SOFTHSM_LIB is the path of libsofthsm2.so.
+func setupSoftHSM(t *testing.T) string {
+	libPath := os.Getenv("SOFTHSM_LIB")
+	if libPath == "" {
+		t.Skip("SOFTHSM_LIB is not set")
+	}
+	dir, err := ioutil.TempDir("", "softhsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenDir := filepath.Join(dir, "tokens")
+	if err = os.Mkdir(tokenDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	conf := filepath.Join(dir, "softhsm2.conf")
+	err = ioutil.WriteFile(conf,
+		[]byte(fmt.Sprintf("directories.tokendir = %s\nobjectstore.backend = file\n", tokenDir)),
+		0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("SOFTHSM2_CONF", conf)
+	if err = InitToken(libPath, "orchestra-test", "so-"+testPIN, testPIN); err != nil {
+		t.Fatal(err)
+	}
+	return libPath
+}
+
+func TestKeyLifecycle(t *testing.T) {
+	libPath := setupSoftHSM(t)
+
+	session, err := Open(libPath, testPIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	if _, err = session.GenerateKey("generated", 2048); err != nil {
+		t.Fatal(err)
+	}
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = session.ImportKey("imported", privKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = session.ImportKey("imported", privKey); err != ErrKeyExists {
+		t.Errorf("expected ErrKeyExists, got %v", err)
+	}
+	pub, err := session.PublicKey("imported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub.N.Cmp(privKey.N) != 0 || pub.E != privKey.E {
+		t.Error("the public key does not match the imported key")
+	}
+
+	keys, err := session.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Bits != 2048 {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	if err = session.DeleteKey("generated"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = session.PublicKey("generated"); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+	keys, err = session.ListKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Label != "imported" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
+
+func TestSignerJWT(t *testing.T) {
+	libPath := setupSoftHSM(t)
+
+	session, err := Open(libPath, testPIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := session.GenerateKey(KeyLabel, 2048)
+	session.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewSigner(libPath, KeyLabel, testPIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signer.Close()
+
+	mw := &middleware.GinJWTMiddleware{Realm: "test", SigningKey: signer}
+	if err = mw.MiddlewareInit(); err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Parse(mw.TokenGenerator("device", "someone"),
+		func(token *jwt.Token) (interface{}, error) {
+			return pub, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Method.Alg() != "RS256" || token.Header["kid"] != mw.KeyID {
+		t.Errorf("unexpected header %v", token.Header)
+	}
+}
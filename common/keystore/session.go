@@ -0,0 +1,348 @@
+package keystore
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+)
+
+// ErrKeyNotFound there is no RSA private key with the label on the token
+var ErrKeyNotFound = errors.New("key not found")
+
+// ErrKeyExists a key with the same label is already on the token
+var ErrKeyExists = errors.New("a key with this label already exists")
+
+// ErrNoToken there is no initialised token in the slots of the library
+var ErrNoToken = errors.New("no initialised token found")
+
+// KeyInfo describes a private key on the token
+type KeyInfo struct {
+	Label string
+	ID    string
+	Bits  int
+}
+
+// Session is a session logged in as the user on the first initialised
+// token of a PKCS#11 library
+type Session struct {
+	ctx    *pkcs11.Ctx
+	handle pkcs11.SessionHandle
+}
+
+// loadLibrary initialises the PKCS#11 library and returns the slots with a
+// token, initialised or not
+func loadLibrary(libPath string) (*pkcs11.Ctx, []uint, error) {
+	if libPath == "" {
+		return nil, nil, errors.New("libPath is empty")
+	}
+	p := pkcs11.New(libPath)
+	if p == nil {
+		return nil, nil, fmt.Errorf("failed to load library %s", libPath)
+	}
+	if err := p.Initialize(); err != nil {
+		p.Destroy()
+		return nil, nil, fmt.Errorf("found library %s, but initialize error %s", libPath, err.Error())
+	}
+	slots, err := p.GetSlotList(true)
+	if err != nil {
+		p.Finalize()
+		p.Destroy()
+		return nil, nil, fmt.Errorf("loaded library %s, failed to list slots %s", libPath, err)
+	}
+	return p, slots, nil
+}
+
+// findSlot returns the first slot whose token is initialised or not
+func findSlot(p *pkcs11.Ctx, slots []uint, initialised bool) (uint, bool) {
+	for _, slot := range slots {
+		info, err := p.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if (info.Flags&pkcs11.CKF_TOKEN_INITIALIZED != 0) == initialised {
+			return slot, true
+		}
+	}
+	return 0, false
+}
+
+// InitToken initialises the first token that is not initialised yet with
+// the label and the SO pin, and sets its user pin
+func InitToken(libPath string, label string, soPIN string, userPIN string) error {
+	p, slots, err := loadLibrary(libPath)
+	if err != nil {
+		return err
+	}
+	defer p.Destroy()
+	defer p.Finalize()
+
+	slot, ok := findSlot(p, slots, false)
+	if !ok {
+		return errors.New("no uninitialised token found")
+	}
+	if err = p.InitToken(slot, soPIN, label); err != nil {
+		return fmt.Errorf("failed to initialise the token: %v", err)
+	}
+	session, err := p.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return fmt.Errorf("failed to open session: %v", err)
+	}
+	defer p.CloseSession(session)
+	if err = p.Login(session, pkcs11.CKU_SO, soPIN); err != nil {
+		return fmt.Errorf("failed to login as SO: %v", err)
+	}
+	defer p.Logout(session)
+	if err = p.InitPIN(session, userPIN); err != nil {
+		return fmt.Errorf("failed to set the user pin: %v", err)
+	}
+	return nil
+}
+
+// Open logs in as the user on the first initialised token. When pin is
+// empty it is asked on the terminal.
+func Open(libPath string, pin string) (*Session, error) {
+	p, slots, err := loadLibrary(libPath)
+	if err != nil {
+		return nil, err
+	}
+	slot, ok := findSlot(p, slots, true)
+	if !ok {
+		p.Finalize()
+		p.Destroy()
+		return nil, ErrNoToken
+	}
+	handle, err := p.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		p.Finalize()
+		p.Destroy()
+		return nil, fmt.Errorf("loaded library, but failed to start session %s", err)
+	}
+	s := &Session{ctx: p, handle: handle}
+	if pin == "" {
+		err = LoginPrompt(p, handle, pkcs11.CKU_USER)
+	} else {
+		err = p.Login(handle, pkcs11.CKU_USER, pin)
+	}
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to login: %v", err)
+	}
+	return s, nil
+}
+
+// Close logs out and releases the PKCS#11 library
+func (s *Session) Close() {
+	s.ctx.Logout(s.handle)
+	s.ctx.CloseSession(s.handle)
+	s.ctx.Finalize()
+	s.ctx.Destroy()
+}
+
+// findObjects returns the objects matching the template
+func (s *Session) findObjects(template []*pkcs11.Attribute) ([]pkcs11.ObjectHandle, error) {
+	if err := s.ctx.FindObjectsInit(s.handle, template); err != nil {
+		return nil, err
+	}
+	var objs []pkcs11.ObjectHandle
+	for {
+		found, _, err := s.ctx.FindObjects(s.handle, 100)
+		if err != nil {
+			s.ctx.FindObjectsFinal(s.handle)
+			return nil, err
+		}
+		if len(found) == 0 {
+			break
+		}
+		objs = append(objs, found...)
+	}
+	return objs, s.ctx.FindObjectsFinal(s.handle)
+}
+
+// privateKeyTemplate matches the RSA private keys, with the label if set
+func privateKeyTemplate(label string) []*pkcs11.Attribute {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+	}
+	if label != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, []byte(label)))
+	}
+	return template
+}
+
+// findKey returns the RSA private key with the label and its public key
+func (s *Session) findKey(label string) (pkcs11.ObjectHandle, *rsa.PublicKey, error) {
+	objs, err := s.findObjects(privateKeyTemplate(label))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(objs) == 0 {
+		return 0, nil, ErrKeyNotFound
+	}
+	if len(objs) > 1 {
+		return 0, nil, fmt.Errorf("found %d RSA private keys with label %s", len(objs), label)
+	}
+	pub, err := s.publicKey(objs[0])
+	return objs[0], pub, err
+}
+
+// publicKey reads the public part of the RSA private key
+func (s *Session) publicKey(obj pkcs11.ObjectHandle) (*rsa.PublicKey, error) {
+	attrs, err := s.ctx.GetAttributeValue(s.handle, obj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the public key: %v", err)
+	}
+	pub := &rsa.PublicKey{N: new(big.Int)}
+	for _, a := range attrs {
+		switch a.Type {
+		case pkcs11.CKA_MODULUS:
+			pub.N.SetBytes(a.Value)
+		case pkcs11.CKA_PUBLIC_EXPONENT:
+			pub.E = int(new(big.Int).SetBytes(a.Value).Int64())
+		}
+	}
+	if pub.N.Sign() == 0 || pub.E == 0 {
+		return nil, errors.New("the private key has no public modulus or exponent")
+	}
+	return pub, nil
+}
+
+// PublicKey returns the public key of the RSA private key with the label
+func (s *Session) PublicKey(label string) (*rsa.PublicKey, error) {
+	_, pub, err := s.findKey(label)
+	return pub, err
+}
+
+// checkLabel fails if there already is an object with the label
+func (s *Session) checkLabel(label string) error {
+	if label == "" {
+		return errors.New("the label is empty")
+	}
+	objs, err := s.findObjects([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, []byte(label)),
+	})
+	if err != nil {
+		return err
+	}
+	if len(objs) > 0 {
+		return ErrKeyExists
+	}
+	return nil
+}
+
+// GenerateKey generates an RSA key pair inside the token. The private key
+// can only be used to sign and cannot be extracted.
+func (s *Session) GenerateKey(label string, bits int) (*rsa.PublicKey, error) {
+	if err := s.checkLabel(label); err != nil {
+		return nil, err
+	}
+	id := []byte(label)
+	pubTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, bits),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, []byte(label)),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}
+	privTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, []byte(label)),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)}
+	_, priv, err := s.ctx.GenerateKeyPair(s.handle, mechanism, pubTemplate, privTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the key: %v", err)
+	}
+	return s.publicKey(priv)
+}
+
+// ImportKey imports an RSA private key in the token
+func (s *Session) ImportKey(label string, key *rsa.PrivateKey) error {
+	if err := s.checkLabel(label); err != nil {
+		return err
+	}
+	_, err := importKey(s.ctx, s.handle, label, []byte(label), key)
+	return err
+}
+
+// ListKeys lists the RSA private keys on the token
+func (s *Session) ListKeys() ([]KeyInfo, error) {
+	keys := []KeyInfo{}
+	objs, err := s.findObjects(privateKeyTemplate(""))
+	if err != nil {
+		return keys, err
+	}
+	for _, obj := range objs {
+		attrs, err := s.ctx.GetAttributeValue(s.handle, obj, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		})
+		if err != nil {
+			return keys, err
+		}
+		var k KeyInfo
+		for _, a := range attrs {
+			switch a.Type {
+			case pkcs11.CKA_LABEL:
+				k.Label = string(a.Value)
+			case pkcs11.CKA_ID:
+				k.ID = fmt.Sprintf("%x", a.Value)
+			case pkcs11.CKA_MODULUS:
+				k.Bits = new(big.Int).SetBytes(a.Value).BitLen()
+			}
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// DeleteKey deletes the private key with the label and the public key and
+// certificate with the same ID
+func (s *Session) DeleteKey(label string) error {
+	priv, _, err := s.findKey(label)
+	if err != nil {
+		return err
+	}
+	attrs, err := s.ctx.GetAttributeValue(s.handle, priv, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+	})
+	if err != nil {
+		return err
+	}
+	objs := []pkcs11.ObjectHandle{priv}
+	if len(attrs) == 1 && len(attrs[0].Value) > 0 {
+		for _, class := range []uint{pkcs11.CKO_PUBLIC_KEY, pkcs11.CKO_CERTIFICATE} {
+			found, err := s.findObjects([]*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+				pkcs11.NewAttribute(pkcs11.CKA_ID, attrs[0].Value),
+			})
+			if err != nil {
+				return err
+			}
+			objs = append(objs, found...)
+		}
+	}
+	for _, obj := range objs {
+		if err = s.ctx.DestroyObject(s.handle, obj); err != nil {
+			return fmt.Errorf("failed to delete the key: %v", err)
+		}
+	}
+	return nil
+}
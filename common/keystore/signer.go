@@ -0,0 +1,78 @@
+package keystore
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// digestInfoPrefixes are the DER encoded DigestInfo headers that CKM_RSA_PKCS
+// expects in front of the digest
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// Signer is a crypto.Signer using an RSA private key that never leaves the
+// PKCS#11 token. It keeps a logged in session open until Close is called.
+type Signer struct {
+	session *Session
+	key     pkcs11.ObjectHandle
+	pub     *rsa.PublicKey
+	// PKCS#11 sessions cannot be used by more than one thread at a time
+	lock sync.Mutex
+}
+
+// NewSigner logs in on the first initialised token and returns the signer
+// for the RSA private key with the label. When pin is empty the user pin is
+// asked on the terminal.
+func NewSigner(libPath string, label string, pin string) (*Signer, error) {
+	session, err := Open(libPath, pin)
+	if err != nil {
+		return nil, err
+	}
+	key, pub, err := session.findKey(label)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return &Signer{session: session, key: key, pub: pub}, nil
+}
+
+// Public returns the public key of the signer
+func (s *Signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign signs the digest with RSASSA-PKCS1-v1_5. PSS is not supported.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, errors.New("PSS signatures are not supported")
+	}
+	prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash function %v", opts.HashFunc())
+	}
+	if len(digest) != opts.HashFunc().Size() {
+		return nil, errors.New("the digest has the wrong length")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)}
+	if err := s.session.ctx.SignInit(s.session.handle, mechanism, s.key); err != nil {
+		return nil, err
+	}
+	return s.session.ctx.Sign(s.session.handle, append(append([]byte{}, prefix...), digest...))
+}
+
+// Close closes the session of the signer
+func (s *Signer) Close() {
+	s.session.Close()
+}
@@ -0,0 +1,242 @@
+package middleware
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	common "github.com/ooni/orchestra/common"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AccountRoles are the roles of the accounts managed with the accounts API.
+// Device accounts are created when probes register.
+var AccountRoles = []string{"admin", "operator", "viewer"}
+
+// MinPasswordLength is the minimum length of the password of the accounts
+// managed with the accounts API
+const MinPasswordLength = 12
+
+// AccountInfo is what the accounts API returns about an account
+type AccountInfo struct {
+	Username   string     `json:"username"`
+	Role       string     `json:"role"`
+	Disabled   bool       `json:"disabled"`
+	LastAccess *time.Time `json:"last_access"`
+}
+
+// ErrAccountNotFound there is no managed account with the given username
+var ErrAccountNotFound = errors.New("account not found")
+
+// ErrAccountExists an account with the same username already exists
+var ErrAccountExists = errors.New("account already exists")
+
+// ErrInvalidRole the role is not one of AccountRoles
+var ErrInvalidRole = errors.New("invalid role")
+
+// ErrInvalidUsername the username is empty or reserved
+var ErrInvalidUsername = errors.New("invalid username")
+
+// ErrWeakPassword the password is shorter than MinPasswordLength
+var ErrWeakPassword = fmt.Errorf("the password must be at least %d characters long",
+	MinPasswordLength)
+
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrWeakPassword
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		ctx.WithError(err).Error("failed to hash password")
+		return "", err
+	}
+	return string(passwordHash), nil
+}
+
+// CreateAccount adds an account with the given role
+func CreateAccount(db *sqlx.DB, username string, password string, role string) error {
+	// admin is the account configured with auth.admin-password
+	if username == "" || username == "admin" {
+		return ErrInvalidUsername
+	}
+	valid := false
+	for _, r := range AccountRoles {
+		if role == r {
+			valid = true
+		}
+	}
+	if !valid {
+		return ErrInvalidRole
+	}
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`INSERT INTO %s (
+		username,
+		password_hash,
+		role
+	) SELECT $1, $2, $3
+	WHERE NOT EXISTS (SELECT 1 FROM %s WHERE username = $1)`,
+		pq.QuoteIdentifier(common.AccountsTable),
+		pq.QuoteIdentifier(common.AccountsTable))
+	res, err := db.Exec(query, username, passwordHash, role)
+	if err != nil {
+		ctx.WithError(err).Error("failed to insert account")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return err
+	}
+	if n == 0 {
+		return ErrAccountExists
+	}
+	return nil
+}
+
+// ListAccounts lists the accounts that are not device accounts
+func ListAccounts(db *sqlx.DB) ([]AccountInfo, error) {
+	accounts := []AccountInfo{}
+	query := fmt.Sprintf(`SELECT
+		username, role,
+		disabled,
+		last_access
+		FROM %s
+		WHERE role = ANY($1)
+		ORDER BY username`,
+		pq.QuoteIdentifier(common.AccountsTable))
+	rows, err := db.Query(query, pq.StringArray(AccountRoles))
+	if err != nil {
+		ctx.WithError(err).Error("failed to list accounts")
+		return accounts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			a          AccountInfo
+			lastAccess pq.NullTime
+		)
+		err = rows.Scan(&a.Username, &a.Role, &a.Disabled, &lastAccess)
+		if err != nil {
+			ctx.WithError(err).Error("failed to iterate over accounts")
+			return accounts, err
+		}
+		if lastAccess.Valid {
+			a.LastAccess = &lastAccess.Time
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, nil
+}
+
+// updateAccount runs the update on the managed account with the username
+func updateAccount(db *sqlx.DB, username string, set string, args ...interface{}) error {
+	query := fmt.Sprintf(`UPDATE %s SET %s
+		WHERE username = $1 AND role = ANY($2)`,
+		pq.QuoteIdentifier(common.AccountsTable), set)
+	args = append([]interface{}{username, pq.StringArray(AccountRoles)}, args...)
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		ctx.WithError(err).Error("failed to update account")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return err
+	}
+	if n == 0 {
+		return ErrAccountNotFound
+	}
+	return nil
+}
+
+// SetAccountDisabled disables or enables the account. Disabled accounts
+// cannot login and their tokens are revoked.
+func SetAccountDisabled(db *sqlx.DB, username string, disabled bool) error {
+	err := updateAccount(db, username, "disabled = $3", disabled)
+	if err != nil || !disabled {
+		return err
+	}
+	return RevokeUserTokens(db, username)
+}
+
+// SetAccountPassword replaces the password of the account and revokes its
+// tokens, which may have been obtained with the old password
+func SetAccountPassword(db *sqlx.DB, username string, password string) error {
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	err = updateAccount(db, username, "password_hash = $3", passwordHash)
+	if err != nil {
+		return err
+	}
+	return RevokeUserTokens(db, username)
+}
+
+// DeleteAccount deletes the account and revokes its tokens
+func DeleteAccount(db *sqlx.DB, username string) error {
+	query := fmt.Sprintf(`DELETE FROM %s
+		WHERE username = $1 AND role = ANY($2)`,
+		pq.QuoteIdentifier(common.AccountsTable))
+	res, err := db.Exec(query, username, pq.StringArray(AccountRoles))
+	if err != nil {
+		ctx.WithError(err).Error("failed to delete account")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return err
+	}
+	if n == 0 {
+		return ErrAccountNotFound
+	}
+	return RevokeUserTokens(db, username)
+}
+
+// checkAccountPassword returns the account if the password matches and the
+// account is not disabled. It updates the last access time of the account.
+func checkAccountPassword(db *sqlx.DB, username string, password string) (Account, bool) {
+	var (
+		passwordHash string
+		disabled     bool
+	)
+	account := Account{Username: username}
+	query := fmt.Sprintf(`SELECT
+		password_hash, role,
+		disabled
+		FROM %s WHERE username = $1`,
+		pq.QuoteIdentifier(common.AccountsTable))
+	err := db.QueryRow(query, username).Scan(
+		&passwordHash,
+		&account.Role,
+		&disabled)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			ctx.WithError(err).Error("failed to lookup account")
+		}
+		return account, false
+	}
+	if disabled {
+		return account, false
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password))
+	if err != nil {
+		return account, false
+	}
+
+	query = fmt.Sprintf(`UPDATE %s SET last_access = $2 WHERE username = $1`,
+		pq.QuoteIdentifier(common.AccountsTable))
+	_, err = db.Exec(query, username, time.Now().UTC())
+	if err != nil {
+		ctx.WithError(err).Error("failed to update last access")
+	}
+	return account, true
+}
@@ -0,0 +1,221 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	common "github.com/ooni/orchestra/common"
+)
+
+// AuditEntry is a row of the append only audit log
+type AuditEntry struct {
+	ID    int64     `json:"id"`
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor"`
+	Role  string    `json:"role"`
+	// Action is either the name of the action, like job.create, or the
+	// method and path of the request
+	Action   string `json:"action"`
+	TargetID string `json:"target_id"`
+	// BodyDigest is the hex encoded SHA256 of the request body
+	BodyDigest string `json:"body_digest"`
+	SourceIP   string `json:"source_ip"`
+}
+
+// BodyDigest returns the digest stored in the audit log for a request body
+func BodyDigest(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// WriteAuditEntry appends the entry to the audit log
+func WriteAuditEntry(db *sqlx.DB, e AuditEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	query := fmt.Sprintf(`INSERT INTO %s (
+		time, actor, role,
+		action, target_id,
+		body_digest, source_ip
+	) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		pq.QuoteIdentifier(common.AuditLogTable))
+	_, err := db.Exec(query, e.Time, e.Actor, e.Role,
+		e.Action, e.TargetID,
+		e.BodyDigest, e.SourceIP)
+	if err != nil {
+		ctx.WithError(err).Errorf("failed to write audit entry %s", e.Action)
+		return err
+	}
+	return nil
+}
+
+// AuditQuery is the query for the audit log listing
+type AuditQuery struct {
+	Limit    int64  `form:"limit" binding:"max=1000"`
+	Actor    string `form:"actor"`
+	Action   string `form:"action"`
+	TargetID string `form:"target_id"`
+	// Since and Until bound the time of the entries
+	Since time.Time `form:"-"`
+	Until time.Time `form:"-"`
+	// Before only returns the entries older than the entry with this ID
+	Before int64 `form:"before"`
+}
+
+// ListAuditEntries lists the audit entries matching the query, the most
+// recent first
+func ListAuditEntries(db *sqlx.DB, q AuditQuery) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+
+	var (
+		where []string
+		args  []interface{}
+	)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if q.Actor != "" {
+		add("actor = $%d", q.Actor)
+	}
+	if q.Action != "" {
+		add("action = $%d", q.Action)
+	}
+	if q.TargetID != "" {
+		add("target_id = $%d", q.TargetID)
+	}
+	if !q.Since.IsZero() {
+		add("time >= $%d", q.Since.UTC())
+	}
+	if !q.Until.IsZero() {
+		add("time < $%d", q.Until.UTC())
+	}
+	if q.Before > 0 {
+		add("id < $%d", q.Before)
+	}
+	query := fmt.Sprintf(`SELECT
+		id, time,
+		COALESCE(actor, ''),
+		COALESCE(role, ''),
+		action,
+		COALESCE(target_id, ''),
+		COALESCE(body_digest, ''),
+		COALESCE(source_ip, '')
+		FROM %s`,
+		pq.QuoteIdentifier(common.AuditLogTable))
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, q.Limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		ctx.WithError(err).Error("failed to list audit entries")
+		return entries, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e AuditEntry
+		err = rows.Scan(&e.ID, &e.Time, &e.Actor, &e.Role,
+			&e.Action, &e.TargetID,
+			&e.BodyDigest, &e.SourceIP)
+		if err != nil {
+			ctx.WithError(err).Error("failed to iterate over audit entries")
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// isReadOnlyMethod returns true for the requests the audit middleware does
+// not record
+func isReadOnlyMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// AuditMiddleware records the successful requests that change something in
+// the audit log. It has to run after the authentication middleware.
+// Handlers that write a more specific entry, for example through AddJob,
+// call MarkAudited so that the request is not recorded twice.
+func AuditMiddleware(db *sqlx.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isReadOnlyMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(c.Request.Body)
+			if err != nil {
+				ctx.WithError(err).Error("failed to read the request body")
+			}
+			c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		entry := AuditEntry{
+			Actor:      c.GetString("userID"),
+			Role:       c.GetString("role"),
+			BodyDigest: BodyDigest(body),
+			SourceIP:   c.ClientIP(),
+		}
+		c.Set("auditEntry", entry)
+
+		c.Next()
+
+		if c.GetBool("audited") || c.Writer.Status() >= 400 {
+			return
+		}
+		entry.Action = fmt.Sprintf("%s %s", c.Request.Method, c.Request.URL.Path)
+		if len(c.Params) > 0 {
+			entry.TargetID = c.Params[0].Value
+		}
+		WriteAuditEntry(db, entry)
+	}
+}
+
+// RequestAuditEntry returns the audit entry for the request, with the
+// actor, role, body digest and source IP filled in
+func RequestAuditEntry(c *gin.Context) AuditEntry {
+	if entry, ok := c.Get("auditEntry"); ok {
+		return entry.(AuditEntry)
+	}
+	return AuditEntry{
+		Actor:    c.GetString("userID"),
+		Role:     c.GetString("role"),
+		SourceIP: c.ClientIP(),
+	}
+}
+
+// MarkAudited tells the audit middleware that the handler already wrote the
+// audit entry of the request
+func MarkAudited(c *gin.Context) {
+	c.Set("audited", true)
+}
+
+// CommandAuditEntry returns the audit entry for the actions run from the
+// command line. The actor is the local user.
+func CommandAuditEntry() AuditEntry {
+	entry := AuditEntry{Role: "cli"}
+	if u, err := user.Current(); err == nil {
+		entry.Actor = u.Username
+	}
+	return entry
+}
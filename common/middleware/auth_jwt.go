@@ -1,21 +1,20 @@
 package middleware
 
 import (
+	"crypto"
 	"crypto/subtle"
-	"database/sql"
 	"errors"
-	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/hellais/jwt-go"
 	"github.com/jmoiron/sqlx"
-	"github.com/lib/pq"
-	common "github.com/ooni/orchestra/common"
+	"github.com/satori/go.uuid"
 	"github.com/spf13/viper"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // This is taken from:
@@ -26,9 +25,15 @@ import (
 type OrchestraClaims struct {
 	Role string `json:"role"`
 	User string `json:"user"`
+	// Type is accessTokenType for the tokens issued with refresh tokens
+	// and empty for the legacy tokens that are refreshed by themselves
+	Type string `json:"typ,omitempty"`
 	jwt.StandardClaims
 }
 
+// accessTokenType is the Type of the tokens issued with refresh tokens
+const accessTokenType = "access"
+
 // Account is the details of the account
 type Account struct {
 	Username string
@@ -44,20 +49,54 @@ type GinJWTMiddleware struct {
 	// Realm name to display to the user. Required.
 	Realm string
 
-	// signing algorithm - possible values are HS256, HS384, HS512
-	// Optional, default is HS256.
+	// signing algorithm - possible values are HS256, HS384, HS512 and, when
+	// SigningKey is set, the RS and ES algorithm matching the key.
+	// Optional, default is HS256 or the algorithm of SigningKey.
 	SigningAlgorithm string
 
-	// Secret key used for signing. Required.
+	// Secret key used for signing and verifying the HS tokens. Required
+	// unless SigningKey, VerificationKeys or JWKSURL is set.
 	Key []byte
 
+	// SigningKey is the RSA or EC private key used to sign the tokens
+	// instead of Key. It can be a key stored in an HSM. Optional, services
+	// that only verify tokens leave it nil.
+	SigningKey crypto.Signer
+
+	// KeyID is the kid header of the tokens signed with SigningKey.
+	// Optional, defaults to the JWK thumbprint of the key.
+	KeyID string
+
+	// VerificationKeys are the public keys accepted for the RS and ES tokens
+	// by key ID, for example the keys of the previous signing keys. The
+	// public key of SigningKey is always accepted.
+	VerificationKeys map[string]crypto.PublicKey
+
+	// JWKSURL is fetched when a token has an unknown key ID, to verify the
+	// tokens signed by another service. Optional.
+	JWKSURL string
+
+	keysLock    sync.Mutex
+	remoteKeys  map[string]crypto.PublicKey
+	jwksFetched time.Time
+
 	// Duration that a jwt token is valid. Optional, defaults to one hour.
 	Timeout time.Duration
 
-	// This field allows clients to refresh their token until MaxRefresh has passed.
-	// Note that clients can refresh their token in the last moment of MaxRefresh.
-	// This means that the maximum validity timespan for a token is MaxRefresh + Timeout.
-	// Optional, defaults to 0 meaning not refreshable.
+	// RoleTimeouts overrides Timeout for the tokens of some roles. These
+	// roles are not issued refresh tokens: their clients login again when
+	// the token expires, like the probes do. Optional.
+	RoleTimeouts map[string]time.Duration
+
+	// RefreshTokens issues the refresh tokens returned by LoginHandler and
+	// rotated by RefreshHandler. Optional, without them clients login again
+	// when the token expires.
+	RefreshTokens *RefreshTokens
+
+	// MaxRefresh is for how long after they were first issued the legacy
+	// tokens, issued before refresh tokens, can still be refreshed by
+	// sending them to RefreshHandler. Optional, defaults to 0 meaning not
+	// refreshable.
 	MaxRefresh time.Duration
 
 	// Callback function that should perform the authentication of the user based on userID and
@@ -89,6 +128,10 @@ type GinJWTMiddleware struct {
 
 	// TimeFunc provides the current time. You can override it to use another time value. This is useful for testing or if your server uses a different time zone than your tokens.
 	TimeFunc func() time.Time
+
+	// Revocations are checked for every token. Optional, when nil tokens
+	// are valid until they expire.
+	Revocations *TokenRevocations
 }
 
 // Authorizator structure
@@ -109,6 +152,26 @@ func (mw *GinJWTMiddleware) MiddlewareInit() error {
 		mw.TokenLookup = "header:Authorization"
 	}
 
+	if mw.SigningKey != nil {
+		alg, err := KeyAlgorithm(mw.SigningKey.Public())
+		if err != nil {
+			return err
+		}
+		if mw.SigningAlgorithm == "" {
+			mw.SigningAlgorithm = alg
+		}
+		if mw.SigningAlgorithm != alg {
+			return errors.New("the signing algorithm does not match the signing key")
+		}
+		if mw.KeyID == "" {
+			jwk, err := NewJWK(mw.SigningKey.Public())
+			if err != nil {
+				return err
+			}
+			mw.KeyID = jwk.Kid
+		}
+	}
+
 	if mw.SigningAlgorithm == "" {
 		mw.SigningAlgorithm = "HS256"
 	}
@@ -148,7 +211,8 @@ func (mw *GinJWTMiddleware) MiddlewareInit() error {
 		return errors.New("realm is required")
 	}
 
-	if mw.Key == nil {
+	if len(mw.Key) == 0 && mw.SigningKey == nil &&
+		len(mw.VerificationKeys) == 0 && mw.JWKSURL == "" {
 		return errors.New("secret key is required")
 	}
 
@@ -196,9 +260,25 @@ func (mw *GinJWTMiddleware) middlewareImpl(auth Authorizator, c *gin.Context) {
 
 	claims := token.Claims.(*OrchestraClaims)
 
+	if mw.Revocations != nil {
+		revoked, err := mw.Revocations.IsRevoked(claims)
+		if err != nil {
+			mw.unauthorized(c, http.StatusInternalServerError, "server side error")
+			return
+		}
+		if revoked {
+			mw.unauthorized(c, http.StatusUnauthorized, "token is revoked")
+			return
+		}
+	}
+
 	account := mw.IdentityHandler(claims)
 	c.Set("JWT_PAYLOAD", claims)
 	c.Set("userID", account.Username)
+	c.Set("role", account.Role)
+	if mw.Revocations != nil {
+		c.Set("TokenRevocations", mw.Revocations)
+	}
 
 	if !auth(account, c) {
 		mw.unauthorized(c, http.StatusForbidden, "You don't have permission to access.")
@@ -208,9 +288,39 @@ func (mw *GinJWTMiddleware) middlewareImpl(auth Authorizator, c *gin.Context) {
 	c.Next()
 }
 
+// roleTimeout returns the duration of the tokens of the role and whether
+// the role gets refresh tokens
+func (mw *GinJWTMiddleware) roleTimeout(role string) (time.Duration, bool) {
+	if timeout, ok := mw.RoleTimeouts[role]; ok {
+		return timeout, false
+	}
+	return mw.Timeout, true
+}
+
+// accessToken returns a token for the account. Its jti is the family of
+// the refresh token it was issued with, so that revoking the family
+// revokes the access tokens too.
+func (mw *GinJWTMiddleware) accessToken(account Account, jti string) (string, time.Time, error) {
+	timeout, _ := mw.roleTimeout(account.Role)
+	expire := mw.TimeFunc().Add(timeout)
+	claims := OrchestraClaims{
+		Role: account.Role,
+		User: account.Username,
+		Type: accessTokenType,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			ExpiresAt: expire.Unix(),
+			IssuedAt:  mw.TimeFunc().Unix(),
+		},
+	}
+	tokenString, err := mw.signToken(claims)
+	return tokenString, expire, err
+}
+
 // LoginHandler can be used by clients to get a jwt token.
 // Payload needs to be json in the form of {"username": "USERNAME", "password": "PASSWORD"}.
-// Reply will be of the form {"token": "TOKEN"}.
+// Reply will be of the form {"token": "TOKEN", "refresh_token": "REFRESH_TOKEN"},
+// without refresh_token for the roles in RoleTimeouts.
 func (mw *GinJWTMiddleware) LoginHandler(c *gin.Context) {
 
 	// Initial middleware default setting.
@@ -235,64 +345,139 @@ func (mw *GinJWTMiddleware) LoginHandler(c *gin.Context) {
 		return
 	}
 
-	// Create the token
-	expire := mw.TimeFunc().Add(mw.Timeout)
-	claims := OrchestraClaims{
-		Role: account.Role,
-		User: account.Username,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expire.Unix(),
-			IssuedAt:  mw.TimeFunc().Unix(),
-		},
+	response := gin.H{}
+	jti := uuid.NewV4().String()
+	if _, refresh := mw.roleTimeout(account.Role); refresh && mw.RefreshTokens != nil {
+		refreshToken, err := mw.RefreshTokens.Issue(account)
+		if err != nil {
+			mw.unauthorized(c, http.StatusInternalServerError, "server side error")
+			return
+		}
+		jti = refreshToken.FamilyID
+		response["refresh_token"] = refreshToken.Token
+		response["refresh_expire"] = refreshToken.ExpiresAt.Format(time.RFC3339)
 	}
-	token := jwt.NewWithClaims(jwt.GetSigningMethod(mw.SigningAlgorithm), claims)
-
-	tokenString, err := token.SignedString(mw.Key)
 
+	tokenString, expire, err := mw.accessToken(account, jti)
 	if err != nil {
 		mw.unauthorized(c, http.StatusUnauthorized, "Create JWT Token faild")
 		return
 	}
+	response["token"] = tokenString
+	response["expire"] = expire.Format(time.RFC3339)
+	c.JSON(http.StatusOK, response)
+}
 
+// RefreshRequest is the payload of RefreshHandler
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// RefreshHandler exchanges a refresh token for a new access token and a new
+// refresh token. The refresh token can only be used once: using it again
+// revokes all the tokens issued with it.
+// Payload needs to be json in the form of {"refresh_token": "REFRESH_TOKEN"}.
+// Reply will be of the form {"token": "TOKEN", "refresh_token": "REFRESH_TOKEN"}.
+// Requests without a refresh token are handled by legacyRefresh.
+func (mw *GinJWTMiddleware) RefreshHandler(c *gin.Context) {
+	var req RefreshRequest
+
+	if binding.JSON.Bind(c.Request, &req) != nil {
+		mw.legacyRefresh(c)
+		return
+	}
+	if mw.RefreshTokens == nil {
+		mw.unauthorized(c, http.StatusInternalServerError, "refresh tokens are not configured")
+		return
+	}
+
+	refreshToken, account, err := mw.RefreshTokens.Rotate(req.RefreshToken)
+	if err == ErrRefreshTokenReused {
+		if mw.Revocations != nil {
+			claims := &OrchestraClaims{
+				User:           account.Username,
+				StandardClaims: jwt.StandardClaims{Id: refreshToken.FamilyID},
+			}
+			timeout, _ := mw.roleTimeout(account.Role)
+			mw.Revocations.RevokeToken(claims, mw.TimeFunc().Add(timeout))
+		}
+		mw.unauthorized(c, http.StatusUnauthorized, ErrInvalidRefreshToken.Error())
+		return
+	}
+	if err == ErrInvalidRefreshToken {
+		mw.unauthorized(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		mw.unauthorized(c, http.StatusInternalServerError, "server side error")
+		return
+	}
+
+	tokenString, expire, err := mw.accessToken(account, refreshToken.FamilyID)
+	if err != nil {
+		mw.unauthorized(c, http.StatusUnauthorized, "Create JWT Token faild")
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"token":  tokenString,
-		"expire": expire.Format(time.RFC3339),
+		"token":          tokenString,
+		"expire":         expire.Format(time.RFC3339),
+		"refresh_token":  refreshToken.Token,
+		"refresh_expire": refreshToken.ExpiresAt.Format(time.RFC3339),
 	})
 }
 
-// RefreshHandler can be used to refresh a token. The token still needs to be valid on refresh.
-// Shall be put under an endpoint that is using the GinJWTMiddleware.
+// legacyRefresh refreshes the token sent in the request, as RefreshHandler
+// did before refresh tokens, so that the clients that do not know about
+// refresh tokens keep working with the tokens they already have. Only the
+// legacy tokens are accepted, until MaxRefresh after they were first issued.
 // Reply will be of the form {"token": "TOKEN"}.
-func (mw *GinJWTMiddleware) RefreshHandler(c *gin.Context) {
-	token, _ := mw.parseToken(c)
+func (mw *GinJWTMiddleware) legacyRefresh(c *gin.Context) {
+	token, err := mw.parseToken(c)
+	if err == ErrMissingToken {
+		mw.unauthorized(c, http.StatusBadRequest, "missing-refresh-token")
+		return
+	}
+	if err != nil {
+		mw.unauthorized(c, http.StatusUnauthorized, err.Error())
+		return
+	}
 	claims := token.Claims.(*OrchestraClaims)
 
-	origIat := int64(claims.StandardClaims.IssuedAt)
-
-	if origIat < mw.TimeFunc().Add(-mw.MaxRefresh).Unix() {
+	origIat := claims.IssuedAt
+	if claims.Type != "" || origIat < mw.TimeFunc().Add(-mw.MaxRefresh).Unix() {
 		mw.unauthorized(c, http.StatusUnauthorized, "Token is expired.")
 		return
 	}
+	if mw.Revocations != nil {
+		revoked, err := mw.Revocations.IsRevoked(claims)
+		if err != nil {
+			mw.unauthorized(c, http.StatusInternalServerError, "server side error")
+			return
+		}
+		if revoked {
+			mw.unauthorized(c, http.StatusUnauthorized, "token is revoked")
+			return
+		}
+	}
 
-	// Create the token
-	expire := mw.TimeFunc().Add(mw.Timeout)
+	timeout, _ := mw.roleTimeout(claims.Role)
+	expire := mw.TimeFunc().Add(timeout)
 	newClaims := OrchestraClaims{
 		Role: claims.Role,
 		User: claims.User,
 		StandardClaims: jwt.StandardClaims{
+			// The refreshed token keeps the jti, so that revoking either
+			// token revokes both
+			Id:        claims.Id,
 			ExpiresAt: expire.Unix(),
 			IssuedAt:  origIat,
 		},
 	}
-	newToken := jwt.NewWithClaims(jwt.GetSigningMethod(mw.SigningAlgorithm), newClaims)
-
-	tokenString, err := newToken.SignedString(mw.Key)
-
+	tokenString, err := mw.signToken(newClaims)
 	if err != nil {
 		mw.unauthorized(c, http.StatusUnauthorized, "Create JWT Token faild")
 		return
 	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"token":  tokenString,
 		"expire": expire.Format(time.RFC3339),
@@ -319,13 +504,12 @@ func (mw *GinJWTMiddleware) TokenGenerator(userID string, role string) string {
 		Role: userID,
 		User: role,
 		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewV4().String(),
 			ExpiresAt: mw.TimeFunc().Add(mw.Timeout).Unix(),
 			IssuedAt:  mw.TimeFunc().Unix(),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.GetSigningMethod(mw.SigningAlgorithm), claims)
-
-	tokenString, _ := token.SignedString(mw.Key)
+	tokenString, _ := mw.signToken(claims)
 
 	return tokenString
 }
@@ -386,14 +570,124 @@ func (mw *GinJWTMiddleware) parseToken(c *gin.Context) (*jwt.Token, error) {
 		return nil, err
 	}
 
-	return jwt.ParseWithClaims(token, &OrchestraClaims{},
-		func(token *jwt.Token) (interface{}, error) {
-			if jwt.GetSigningMethod(mw.SigningAlgorithm) != token.Method {
-				return nil, errors.New("invalid signing algorithm")
-			}
+	return jwt.ParseWithClaims(token, &OrchestraClaims{}, mw.verificationKey)
+}
+
+// ErrUnknownKeyID the token is signed with a key that is not known
+var ErrUnknownKeyID = errors.New("unknown key id")
+
+// signToken signs the claims with SigningKey, or with Key when no signing
+// key is configured
+func (mw *GinJWTMiddleware) signToken(claims jwt.Claims) (string, error) {
+	if mw.SigningKey == nil {
+		if len(mw.Key) == 0 {
+			return "", errors.New("no signing key configured")
+		}
+		token := jwt.NewWithClaims(jwt.GetSigningMethod(mw.SigningAlgorithm), claims)
+		return token.SignedString(mw.Key)
+	}
+	token := jwt.NewWithClaims(newSignerMethod(mw.SigningAlgorithm), claims)
+	token.Header["kid"] = mw.KeyID
+	return token.SignedString(mw.SigningKey)
+}
+
+// verificationKey returns the key that verifies the token. HS tokens are
+// verified with Key and the RS and ES tokens with the public key matching
+// their kid header, which must be of the algorithm of the token.
+func (mw *GinJWTMiddleware) verificationKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if len(mw.Key) == 0 {
+			return nil, errors.New("invalid signing algorithm")
+		}
+		return mw.Key, nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, err := mw.publicKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	alg, err := KeyAlgorithm(key)
+	if err != nil || alg != token.Method.Alg() {
+		return nil, errors.New("invalid signing algorithm")
+	}
+	return key, nil
+}
+
+// jwksRefreshInterval is the minimum time between two fetches of JWKSURL
+const jwksRefreshInterval = time.Minute
+
+// publicKey returns the verification key with the key ID, fetching JWKSURL
+// again if the key is not known
+func (mw *GinJWTMiddleware) publicKey(kid string) (crypto.PublicKey, error) {
+	if kid == "" {
+		return nil, ErrUnknownKeyID
+	}
+	if mw.SigningKey != nil && kid == mw.KeyID {
+		return mw.SigningKey.Public(), nil
+	}
+	if key, ok := mw.VerificationKeys[kid]; ok {
+		return key, nil
+	}
+	if mw.JWKSURL == "" {
+		return nil, ErrUnknownKeyID
+	}
+
+	mw.keysLock.Lock()
+	defer mw.keysLock.Unlock()
+	if key, ok := mw.remoteKeys[kid]; ok {
+		return key, nil
+	}
+	if mw.TimeFunc().Sub(mw.jwksFetched) < jwksRefreshInterval {
+		return nil, ErrUnknownKeyID
+	}
+	mw.jwksFetched = mw.TimeFunc()
+	keys, err := FetchJWKS(mw.JWKSURL)
+	if err != nil {
+		ctx.WithError(err).Error("failed to fetch the JWKS")
+		return nil, ErrUnknownKeyID
+	}
+	mw.remoteKeys = keys
+	if key, ok := mw.remoteKeys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKeyID
+}
 
-			return mw.Key, nil
-		})
+// JWKS returns the public keys of the service: the key of SigningKey and
+// the VerificationKeys
+func (mw *GinJWTMiddleware) JWKS() (JWKSet, error) {
+	set := JWKSet{Keys: []JWK{}}
+	if mw.SigningKey != nil {
+		jwk, err := NewJWK(mw.SigningKey.Public())
+		if err != nil {
+			return set, err
+		}
+		jwk.Kid = mw.KeyID
+		set.Keys = append(set.Keys, jwk)
+	}
+	for kid, key := range mw.VerificationKeys {
+		jwk, err := NewJWK(key)
+		if err != nil {
+			return set, err
+		}
+		jwk.Kid = kid
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// JWKSHandler serves the public keys that verify the tokens, to be mounted
+// at /.well-known/jwks.json
+func (mw *GinJWTMiddleware) JWKSHandler(c *gin.Context) {
+	set, err := mw.JWKS()
+	if err != nil {
+		ctx.WithError(err).Error("failed to encode the JWKS")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, set)
 }
 
 func (mw *GinJWTMiddleware) unauthorized(c *gin.Context, code int, message string) {
@@ -410,18 +704,68 @@ func (mw *GinJWTMiddleware) unauthorized(c *gin.Context, code int, message strin
 	return
 }
 
-// InitAuthMiddleware is called to initialise the authentication middleware
-func InitAuthMiddleware(db *sqlx.DB) (*GinJWTMiddleware, error) {
-	return &GinJWTMiddleware{
-		Realm:      "Proteus Realm", // XXX rename this to Orchestra
-		Key:        []byte(viper.GetString("auth.jwt-token")),
-		Timeout:    time.Hour,
-		MaxRefresh: time.Hour,
+// InitAuthMiddleware is called to initialise the authentication middleware.
+// The tokens are signed with signingKey, for example a key stored in an HSM,
+// or when it's nil with the key configured with auth.jwt-signing-key.
+func InitAuthMiddleware(db *sqlx.DB, signingKey crypto.Signer) (*GinJWTMiddleware, error) {
+	revocationCacheTTL := 30 * time.Second
+	if viper.IsSet("auth.revocation-cache-ttl") {
+		revocationCacheTTL = viper.GetDuration("auth.revocation-cache-ttl")
+	}
+	accessTokenTTL := 10 * time.Minute
+	if viper.IsSet("auth.access-token-ttl") {
+		accessTokenTTL = viper.GetDuration("auth.access-token-ttl")
+	}
+	refreshTokenTTL := 30 * 24 * time.Hour
+	if viper.IsSet("auth.refresh-token-ttl") {
+		refreshTokenTTL = viper.GetDuration("auth.refresh-token-ttl")
+	}
+	// The tokens issued before refresh tokens could be refreshed for an
+	// hour after they were first issued
+	legacyMaxRefresh := time.Hour
+	if viper.IsSet("auth.legacy-max-refresh") {
+		legacyMaxRefresh = viper.GetDuration("auth.legacy-max-refresh")
+	}
+	// The probes only login again when their token expires
+	deviceTokenTTL := time.Hour
+	if viper.IsSet("auth.device-token-ttl") {
+		deviceTokenTTL = viper.GetDuration("auth.device-token-ttl")
+	}
+	if path := viper.GetString("auth.jwt-signing-key"); signingKey == nil && path != "" {
+		key, err := LoadPrivateKey(path)
+		if err != nil {
+			ctx.WithError(err).Error("failed to load the jwt signing key")
+			return nil, err
+		}
+		signingKey = key
+	}
+	verificationKeys := make(map[string]crypto.PublicKey)
+	for _, path := range viper.GetStringSlice("auth.jwt-verification-keys") {
+		key, err := LoadPublicKey(path)
+		if err != nil {
+			ctx.WithError(err).Error("failed to load a jwt verification key")
+			return nil, err
+		}
+		jwk, err := NewJWK(key)
+		if err != nil {
+			return nil, err
+		}
+		verificationKeys[jwk.Kid] = key
+	}
+	mw := &GinJWTMiddleware{
+		Realm:            "Proteus Realm", // XXX rename this to Orchestra
+		Key:              []byte(viper.GetString("auth.jwt-token")),
+		SigningKey:       signingKey,
+		KeyID:            viper.GetString("auth.jwt-key-id"),
+		VerificationKeys: verificationKeys,
+		JWKSURL:          viper.GetString("auth.jwks-url"),
+		Timeout:          accessTokenTTL,
+		MaxRefresh:       legacyMaxRefresh,
+		RoleTimeouts:     map[string]time.Duration{"device": deviceTokenTTL},
+		Revocations:      NewTokenRevocations(db, revocationCacheTTL),
+		RefreshTokens:    &RefreshTokens{DB: db, TTL: refreshTokenTTL},
 		Authenticator: func(userId string, password string, c *gin.Context) (Account, bool) {
-			var (
-				passwordHash string
-				account      Account
-			)
+			var account Account
 			account.Username = userId
 			if account.Username == "admin" {
 				if viper.IsSet("auth.admin-password") == false {
@@ -434,25 +778,7 @@ func InitAuthMiddleware(db *sqlx.DB) (*GinJWTMiddleware, error) {
 				}
 				return account, false
 			}
-			// XXX set the last_login value
-			query := fmt.Sprintf(`SELECT
-							password_hash, role
-							FROM %s WHERE username = $1`,
-				pq.QuoteIdentifier(common.AccountsTable))
-			err := db.QueryRow(query, userId).Scan(
-				&passwordHash,
-				&account.Role)
-			if err != nil {
-				if err == sql.ErrNoRows {
-					return account, false
-				}
-				return account, false
-			}
-			err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password))
-			if err != nil {
-				return account, false
-			}
-			return account, true
+			return checkAccountPassword(db, userId, password)
 		},
 		Unauthorized: func(c *gin.Context, code int, message string) {
 			c.JSON(code, gin.H{
@@ -463,7 +789,11 @@ func InitAuthMiddleware(db *sqlx.DB) (*GinJWTMiddleware, error) {
 		TokenLookup:   "header:Authorization",
 		TokenHeadName: "Bearer",
 		TimeFunc:      time.Now,
-	}, nil
+	}
+	if err := mw.MiddlewareInit(); err != nil {
+		return nil, err
+	}
+	return mw, nil
 }
 
 // AdminAuthorizor is used to protect routes that are allowed only by administrator accounts
@@ -474,6 +804,40 @@ func AdminAuthorizor(account Account, c *gin.Context) bool {
 	return false
 }
 
+// OperatorAuthorizor is used to protect routes that create or change jobs.
+// Operators are only allowed to change the jobs they own, which is checked
+// by the handlers with CanManageJob.
+func OperatorAuthorizor(account Account, c *gin.Context) bool {
+	if account.Role == "admin" || account.Role == "operator" {
+		return true
+	}
+	return false
+}
+
+// ViewerAuthorizor is used to protect the read only admin routes
+func ViewerAuthorizor(account Account, c *gin.Context) bool {
+	switch account.Role {
+	case "admin", "operator", "viewer":
+		return true
+	}
+	return false
+}
+
+// CanManageJob returns true if the account can change a job owned by owner.
+// Admins can change every job and operators only their own jobs.
+func CanManageJob(account Account, owner string) bool {
+	if account.Role == "admin" {
+		return true
+	}
+	return account.Role == "operator" && owner != "" && owner == account.Username
+}
+
+// AuthenticatedAuthorizor is used to protect routes that every account with
+// a valid token can use
+func AuthenticatedAuthorizor(account Account, c *gin.Context) bool {
+	return account.Role != "unauthenticated"
+}
+
 // DeviceAuthorizor is used to protect routes that are allowed only by authenticated devices
 func DeviceAuthorizor(account Account, c *gin.Context) bool {
 	if account.Role == "device" {
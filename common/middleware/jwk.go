@@ -0,0 +1,240 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/hellais/jwt-go"
+)
+
+// JWK is a JSON Web Key (RFC 7517) holding an RSA or EC public key
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// N and E are the modulus and exponent of RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Crv, X and Y are the curve and point of EC keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ErrUnsupportedKey the key is neither an RSA key nor an EC key on one of
+// the P-256, P-384 and P-521 curves
+var ErrUnsupportedKey = errors.New("unsupported key type")
+
+var b64 = base64.RawURLEncoding
+
+// curveParams returns the JWK name of the curve, the JWT algorithm and the
+// size in bytes of the coordinates
+func curveParams(curve elliptic.Curve) (string, string, int, error) {
+	switch curve {
+	case elliptic.P256():
+		return "P-256", "ES256", 32, nil
+	case elliptic.P384():
+		return "P-384", "ES384", 48, nil
+	case elliptic.P521():
+		return "P-521", "ES512", 66, nil
+	}
+	return "", "", 0, ErrUnsupportedKey
+}
+
+// KeyAlgorithm returns the JWT algorithm used to sign with the private key
+// matching the public key
+func KeyAlgorithm(pub crypto.PublicKey) (string, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		_, alg, _, err := curveParams(k.Curve)
+		return alg, err
+	}
+	return "", ErrUnsupportedKey
+}
+
+// NewJWK returns the JWK of the public key. Its key ID is the RFC 7638
+// thumbprint of the key.
+func NewJWK(pub crypto.PublicKey) (JWK, error) {
+	var k JWK
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		k = JWK{
+			Kty: "RSA",
+			Alg: "RS256",
+			N:   b64.EncodeToString(key.N.Bytes()),
+			E:   b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		crv, alg, size, err := curveParams(key.Curve)
+		if err != nil {
+			return k, err
+		}
+		// The coordinates are padded to the size of the curve
+		x, y := make([]byte, size), make([]byte, size)
+		xb, yb := key.X.Bytes(), key.Y.Bytes()
+		copy(x[size-len(xb):], xb)
+		copy(y[size-len(yb):], yb)
+		k = JWK{
+			Kty: "EC",
+			Alg: alg,
+			Crv: crv,
+			X:   b64.EncodeToString(x),
+			Y:   b64.EncodeToString(y),
+		}
+	default:
+		return k, ErrUnsupportedKey
+	}
+	k.Use = "sig"
+	k.Kid = k.Thumbprint()
+	return k, nil
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of the key
+func (k JWK) Thumbprint() string {
+	var members string
+	// The required members in lexicographic order, without whitespace
+	switch k.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, k.E, k.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`,
+			k.Crv, k.X, k.Y)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return b64.EncodeToString(sum[:])
+}
+
+// PublicKey returns the public key of the JWK
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := b64.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, ErrUnsupportedKey
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("the point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// LoadPrivateKey reads an RSA or EC private key from a PEM file
+func LoadPrivateKey(path string) (crypto.Signer, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(b); err == nil {
+		return rsaKey, nil
+	}
+	ecKey, err := jwt.ParseECPrivateKeyFromPEM(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: not an RSA or EC private key", path)
+	}
+	return ecKey, nil
+}
+
+// LoadPublicKey reads an RSA or EC public key from a PEM file
+func LoadPublicKey(path string) (crypto.PublicKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if rsaKey, err := jwt.ParseRSAPublicKeyFromPEM(b); err == nil {
+		return rsaKey, nil
+	}
+	ecKey, err := jwt.ParseECPublicKeyFromPEM(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: not an RSA or EC public key", path)
+	}
+	return ecKey, nil
+}
+
+var jwksClient = &http.Client{Timeout: 10 * time.Second}
+
+// FetchJWKS downloads a JWK set and returns its signing keys by key ID
+func FetchJWKS(url string) (map[string]crypto.PublicKey, error) {
+	keys := make(map[string]crypto.PublicKey)
+	resp, err := jwksClient.Get(url)
+	if err != nil {
+		return keys, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return keys, fmt.Errorf("fetching %s returned %s", url, resp.Status)
+	}
+	var set JWKSet
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return keys, err
+	}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.PublicKey()
+		if err != nil {
+			ctx.WithError(err).Warnf("skipping key %s of %s", k.Kid, url)
+			continue
+		}
+		kid := k.Kid
+		if kid == "" {
+			kid = k.Thumbprint()
+		}
+		keys[kid] = pub
+	}
+	return keys, nil
+}
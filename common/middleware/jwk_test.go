@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/hellais/jwt-go"
+)
+
+func TestJWKRoundTrip(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pub := range []interface{}{&ecKey.PublicKey, &rsaKey.PublicKey} {
+		jwk, err := NewJWK(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := NewJWK(decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again != jwk {
+			t.Errorf("%v != %v", again, jwk)
+		}
+	}
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldMW := &GinJWTMiddleware{Realm: "test", SigningKey: oldKey}
+	if err = oldMW.MiddlewareInit(); err != nil {
+		t.Fatal(err)
+	}
+	if oldMW.SigningAlgorithm != "ES256" {
+		t.Fatalf("unexpected algorithm %s", oldMW.SigningAlgorithm)
+	}
+	oldToken := oldMW.TokenGenerator("device", "someone")
+
+	mw := &GinJWTMiddleware{
+		Realm:            "test",
+		SigningKey:       newKey,
+		VerificationKeys: map[string]crypto.PublicKey{oldMW.KeyID: &oldKey.PublicKey},
+	}
+	if err = mw.MiddlewareInit(); err != nil {
+		t.Fatal(err)
+	}
+	newToken := mw.TokenGenerator("device", "someone")
+	for _, token := range []string{oldToken, newToken} {
+		_, err = jwt.ParseWithClaims(token, &OrchestraClaims{}, mw.verificationKey)
+		if err != nil {
+			t.Errorf("failed to verify token: %v", err)
+		}
+	}
+
+	// A token signed with the secret is refused when no secret is configured
+	hmacMW := &GinJWTMiddleware{Realm: "test", Key: []byte("secret"),
+		TimeFunc: time.Now}
+	if err = hmacMW.MiddlewareInit(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = jwt.ParseWithClaims(hmacMW.TokenGenerator("device", "someone"),
+		&OrchestraClaims{}, mw.verificationKey)
+	if err == nil {
+		t.Error("HS256 token verified without a secret")
+	}
+
+	set, err := mw.JWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 2 || set.Keys[0].Kid != mw.KeyID {
+		t.Errorf("unexpected JWKS %v", set)
+	}
+}
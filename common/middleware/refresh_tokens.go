@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	common "github.com/ooni/orchestra/common"
+	"github.com/satori/go.uuid"
+)
+
+// ErrInvalidRefreshToken the refresh token is unknown, expired or revoked
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// ErrRefreshTokenReused the refresh token was already replaced by another
+// one. Its family is revoked as the token has probably been stolen.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// RefreshToken is an opaque refresh token given to a client
+type RefreshToken struct {
+	Token string
+	// FamilyID is shared by the refresh tokens that replace each other and
+	// is the jti of the access tokens issued with them
+	FamilyID  string
+	ExpiresAt time.Time
+}
+
+// RefreshTokens issues and rotates the refresh tokens stored in the
+// database. Only the SHA256 of the tokens is stored.
+type RefreshTokens struct {
+	DB *sqlx.DB
+	// TTL is how long a refresh token can be used. Every refresh issues a
+	// new token valid for TTL.
+	TTL time.Duration
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRefreshTokenValue() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// insertRefreshToken stores a new token of the family for the account
+func (r *RefreshTokens) insertRefreshToken(db sqlx.Execer, familyID string,
+	account Account) (RefreshToken, error) {
+	var t RefreshToken
+	value, err := newRefreshTokenValue()
+	if err != nil {
+		ctx.WithError(err).Error("failed to generate refresh token")
+		return t, err
+	}
+	now := time.Now().UTC()
+	t = RefreshToken{
+		Token:     value,
+		FamilyID:  familyID,
+		ExpiresAt: now.Add(r.TTL),
+	}
+	query := fmt.Sprintf(`INSERT INTO %s (
+		token_hash, family_id,
+		username, role,
+		issued_at, expires_at
+	) VALUES ($1, $2, $3, $4, $5, $6)`,
+		pq.QuoteIdentifier(common.RefreshTokensTable))
+	_, err = db.Exec(query, hashRefreshToken(value), familyID,
+		account.Username, account.Role,
+		now, t.ExpiresAt)
+	if err != nil {
+		ctx.WithError(err).Error("failed to insert refresh token")
+		return t, err
+	}
+	return t, nil
+}
+
+// Issue returns the first refresh token of a new family for the account.
+// It also drops the expired refresh tokens.
+func (r *RefreshTokens) Issue(account Account) (RefreshToken, error) {
+	t, err := r.insertRefreshToken(r.DB, uuid.NewV4().String(), account)
+	if err != nil {
+		return t, err
+	}
+	query := fmt.Sprintf(`DELETE FROM %s WHERE expires_at < $1`,
+		pq.QuoteIdentifier(common.RefreshTokensTable))
+	if _, err = r.DB.Exec(query, time.Now().UTC()); err != nil {
+		ctx.WithError(err).Error("failed to drop expired refresh tokens")
+	}
+	return t, nil
+}
+
+// Rotate replaces the refresh token with a new one of the same family and
+// returns it with the account it was issued to. Using a token that was
+// already replaced revokes the whole family and returns
+// ErrRefreshTokenReused.
+func (r *RefreshTokens) Rotate(token string) (RefreshToken, Account, error) {
+	var (
+		newToken  RefreshToken
+		account   Account
+		familyID  string
+		expiresAt time.Time
+		usedAt    pq.NullTime
+		revokedAt pq.NullTime
+	)
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		ctx.WithError(err).Error("failed to open transaction")
+		return newToken, account, err
+	}
+	// Locking the row makes concurrent uses of the same token count as reuse
+	query := fmt.Sprintf(`SELECT
+		family_id, username,
+		role, expires_at,
+		used_at, revoked_at
+		FROM %s WHERE token_hash = $1
+		FOR UPDATE`,
+		pq.QuoteIdentifier(common.RefreshTokensTable))
+	err = tx.QueryRow(query, hashRefreshToken(token)).Scan(
+		&familyID, &account.Username,
+		&account.Role, &expiresAt,
+		&usedAt, &revokedAt)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return newToken, account, ErrInvalidRefreshToken
+		}
+		ctx.WithError(err).Error("failed to lookup refresh token")
+		return newToken, account, err
+	}
+	now := time.Now().UTC()
+	if revokedAt.Valid || now.After(expiresAt) {
+		tx.Rollback()
+		return newToken, account, ErrInvalidRefreshToken
+	}
+	if usedAt.Valid {
+		if err = revokeRefreshTokenFamily(tx, familyID); err != nil {
+			tx.Rollback()
+			return newToken, account, err
+		}
+		if err = tx.Commit(); err != nil {
+			ctx.WithError(err).Error("failed to commit transaction")
+			return newToken, account, err
+		}
+		ctx.Warnf("refresh token of %s reused, revoked family %s",
+			account.Username, familyID)
+		newToken.FamilyID = familyID
+		return newToken, account, ErrRefreshTokenReused
+	}
+
+	query = fmt.Sprintf(`UPDATE %s SET used_at = $2 WHERE token_hash = $1`,
+		pq.QuoteIdentifier(common.RefreshTokensTable))
+	if _, err = tx.Exec(query, hashRefreshToken(token), now); err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("failed to mark refresh token as used")
+		return newToken, account, err
+	}
+	newToken, err = r.insertRefreshToken(tx, familyID, account)
+	if err != nil {
+		tx.Rollback()
+		return newToken, account, err
+	}
+	if err = tx.Commit(); err != nil {
+		ctx.WithError(err).Error("failed to commit transaction")
+		return newToken, account, err
+	}
+	return newToken, account, nil
+}
+
+// RevokeFamily revokes all the refresh tokens of the family
+func (r *RefreshTokens) RevokeFamily(familyID string) error {
+	return revokeRefreshTokenFamily(r.DB, familyID)
+}
+
+func revokeRefreshTokenFamily(db sqlx.Execer, familyID string) error {
+	query := fmt.Sprintf(`UPDATE %s SET revoked_at = $2
+		WHERE family_id = $1 AND revoked_at IS NULL`,
+		pq.QuoteIdentifier(common.RefreshTokensTable))
+	_, err := db.Exec(query, familyID, time.Now().UTC())
+	if err != nil {
+		ctx.WithError(err).Error("failed to revoke refresh token family")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestRotateRefreshToken(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	r := &RefreshTokens{DB: sqlx.NewDb(mockDB, "sqlmock"), TTL: time.Hour}
+
+	family := "b7c2fd3e-0a6f-4a5e-9a43-1c1b8f9b0b6e"
+	columns := []string{"family_id", "username", "role", "expires_at", "used_at", "revoked_at"}
+	expires := time.Now().Add(time.Hour)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT").
+		WithArgs(hashRefreshToken("first")).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(family, "alice", "operator", expires, nil, nil))
+	mock.ExpectExec("^UPDATE \"refresh_tokens\" SET used_at").
+		WithArgs(hashRefreshToken("first"), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("^INSERT INTO \"refresh_tokens\"").
+		WithArgs(sqlmock.AnyArg(), family, "alice", "operator",
+			sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	next, account, err := r.Rotate("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.FamilyID != family || next.Token == "" || next.Token == "first" {
+		t.Errorf("unexpected refresh token %v", next)
+	}
+	if account.Username != "alice" || account.Role != "operator" {
+		t.Errorf("unexpected account %v", account)
+	}
+
+	// Using the replaced token again revokes the family
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT").
+		WithArgs(hashRefreshToken("first")).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(family, "alice", "operator", expires, time.Now(), nil))
+	mock.ExpectExec("^UPDATE \"refresh_tokens\" SET revoked_at").
+		WithArgs(family, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	reused, _, err := r.Rotate("first")
+	if err != ErrRefreshTokenReused {
+		t.Errorf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if reused.FamilyID != family || reused.Token != "" {
+		t.Errorf("unexpected refresh token %v", reused)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDeviceLoginHasNoRefreshToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	mw := &GinJWTMiddleware{
+		Realm:         "test",
+		Key:           []byte("secret"),
+		Timeout:       10 * time.Minute,
+		RoleTimeouts:  map[string]time.Duration{"device": time.Hour},
+		RefreshTokens: &RefreshTokens{DB: sqlx.NewDb(mockDB, "sqlmock"), TTL: time.Hour},
+		TimeFunc:      func() time.Time { return now },
+		Authenticator: func(username string, password string, c *gin.Context) (Account, bool) {
+			if username == "probe" {
+				return Account{Username: username, Role: "device"}, true
+			}
+			return Account{Username: username, Role: "operator"}, true
+		},
+	}
+	if err = mw.MiddlewareInit(); err != nil {
+		t.Fatal(err)
+	}
+	login := func(username string) map[string]string {
+		w := httptest.NewRecorder()
+		router := gin.New()
+		router.POST("/login", mw.LoginHandler)
+		body := `{"username": "` + username + `", "password": "secret"}`
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/login", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 (got: %d %s)", w.Code, w.Body.String())
+		}
+		resp := make(map[string]string)
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	// No refresh token is stored for the probes
+	resp := login("probe")
+	if _, ok := resp["refresh_token"]; ok {
+		t.Error("a device got a refresh token")
+	}
+	if resp["expire"] != now.Add(time.Hour).Format(time.RFC3339) {
+		t.Errorf("a device got a token expiring at %s", resp["expire"])
+	}
+
+	mock.ExpectExec("^INSERT INTO \"refresh_tokens\"").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("^DELETE FROM \"refresh_tokens\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	resp = login("alice")
+	if resp["refresh_token"] == "" {
+		t.Error("an operator got no refresh token")
+	}
+	if resp["expire"] != now.Add(10*time.Minute).Format(time.RFC3339) {
+		t.Errorf("an operator got a token expiring at %s", resp["expire"])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestLegacyHeaderRefresh(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mw := &GinJWTMiddleware{
+		Realm:      "test",
+		Key:        []byte("secret"),
+		Timeout:    10 * time.Minute,
+		MaxRefresh: time.Hour,
+	}
+	if err := mw.MiddlewareInit(); err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := func(token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router := gin.New()
+		router.POST("/refresh", mw.RefreshHandler)
+		req := httptest.NewRequest("POST", "/refresh", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The tokens issued before refresh tokens are still refreshed by
+	// themselves
+	legacy := mw.TokenGenerator("operator", "alice")
+	w := refresh(legacy)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a legacy token (got: %d %s)", w.Code, w.Body.String())
+	}
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["token"] == "" {
+		t.Errorf("expected a refreshed token: %s", w.Body.String())
+	}
+
+	// The tokens issued with a refresh token need the refresh token
+	token, _, err := mw.accessToken(Account{Username: "alice", Role: "operator"},
+		"b7c2fd3e-0a6f-4a5e-9a43-1c1b8f9b0b6e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := refresh(token); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for an access token (got: %d)", w.Code)
+	}
+}
@@ -0,0 +1,214 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hellais/jwt-go"
+)
+
+// SignatureHeader is the response header with the detached signature of the
+// response body
+const SignatureHeader = "X-Orchestra-Signature"
+
+// DefaultSignatureValidity is for how long a signed response is accepted
+const DefaultSignatureValidity = time.Hour
+
+// maxSignatureClockSkew is how far in the future the issue time of a
+// signature can be
+const maxSignatureClockSkew = time.Minute
+
+// ErrSignatureExpired the signature is expired or not valid yet
+var ErrSignatureExpired = errors.New("signature expired")
+
+// ErrSignaturePathMismatch the signature was made for another path
+var ErrSignaturePathMismatch = errors.New("signature made for another path")
+
+// ResponseSigner makes detached JWS signatures (RFC 7515 appendix F) of the
+// response bodies, so that probes can check that a response comes from
+// orchestra even when it went through a CDN or a front domain. The
+// protected header holds the issue and expiry times and the request path,
+// so that old or unrelated responses cannot be replayed.
+type ResponseSigner struct {
+	Key   crypto.Signer
+	KeyID string
+	// Validity is for how long a signature is accepted
+	Validity time.Duration
+	// TimeFunc provides the current time, it defaults to time.Now
+	TimeFunc func() time.Time
+	alg      string
+}
+
+// signatureHeader is the protected header of the response signatures
+type signatureHeader struct {
+	Alg  string `json:"alg"`
+	Kid  string `json:"kid"`
+	Iat  int64  `json:"iat"`
+	Exp  int64  `json:"exp"`
+	Path string `json:"path"`
+}
+
+// NewResponseSigner returns the signer using the RSA or EC key. The key ID
+// is the JWK thumbprint of the key.
+func NewResponseSigner(key crypto.Signer) (*ResponseSigner, error) {
+	alg, err := KeyAlgorithm(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := NewJWK(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &ResponseSigner{
+		Key:      key,
+		KeyID:    jwk.Kid,
+		Validity: DefaultSignatureValidity,
+		TimeFunc: time.Now,
+		alg:      alg,
+	}, nil
+}
+
+// CanonicalJSON encodes the value as JSON with the object keys sorted, no
+// whitespace and no HTML escaping, so that the same value always has the
+// same encoding
+func CanonicalJSON(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// Decoding in generic values sorts the keys of the structs as well
+	var generic interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err = dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err = enc.Encode(generic); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// Sign returns the detached JWS of the payload served at path, in the form
+// <protected header>..<signature>
+func (s *ResponseSigner) Sign(payload []byte, path string) (string, error) {
+	now := s.TimeFunc()
+	header, err := json.Marshal(signatureHeader{
+		Alg:  s.alg,
+		Kid:  s.KeyID,
+		Iat:  now.Unix(),
+		Exp:  now.Add(s.Validity).Unix(),
+		Path: path,
+	})
+	if err != nil {
+		return "", err
+	}
+	protected := jwt.EncodeSegment(header)
+	signingString := protected + "." + jwt.EncodeSegment(payload)
+	sig, err := newSignerMethod(s.alg).Sign(signingString, s.Key)
+	if err != nil {
+		return "", err
+	}
+	return protected + ".." + sig, nil
+}
+
+// VerifyDetached checks the detached JWS of the payload served at path with
+// the public key. The signature must be valid at the given time.
+func VerifyDetached(signature string, payload []byte, path string, pub crypto.PublicKey, now time.Time) error {
+	parts := strings.Split(signature, ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return errors.New("invalid detached signature")
+	}
+	b, err := jwt.DecodeSegment(parts[0])
+	if err != nil {
+		return err
+	}
+	var header signatureHeader
+	if err = json.Unmarshal(b, &header); err != nil {
+		return err
+	}
+	alg, err := KeyAlgorithm(pub)
+	if err != nil {
+		return err
+	}
+	if header.Alg != alg {
+		return fmt.Errorf("unexpected algorithm %s", header.Alg)
+	}
+	signingString := parts[0] + "." + jwt.EncodeSegment(payload)
+	err = jwt.GetSigningMethod(alg).Verify(signingString, parts[2], pub)
+	if err != nil {
+		return err
+	}
+	if header.Path != path {
+		return ErrSignaturePathMismatch
+	}
+	if now.Add(maxSignatureClockSkew).Unix() < header.Iat || now.Unix() >= header.Exp {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+// JWKS returns the public key of the signer
+func (s *ResponseSigner) JWKS() (JWKSet, error) {
+	jwk, err := NewJWK(s.Key.Public())
+	if err != nil {
+		return JWKSet{}, err
+	}
+	jwk.Kid = s.KeyID
+	return JWKSet{Keys: []JWK{jwk}}, nil
+}
+
+// JWKSHandler serves the public key that verifies the response signatures
+func (s *ResponseSigner) JWKSHandler(c *gin.Context) {
+	set, err := s.JWKS()
+	if err != nil {
+		ctx.WithError(err).Error("failed to encode the JWKS")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, set)
+}
+
+// MiddlewareFunc makes the signer available to the handlers with
+// SignedJSON
+func (s *ResponseSigner) MiddlewareFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("ResponseSigner", s)
+		c.Next()
+	}
+}
+
+// SignedJSON writes the value as canonical JSON. When a ResponseSigner is
+// configured the detached signature of the body is set in SignatureHeader.
+func SignedJSON(c *gin.Context, code int, v interface{}) {
+	body, err := CanonicalJSON(v)
+	if err != nil {
+		ctx.WithError(err).Error("failed to encode the response")
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	if s, ok := c.Get("ResponseSigner"); ok {
+		signature, err := s.(*ResponseSigner).Sign(body, c.Request.URL.Path)
+		if err != nil {
+			ctx.WithError(err).Error("failed to sign the response")
+			c.JSON(http.StatusInternalServerError,
+				gin.H{"error": "server side error"})
+			return
+		}
+		c.Header(SignatureHeader, signature)
+	}
+	c.Data(code, "application/json; charset=utf-8", body)
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	v := struct {
+		B string            `json:"b"`
+		A map[string]string `json:"a"`
+	}{"<x>", map[string]string{"z": "1", "y": "2"}}
+	b, err := CanonicalJSON(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"a":{"y":"2","z":"1"},"b":"<x>"}`
+	if string(b) != expected {
+		t.Errorf("%s != %s", b, expected)
+	}
+}
+
+func TestSignedJSON(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewResponseSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	signer.TimeFunc = func() time.Time { return now }
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/api/v1/urls?probe_cc=IT", nil)
+	c.Set("ResponseSigner", signer)
+	SignedJSON(c, 200, gin.H{"results": []string{"https://example.com/"}})
+
+	signature := w.Header().Get(SignatureHeader)
+	if signature == "" {
+		t.Fatal("missing signature")
+	}
+	body := w.Body.Bytes()
+	if err = VerifyDetached(signature, body, "/api/v1/urls", &key.PublicKey, now); err != nil {
+		t.Errorf("failed to verify the signature: %v", err)
+	}
+	// A response replayed on another endpoint or after it expired is refused
+	err = VerifyDetached(signature, body, "/api/v1/collectors", &key.PublicKey, now)
+	if err != ErrSignaturePathMismatch {
+		t.Errorf("expected a path mismatch, got %v", err)
+	}
+	err = VerifyDetached(signature, body, "/api/v1/urls", &key.PublicKey,
+		now.Add(DefaultSignatureValidity))
+	if err != ErrSignatureExpired {
+		t.Errorf("expected an expired signature, got %v", err)
+	}
+	body[len(body)-2] = 'X'
+	if err = VerifyDetached(signature, body, "/api/v1/urls", &key.PublicKey, now); err == nil {
+		t.Error("verified the signature of a modified body")
+	}
+}
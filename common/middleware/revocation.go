@@ -0,0 +1,239 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	common "github.com/ooni/orchestra/common"
+)
+
+// maxRevocationCacheSize is the number of cached answers above which the
+// expired ones are dropped
+const maxRevocationCacheSize = 10000
+
+type revocationCacheEntry struct {
+	username string
+	revoked  bool
+	expires  time.Time
+}
+
+// TokenRevocations checks whether tokens are revoked. The answers are cached
+// for TTL, so a token revoked by another instance can still be used for
+// that long.
+type TokenRevocations struct {
+	DB  *sqlx.DB
+	TTL time.Duration
+
+	lock  sync.Mutex
+	cache map[string]revocationCacheEntry
+}
+
+// NewTokenRevocations returns the revocations stored in the database
+func NewTokenRevocations(db *sqlx.DB, ttl time.Duration) *TokenRevocations {
+	return &TokenRevocations{
+		DB:    db,
+		TTL:   ttl,
+		cache: make(map[string]revocationCacheEntry),
+	}
+}
+
+// cacheKey identifies the token. Tokens issued before the jti claim was
+// added are identified by their user and issue time.
+func cacheKey(claims *OrchestraClaims) string {
+	if claims.Id != "" {
+		return claims.Id
+	}
+	return fmt.Sprintf("%s@%d", claims.User, claims.IssuedAt)
+}
+
+// IsRevoked returns true if the token was revoked on its own or by revoking
+// all the tokens of its user
+func (r *TokenRevocations) IsRevoked(claims *OrchestraClaims) (bool, error) {
+	key := cacheKey(claims)
+	now := time.Now()
+
+	r.lock.Lock()
+	entry, ok := r.cache[key]
+	r.lock.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.revoked, nil
+	}
+
+	var tokenRevoked, userRevoked bool
+	query := fmt.Sprintf(`SELECT
+		EXISTS (SELECT 1 FROM %s WHERE jti = $1),
+		EXISTS (SELECT 1 FROM %s WHERE username = $2 AND revoked_before > $3)`,
+		pq.QuoteIdentifier(common.RevokedTokensTable),
+		pq.QuoteIdentifier(common.RevokedUsersTable))
+	err := r.DB.QueryRow(query, claims.Id, claims.User,
+		time.Unix(claims.IssuedAt, 0).UTC()).Scan(&tokenRevoked, &userRevoked)
+	if err != nil {
+		ctx.WithError(err).Error("failed to check token revocation")
+		return false, err
+	}
+	revoked := tokenRevoked || userRevoked
+
+	r.lock.Lock()
+	if len(r.cache) >= maxRevocationCacheSize {
+		for k, e := range r.cache {
+			if now.After(e.expires) {
+				delete(r.cache, k)
+			}
+		}
+	}
+	r.cache[key] = revocationCacheEntry{
+		username: claims.User,
+		revoked:  revoked,
+		expires:  now.Add(r.TTL),
+	}
+	r.lock.Unlock()
+	return revoked, nil
+}
+
+// RevokeToken revokes a single token until expiresAt, after which it can no
+// longer be used anyway. It also drops the expired revocations.
+func (r *TokenRevocations) RevokeToken(claims *OrchestraClaims, expiresAt time.Time) error {
+	if claims.Id == "" {
+		// Without a jti the only way to revoke the token is to revoke all
+		// the tokens of the user
+		return r.RevokeUser(claims.User)
+	}
+	now := time.Now().UTC()
+	query := fmt.Sprintf(`INSERT INTO %s (
+		jti, username,
+		revoked_at, expires_at
+	) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (jti) DO NOTHING`,
+		pq.QuoteIdentifier(common.RevokedTokensTable))
+	_, err := r.DB.Exec(query, claims.Id, claims.User, now, expiresAt.UTC())
+	if err != nil {
+		ctx.WithError(err).Error("failed to revoke token")
+		return err
+	}
+	query = fmt.Sprintf(`DELETE FROM %s WHERE expires_at < $1`,
+		pq.QuoteIdentifier(common.RevokedTokensTable))
+	if _, err = r.DB.Exec(query, now); err != nil {
+		ctx.WithError(err).Error("failed to drop expired revocations")
+	}
+
+	r.lock.Lock()
+	delete(r.cache, cacheKey(claims))
+	r.lock.Unlock()
+	return nil
+}
+
+// RevokeUser revokes all the tokens issued to the user until now
+func (r *TokenRevocations) RevokeUser(username string) error {
+	if err := RevokeUserTokens(r.DB, username); err != nil {
+		return err
+	}
+	r.ForgetUser(username)
+	return nil
+}
+
+// ForgetUser drops the cached answers for the tokens of the user, so that
+// the tokens revoked by a change to its account are refused right away
+func (r *TokenRevocations) ForgetUser(username string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for k, e := range r.cache {
+		if e.username == username {
+			delete(r.cache, k)
+		}
+	}
+}
+
+// ForgetUserRevocations drops the cached revocation answers for the tokens
+// of the user in the TokenRevocations of the request, if any. Handlers
+// changing an account call it after revoking its tokens.
+func ForgetUserRevocations(c *gin.Context, username string) {
+	if r, ok := c.Get("TokenRevocations"); ok {
+		if revocations, _ := r.(*TokenRevocations); revocations != nil {
+			revocations.ForgetUser(username)
+		}
+	}
+}
+
+// RevokeUserTokens revokes all the access tokens issued to the user until
+// now and all its refresh tokens. It can be run in the transaction that
+// deletes the user.
+func RevokeUserTokens(db sqlx.Execer, username string) error {
+	now := time.Now().UTC()
+	// The issue time of the tokens is in seconds, so the cutoff is rounded
+	// up to the next second to also revoke the tokens issued during the
+	// current one
+	query := fmt.Sprintf(`INSERT INTO %s (
+		username, revoked_before
+	) VALUES ($1, $2)
+	ON CONFLICT (username) DO UPDATE SET revoked_before = EXCLUDED.revoked_before`,
+		pq.QuoteIdentifier(common.RevokedUsersTable))
+	_, err := db.Exec(query, username, now.Truncate(time.Second).Add(time.Second))
+	if err != nil {
+		ctx.WithError(err).Error("failed to revoke the tokens of the user")
+		return err
+	}
+	query = fmt.Sprintf(`UPDATE %s SET revoked_at = $2
+		WHERE username = $1 AND revoked_at IS NULL`,
+		pq.QuoteIdentifier(common.RefreshTokensTable))
+	_, err = db.Exec(query, username, now)
+	if err != nil {
+		ctx.WithError(err).Error("failed to revoke the refresh tokens of the user")
+		return err
+	}
+	return nil
+}
+
+// LogoutHandler revokes the token of the request
+func (mw *GinJWTMiddleware) LogoutHandler(c *gin.Context) {
+	payload, ok := c.Get("JWT_PAYLOAD")
+	if !ok {
+		mw.unauthorized(c, http.StatusUnauthorized, ErrMissingToken.Error())
+		return
+	}
+	if mw.Revocations == nil {
+		mw.unauthorized(c, http.StatusInternalServerError, "token revocation is not configured")
+		return
+	}
+	claims := payload.(*OrchestraClaims)
+	// The access tokens issued with the same refresh token family share the
+	// jti. The last one issued expires at most the timeout of the role from
+	// now.
+	timeout, _ := mw.roleTimeout(claims.Role)
+	expiresAt := mw.TimeFunc().Add(timeout)
+	if exp := time.Unix(claims.ExpiresAt, 0); exp.After(expiresAt) {
+		expiresAt = exp
+	}
+	if err := mw.Revocations.RevokeToken(claims, expiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	if mw.RefreshTokens != nil && claims.Id != "" {
+		if err := mw.RefreshTokens.RevokeFamily(claims.Id); err != nil {
+			c.JSON(http.StatusInternalServerError,
+				gin.H{"error": "server side error"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "logged out"})
+}
+
+// RevokeUserTokensHandler revokes all the tokens of the user in the
+// username parameter
+func (mw *GinJWTMiddleware) RevokeUserTokensHandler(c *gin.Context) {
+	if mw.Revocations == nil {
+		mw.unauthorized(c, http.StatusInternalServerError, "token revocation is not configured")
+		return
+	}
+	if err := mw.Revocations.RevokeUser(c.Param("username")); err != nil {
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestTokenRevocation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+
+	mw := &GinJWTMiddleware{
+		Realm:       "test",
+		Key:         []byte("secret"),
+		Revocations: NewTokenRevocations(sqlx.NewDb(mockDB, "sqlmock"), time.Minute),
+	}
+	if err = mw.MiddlewareInit(); err != nil {
+		t.Fatal(err)
+	}
+	token := mw.TokenGenerator("device", "someone")
+
+	request := func(handler gin.HandlerFunc) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router := gin.New()
+		router.POST("/", mw.MiddlewareFunc(NullAuthorizor), handler)
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	ok := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{})
+	}
+
+	// The second request uses the cached answer
+	mock.ExpectQuery("^SELECT").
+		WillReturnRows(sqlmock.NewRows([]string{"token", "user"}).AddRow(false, false))
+	for i := 0; i < 2; i++ {
+		if w := request(ok); w.Code != http.StatusOK {
+			t.Fatalf("expected 200 (got: %d %s)", w.Code, w.Body.String())
+		}
+	}
+
+	mock.ExpectExec("^INSERT INTO \"revoked_tokens\"").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("^DELETE FROM \"revoked_tokens\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if w := request(mw.LogoutHandler); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 (got: %d %s)", w.Code, w.Body.String())
+	}
+
+	mock.ExpectQuery("^SELECT").
+		WillReturnRows(sqlmock.NewRows([]string{"token", "user"}).AddRow(true, false))
+	if w := request(ok); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a revoked token (got: %d)", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetAccountPasswordRevokesTokens(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+
+	mock.ExpectExec("^UPDATE \"accounts\" SET password_hash").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// The tokens issued during the current second are revoked as well
+	mock.ExpectExec("^INSERT INTO \"revoked_users\"").
+		WithArgs("alice", notBefore(time.Now().UTC())).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("^UPDATE \"refresh_tokens\" SET revoked_at").
+		WithArgs("alice", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	err = SetAccountPassword(sqlx.NewDb(mockDB, "sqlmock"), "alice", "new password")
+	if err != nil {
+		t.Fatalf("failed to set the password: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// notBefore matches the times that are not before t
+type notBefore time.Time
+
+func (n notBefore) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && !t.Before(time.Time(n))
+}
+
+func TestForgetUserRevocations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+
+	mw := &GinJWTMiddleware{
+		Realm:       "test",
+		Key:         []byte("secret"),
+		Revocations: NewTokenRevocations(sqlx.NewDb(mockDB, "sqlmock"), time.Minute),
+	}
+	if err = mw.MiddlewareInit(); err != nil {
+		t.Fatal(err)
+	}
+	token := mw.TokenGenerator("admin", "someone")
+
+	request := func(handler gin.HandlerFunc) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router := gin.New()
+		router.POST("/", mw.MiddlewareFunc(NullAuthorizor), handler)
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	ok := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{})
+	}
+	// Stands for a handler changing the account, for example resetting
+	// its password
+	forget := func(c *gin.Context) {
+		ForgetUserRevocations(c, "someone")
+		c.JSON(http.StatusOK, gin.H{})
+	}
+
+	mock.ExpectQuery("^SELECT").
+		WillReturnRows(sqlmock.NewRows([]string{"token", "user"}).AddRow(false, false))
+	if w := request(forget); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 (got: %d %s)", w.Code, w.Body.String())
+	}
+
+	// The cached answer was dropped, so the revocation is seen right away
+	mock.ExpectQuery("^SELECT").
+		WillReturnRows(sqlmock.NewRows([]string{"token", "user"}).AddRow(false, true))
+	if w := request(ok); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a revoked token (got: %d)", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
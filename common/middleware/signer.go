@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/asn1"
+	"math/big"
+
+	"github.com/hellais/jwt-go"
+)
+
+// signerMethod is a jwt.SigningMethod that signs with a crypto.Signer, so
+// that the signing key can stay in an HSM. Tokens are verified by the RS
+// and ES methods of jwt-go.
+type signerMethod struct {
+	jwt.SigningMethod
+	hash crypto.Hash
+}
+
+// newSignerMethod returns the method signing with a crypto.Signer for the
+// RS or ES algorithm
+func newSignerMethod(alg string) jwt.SigningMethod {
+	switch m := jwt.GetSigningMethod(alg).(type) {
+	case *jwt.SigningMethodRSA:
+		return signerMethod{SigningMethod: m, hash: m.Hash}
+	case *jwt.SigningMethodECDSA:
+		return signerMethod{SigningMethod: m, hash: m.Hash}
+	}
+	return nil
+}
+
+// Sign signs the string with the crypto.Signer key
+func (m signerMethod) Sign(signingString string, key interface{}) (string, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	h := m.hash.New()
+	h.Write([]byte(signingString))
+	sig, err := signer.Sign(rand.Reader, h.Sum(nil), m.hash)
+	if err != nil {
+		return "", err
+	}
+	if pub, ok := signer.Public().(*ecdsa.PublicKey); ok {
+		// Signers return ASN.1 ECDSA signatures while JWS uses the
+		// concatenation of r and s padded to the size of the curve
+		var esig struct {
+			R, S *big.Int
+		}
+		if _, err = asn1.Unmarshal(sig, &esig); err != nil {
+			return "", err
+		}
+		_, _, size, err := curveParams(pub.Curve)
+		if err != nil {
+			return "", err
+		}
+		rb, sb := esig.R.Bytes(), esig.S.Bytes()
+		sig = make([]byte, 2*size)
+		copy(sig[size-len(rb):size], rb)
+		copy(sig[2*size-len(sb):], sb)
+	}
+	return jwt.EncodeSegment(sig), nil
+}
@@ -67,6 +67,6 @@ require (
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 	gopkg.in/go-playground/validator.v8 v8.18.1 // indirect
 	gopkg.in/gorp.v1 v1.7.1 // indirect
-	gopkg.in/yaml.v2 v2.0.0-20170407172122-cd8b52f8269e // indirect
+	gopkg.in/yaml.v2 v2.0.0-20170407172122-cd8b52f8269e
 	gotest.tools v2.2.0+incompatible // indirect
 )
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/ooni/orchestra/common/middleware"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var (
+	accountsRole          string
+	accountsPasswordStdin bool
+)
+
+var accountsCmd = &cobra.Command{
+	Use:   "accounts",
+	Short: "Manage the admin, operator and viewer accounts",
+}
+
+// readPassword reads the new password of an account from the terminal,
+// asking for it twice, or from the first line of the standard input
+func readPassword() (string, error) {
+	if accountsPasswordStdin || !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Repeat password: ")
+	repeated, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(password) != string(repeated) {
+		return "", errors.New("the passwords do not match")
+	}
+	return string(password), nil
+}
+
+// auditAccount records a change to an account made from the command line
+func auditAccount(db *sqlx.DB, action string, username string) {
+	audit := middleware.CommandAuditEntry()
+	audit.Action = action
+	audit.TargetID = username
+	middleware.WriteAuditEntry(db, audit)
+}
+
+var accountsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the accounts",
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := initJobDB()
+		if err != nil {
+			log.WithError(err).Error("failed to connect to the database")
+			return
+		}
+		accounts, err := middleware.ListAccounts(db)
+		if err != nil {
+			log.WithError(err).Error("failed to list accounts")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "USERNAME\tROLE\tDISABLED\tLAST ACCESS")
+		for _, a := range accounts {
+			lastAccess := "never"
+			if a.LastAccess != nil {
+				lastAccess = a.LastAccess.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", a.Username, a.Role, a.Disabled,
+				lastAccess)
+		}
+		w.Flush()
+	},
+}
+
+var accountsAddCmd = &cobra.Command{
+	Use:   "add <username>",
+	Short: "Add an account",
+	Long: `This command adds an account with the role given by --role, one of admin,
+operator and viewer. The password is asked on the terminal or, with
+--password-stdin, read from the standard input.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Error("add takes exactly one username")
+			return
+		}
+		db, err := initJobDB()
+		if err != nil {
+			log.WithError(err).Error("failed to connect to the database")
+			return
+		}
+		password, err := readPassword()
+		if err != nil {
+			log.WithError(err).Error("failed to read the password")
+			return
+		}
+		err = middleware.CreateAccount(db, args[0], password, accountsRole)
+		if err != nil {
+			log.WithError(err).Errorf("failed to add account %s", args[0])
+			return
+		}
+		auditAccount(db, "account.create", args[0])
+		log.Infof("account %s added", args[0])
+	},
+}
+
+var accountsPasswdCmd = &cobra.Command{
+	Use:   "passwd <username>",
+	Short: "Reset the password of an account and revoke its tokens",
+	Long: `This command resets the password of the account and revokes its tokens.
+Running servers cache the revocation checks, so they can keep accepting the
+revoked tokens for up to auth.revocation-cache-ttl.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Error("passwd takes exactly one username")
+			return
+		}
+		db, err := initJobDB()
+		if err != nil {
+			log.WithError(err).Error("failed to connect to the database")
+			return
+		}
+		password, err := readPassword()
+		if err != nil {
+			log.WithError(err).Error("failed to read the password")
+			return
+		}
+		err = middleware.SetAccountPassword(db, args[0], password)
+		if err != nil {
+			log.WithError(err).Errorf("failed to reset the password of %s", args[0])
+			return
+		}
+		auditAccount(db, "account.password", args[0])
+		log.Infof("password of %s updated", args[0])
+	},
+}
+
+// accountsUpdateCmd returns a command that applies fn to the account given
+// as argument
+func accountsUpdateCmd(use string, short string, action string, done string,
+	fn func(*sqlx.DB, string) error) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " <username>",
+		Short: short,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 1 {
+				log.Errorf("%s takes exactly one username", use)
+				return
+			}
+			db, err := initJobDB()
+			if err != nil {
+				log.WithError(err).Error("failed to connect to the database")
+				return
+			}
+			err = fn(db, args[0])
+			if err != nil {
+				log.WithError(err).Errorf("failed to %s account %s", use, args[0])
+				return
+			}
+			auditAccount(db, action, args[0])
+			log.Infof("account %s %s", args[0], done)
+		},
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(accountsCmd)
+
+	accountsAddCmd.Flags().StringVar(&accountsRole, "role", "operator", "One of admin, operator and viewer")
+	for _, c := range []*cobra.Command{accountsAddCmd, accountsPasswdCmd} {
+		c.Flags().BoolVar(&accountsPasswordStdin, "password-stdin", false, "Read the password from the standard input")
+	}
+
+	accountsCmd.AddCommand(accountsListCmd)
+	accountsCmd.AddCommand(accountsAddCmd)
+	accountsCmd.AddCommand(accountsPasswdCmd)
+	accountsCmd.AddCommand(accountsUpdateCmd("disable", "Disable an account",
+		"account.disable", "disabled",
+		func(db *sqlx.DB, username string) error {
+			return middleware.SetAccountDisabled(db, username, true)
+		}))
+	accountsCmd.AddCommand(accountsUpdateCmd("enable", "Enable a disabled account",
+		"account.enable", "enabled",
+		func(db *sqlx.DB, username string) error {
+			return middleware.SetAccountDisabled(db, username, false)
+		}))
+	accountsCmd.AddCommand(accountsUpdateCmd("delete", "Delete an account",
+		"account.delete", "deleted", middleware.DeleteAccount))
+}
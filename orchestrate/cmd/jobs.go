@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+
+	"github.com/apex/log"
+	"github.com/ooni/orchestra/common/middleware"
+	"github.com/ooni/orchestra/orchestrate/orchestrate/handler"
+	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
+	"github.com/spf13/cobra"
+)
+
+var (
+	jobsQuery  handler.JobsQuery
+	jobsJSON   bool
+	jobsDryRun bool
+	jobsYes    bool
+)
+
+func initJobStore() (*sched.PostgresStore, error) {
+	db, err := initJobDB()
+	if err != nil {
+		return nil, err
+	}
+	return sched.NewPostgresStore(db), nil
+}
+
+func printJSON(v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.WithError(err).Error("failed to serialise output")
+		return
+	}
+	fmt.Println(string(b))
+}
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Manage the jobs",
+	Long: `These commands manage the jobs directly in the database. A running
+orchestrate picks up the changes within core.job-refresh-interval.`,
+}
+
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the jobs",
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := initJobStore()
+		if err != nil {
+			log.WithError(err).Error("failed to init the job store")
+			return
+		}
+		jobs, nextCursor, err := handler.ListJobs(store.DB(), jobsQuery)
+		if err != nil {
+			log.WithError(err).Error("failed to list jobs")
+			return
+		}
+		if jobsJSON {
+			printJSON(jobs)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTATE\tSCHEDULE\tTYPE\tCOMMENT")
+		for _, jd := range jobs {
+			jobType := "alert"
+			if jd.TaskData != nil {
+				jobType = jd.TaskData.TestName
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", jd.ID, jd.State,
+				jd.Schedule, jobType, jd.Comment)
+		}
+		w.Flush()
+		if nextCursor != "" {
+			fmt.Printf("more jobs available with --cursor %s\n", nextCursor)
+		}
+	},
+}
+
+var jobsShowCmd = &cobra.Command{
+	Use:   "show <job_id>",
+	Short: "Show a job",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Error("show takes exactly one job ID")
+			return
+		}
+		store, err := initJobStore()
+		if err != nil {
+			log.WithError(err).Error("failed to init the job store")
+			return
+		}
+		jd, err := handler.GetJob(store, args[0])
+		if err != nil {
+			log.WithError(err).Errorf("failed to get job %s", args[0])
+			return
+		}
+		printJSON(jd)
+	},
+}
+
+var jobsAddCmd = &cobra.Command{
+	Use:   "add <job_file>",
+	Short: "Add a job from a YAML or JSON file",
+	Long: `This command adds the job defined in the given YAML or JSON file, which
+uses the same format as the POST /api/v1/admin/job endpoint. Use - to read
+the job from the standard input.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var (
+			b   []byte
+			err error
+		)
+		if len(args) != 1 {
+			log.Error("add takes exactly one job file")
+			return
+		}
+		if args[0] == "-" {
+			b, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			b, err = ioutil.ReadFile(args[0])
+		}
+		if err != nil {
+			log.WithError(err).Error("failed to read the job file")
+			return
+		}
+		jd, err := handler.ParseJobDefinition(b)
+		if err != nil {
+			log.WithError(err).Error("invalid job definition")
+			return
+		}
+		err = handler.ValidateJob(jd)
+		if err != nil {
+			log.WithError(err).Error("invalid job")
+			return
+		}
+
+		store, err := initJobStore()
+		if err != nil {
+			log.WithError(err).Error("failed to init the job store")
+			return
+		}
+		if jobsDryRun {
+			probes, err := store.ListTargetProbes(jd.Target.Countries,
+				jd.Target.Platforms, jd.Target.Groups)
+			if err != nil {
+				log.WithError(err).Error("failed to list the target probes")
+				return
+			}
+			printJSON(jd)
+			fmt.Printf("dry run: the job is valid and currently targets %d probes\n",
+				len(probes))
+			return
+		}
+		audit := middleware.CommandAuditEntry()
+		audit.BodyDigest = middleware.BodyDigest(b)
+		jobID, err := handler.AddJob(store, jd, nil, audit)
+		if err != nil {
+			log.WithError(err).Error("failed to add job")
+			return
+		}
+		fmt.Println(jobID)
+	},
+}
+
+var jobsSyncCmd = &cobra.Command{
+	Use:   "sync <dir>",
+	Short: "Make the named jobs match the job files of a directory",
+	Long: `This command reads the YAML and JSON job files in the given directory and
+compares them with the jobs by name. The name of a job is its name field or,
+when missing, the name of its file. It prints the plan and, once confirmed,
+creates the missing jobs, updates the changed ones, pauses or resumes jobs
+according to their state field and deletes the named jobs that no longer
+have a file. Jobs without a name are never touched.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Error("sync takes exactly one directory")
+			return
+		}
+		jobs, err := handler.LoadJobDefinitions(args[0])
+		if err != nil {
+			log.WithError(err).Error("failed to load the job files")
+			return
+		}
+		store, err := initJobStore()
+		if err != nil {
+			log.WithError(err).Error("failed to init the job store")
+			return
+		}
+		plan, err := handler.PlanJobSync(store, jobs)
+		if err != nil {
+			log.WithError(err).Error("failed to plan the sync")
+			return
+		}
+		if len(plan) == 0 {
+			fmt.Printf("%d jobs, nothing to do\n", len(jobs))
+			return
+		}
+		for _, a := range plan {
+			fmt.Println(a)
+		}
+		if jobsDryRun {
+			return
+		}
+		if !jobsYes {
+			fmt.Printf("Apply these %d changes? Press enter to continue or ctrl-c to cancel.", len(plan))
+			bufio.NewReader(os.Stdin).ReadString('\n')
+		}
+		audit := middleware.CommandAuditEntry()
+		for i, a := range plan {
+			err = handler.ApplySyncAction(store, a, nil, audit)
+			if err != nil {
+				log.WithError(err).Errorf("failed to %s %s, %d of %d changes applied",
+					a.Action, a.Name, i, len(plan))
+				return
+			}
+		}
+		log.Infof("applied %d changes", len(plan))
+	},
+}
+
+// jobsStateCmd returns a command that applies fn to the job given as
+// argument
+func jobsStateCmd(use string, short string, done string,
+	fn func(string, sched.Store, *sched.Scheduler, middleware.AuditEntry) error) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " <job_id>",
+		Short: short,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != 1 {
+				log.Errorf("%s takes exactly one job ID", use)
+				return
+			}
+			store, err := initJobStore()
+			if err != nil {
+				log.WithError(err).Error("failed to init the job store")
+				return
+			}
+			err = fn(args[0], store, nil, middleware.CommandAuditEntry())
+			if err != nil {
+				log.WithError(err).Errorf("failed to %s job %s", use, args[0])
+				return
+			}
+			log.Infof("job %s %s", args[0], done)
+		},
+	}
+}
+
+func init() {
+	RootCmd.AddCommand(jobsCmd)
+
+	jobsListCmd.Flags().StringVar(&jobsQuery.State, "state", "", "Comma separated job states")
+	jobsListCmd.Flags().StringVar(&jobsQuery.Type, "type", "", "Either alert or task")
+	jobsListCmd.Flags().StringVar(&jobsQuery.TestName, "test-name", "", "Comma separated test names")
+	jobsListCmd.Flags().StringVar(&jobsQuery.CountryCode, "country-code", "", "Comma separated target countries")
+	jobsListCmd.Flags().StringVar(&jobsQuery.Search, "search", "", "Search in the job comments")
+	jobsListCmd.Flags().StringVar(&jobsQuery.Owner, "owner", "", "Only list the jobs created by this account")
+	jobsListCmd.Flags().StringVar(&jobsQuery.Cursor, "cursor", "", "Cursor of the page to list")
+	jobsListCmd.Flags().Int64Var(&jobsQuery.Limit, "limit", 100, "Maximum number of jobs to list")
+	jobsListCmd.Flags().BoolVar(&jobsJSON, "json", false, "Print the jobs as JSON")
+	jobsAddCmd.Flags().BoolVar(&jobsDryRun, "dry-run", false, "Validate the job without adding it")
+	jobsSyncCmd.Flags().BoolVar(&jobsDryRun, "dry-run", false, "Print the plan without applying it")
+	jobsSyncCmd.Flags().BoolVar(&jobsYes, "yes", false, "Apply the plan without asking for confirmation")
+
+	jobsCmd.AddCommand(jobsListCmd)
+	jobsCmd.AddCommand(jobsShowCmd)
+	jobsCmd.AddCommand(jobsAddCmd)
+	jobsCmd.AddCommand(jobsSyncCmd)
+	jobsCmd.AddCommand(jobsStateCmd("delete", "Delete a job", "deleted",
+		handler.DeleteJob))
+	jobsCmd.AddCommand(jobsStateCmd("pause", "Pause an active job", "paused",
+		handler.PauseJob))
+	jobsCmd.AddCommand(jobsStateCmd("resume", "Resume a paused job", "resumed",
+		handler.ResumeJob))
+}
@@ -0,0 +1,225 @@
+//go:build pkcs11
+// +build pkcs11
+
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/apex/log"
+	"github.com/hellais/jwt-go"
+	"github.com/ooni/orchestra/common/keystore"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var (
+	keystoreLibPath   string
+	keystorePINFile   string
+	keystoreSOPINFile string
+	keystoreBits      int
+)
+
+var keystoreCmd = &cobra.Command{
+	Use:   "keystore",
+	Short: "Manage the signing keys stored in a PKCS#11 token",
+	Long: `These commands manage the RSA keys of the first initialised token of the
+PKCS#11 library given by --lib or auth.hsm-library. The user pin is asked on
+the terminal unless --pin-file is set.`,
+}
+
+// readPINFile reads a pin from the first line of a file
+func readPINFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(strings.SplitN(string(b), "\n", 2)[0], "\r"), nil
+}
+
+// newPIN reads a new pin from the file or asks it twice on the terminal
+func newPIN(path string, pinType string) (string, error) {
+	if path != "" {
+		return readPINFile(path)
+	}
+	fmt.Fprintf(os.Stderr, "New %s pin: ", pinType)
+	pin, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(os.Stderr, "Repeat %s pin: ", pinType)
+	repeated, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(pin) != string(repeated) {
+		return "", fmt.Errorf("the %s pins do not match", pinType)
+	}
+	return string(pin), nil
+}
+
+func keystoreLib() string {
+	if keystoreLibPath != "" {
+		return keystoreLibPath
+	}
+	return viper.GetString("auth.hsm-library")
+}
+
+// openKeystore logs in on the token with the pin of --pin-file, or the one
+// typed on the terminal
+func openKeystore() (*keystore.Session, error) {
+	var pin string
+	if keystorePINFile != "" {
+		var err error
+		pin, err = readPINFile(keystorePINFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keystore.Open(keystoreLib(), pin)
+}
+
+// keystoreSessionCmd returns a command that runs fn in a session on the
+// token, after checking that it got nArgs arguments
+func keystoreSessionCmd(use string, short string, nArgs int,
+	fn func(*keystore.Session, []string) error) *cobra.Command {
+	name := strings.SplitN(use, " ", 2)[0]
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) != nArgs {
+				log.Errorf("%s takes %d arguments", name, nArgs)
+				return
+			}
+			session, err := openKeystore()
+			if err != nil {
+				log.WithError(err).Error("failed to open the keystore")
+				return
+			}
+			defer session.Close()
+			if err = fn(session, args); err != nil {
+				log.WithError(err).Errorf("failed to %s", name)
+			}
+		},
+	}
+}
+
+var keystoreInitCmd = &cobra.Command{
+	Use:   "init <token label>",
+	Short: "Initialise the first uninitialised token and set its pins",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Error("init takes exactly one token label")
+			return
+		}
+		soPIN, err := newPIN(keystoreSOPINFile, "SO")
+		if err != nil {
+			log.WithError(err).Error("failed to read the SO pin")
+			return
+		}
+		userPIN, err := newPIN(keystorePINFile, "User")
+		if err != nil {
+			log.WithError(err).Error("failed to read the user pin")
+			return
+		}
+		err = keystore.InitToken(keystoreLib(), args[0], soPIN, userPIN)
+		if err != nil {
+			log.WithError(err).Error("failed to initialise the token")
+			return
+		}
+		log.Infof("token %s initialised", args[0])
+	},
+}
+
+var keystoreGenerateCmd = keystoreSessionCmd("generate <label>",
+	"Generate an RSA key inside the token", 1,
+	func(session *keystore.Session, args []string) error {
+		_, err := session.GenerateKey(args[0], keystoreBits)
+		if err != nil {
+			return err
+		}
+		log.Infof("key %s generated", args[0])
+		return nil
+	})
+
+var keystoreImportCmd = keystoreSessionCmd("import <label> <private key PEM file>",
+	"Import an RSA private key in the token", 2,
+	func(session *keystore.Session, args []string) error {
+		b, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			return err
+		}
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(b)
+		if err != nil {
+			return err
+		}
+		if err = session.ImportKey(args[0], key); err != nil {
+			return err
+		}
+		log.Infof("key %s imported", args[0])
+		return nil
+	})
+
+var keystoreListCmd = keystoreSessionCmd("list", "List the keys in the token", 0,
+	func(session *keystore.Session, args []string) error {
+		keys, err := session.ListKeys()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "LABEL\tID\tBITS")
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", k.Label, k.ID, k.Bits)
+		}
+		return w.Flush()
+	})
+
+var keystoreExportPubCmd = keystoreSessionCmd("export-pub <label>",
+	"Write the PEM public key of a key to the standard output", 1,
+	func(session *keystore.Session, args []string) error {
+		pub, err := session.PublicKey(args[0])
+		if err != nil {
+			return err
+		}
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return err
+		}
+		return pem.Encode(os.Stdout, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	})
+
+var keystoreDeleteCmd = keystoreSessionCmd("delete <label>",
+	"Delete a key from the token", 1,
+	func(session *keystore.Session, args []string) error {
+		if err := session.DeleteKey(args[0]); err != nil {
+			return err
+		}
+		log.Infof("key %s deleted", args[0])
+		return nil
+	})
+
+func init() {
+	RootCmd.AddCommand(keystoreCmd)
+
+	keystoreCmd.PersistentFlags().StringVar(&keystoreLibPath, "lib", "", "Path of the PKCS#11 library (default is auth.hsm-library)")
+	keystoreCmd.PersistentFlags().StringVar(&keystorePINFile, "pin-file", "", "Read the user pin from this file")
+	keystoreInitCmd.Flags().StringVar(&keystoreSOPINFile, "so-pin-file", "", "Read the SO pin from this file")
+	keystoreGenerateCmd.Flags().IntVar(&keystoreBits, "bits", 2048, "Size of the RSA key")
+
+	keystoreCmd.AddCommand(keystoreInitCmd)
+	keystoreCmd.AddCommand(keystoreGenerateCmd)
+	keystoreCmd.AddCommand(keystoreImportCmd)
+	keystoreCmd.AddCommand(keystoreListCmd)
+	keystoreCmd.AddCommand(keystoreExportPubCmd)
+	keystoreCmd.AddCommand(keystoreDeleteCmd)
+}
@@ -4,11 +4,12 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/jmoiron/sqlx"
-	"github.com/lib/pq"
-	"github.com/ooni/orchestra/common"
 	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -23,74 +24,200 @@ func initJobDB() (*sqlx.DB, error) {
 	return db, err
 }
 
+// readProgress returns the IDs of the probes listed in the progress file of
+// a previous run
+func readProgress(path string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return done, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			done[id] = true
+		}
+	}
+	return done, scanner.Err()
+}
+
+// printTargetCounts prints how many probes are targeted in every country
+// and on every platform
+func printTargetCounts(probes []sched.Probe) {
+	byCountry := make(map[string]int)
+	byPlatform := make(map[string]int)
+	for _, p := range probes {
+		byCountry[p.ProbeCC]++
+		byPlatform[p.Platform]++
+	}
+	for _, counts := range []map[string]int{byCountry, byPlatform} {
+		var keys []string
+		for k := range counts {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if k == "" {
+				fmt.Printf("  unknown\t%d\n", counts[k])
+				continue
+			}
+			fmt.Printf("  %s\t%d\n", k, counts[k])
+		}
+		fmt.Println()
+	}
+}
+
 // startCmd represents the start command
 var notify = &cobra.Command{
 	Use:   "notify",
 	Short: "Send a notification message to some users",
-	Long:  `This command sends notifications to some users`,
+	Long: `This command sends notifications to the probes matching the given
+filters. Use --all to notify every probe.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		where := viper.GetString("notify.where")
 		message := viper.GetString("notify.message")
 		if message == "" {
 			log.Error("Message is empty")
 			return
 		}
+		filter := sched.ProbeFilter{
+			Countries:  viper.GetStringSlice("notify.country"),
+			Platforms:  viper.GetStringSlice("notify.platform"),
+			ASNs:       viper.GetStringSlice("notify.asn"),
+			MinVersion: viper.GetString("notify.min-version"),
+			MaxVersion: viper.GetString("notify.max-version"),
+			LastSeen:   viper.GetDuration("notify.last-seen"),
+		}
+		if filter.IsEmpty() && !viper.GetBool("notify.all") {
+			log.Error("no filter given, use --all to notify every probe")
+			return
+		}
+		if err := filter.Validate(); err != nil {
+			log.WithError(err).Error("invalid filter")
+			return
+		}
+		rate := viper.GetFloat64("notify.rate")
+		if rate < 0 {
+			log.Error("rate must be positive")
+			return
+		}
 
 		db, err := initJobDB()
 		if err != nil {
 			log.WithError(err).Error("failed to init jdb")
 			return
 		}
+		probes, err := sched.NewPostgresStore(db).ListProbes(filter)
+		if err != nil {
+			ctx.WithError(err).Error("failed to find targets")
+			return
+		}
+
+		progressPath := viper.GetString("notify.progress-file")
+		var done map[string]bool
+		if progressPath != "" {
+			done, err = readProgress(progressPath)
+			if err != nil {
+				log.WithError(err).Error("failed to read the progress file")
+				return
+			}
+		}
 		// "You may be running an out of date version of OONI Probe which includes a critical bug. Please update to the latest version."
 		alertData := sched.AlertData{
 			Message: message,
 		}
+		var (
+			targets []*sched.JobTarget
+			pending []sched.Probe
+		)
+		for _, p := range probes {
+			if done[p.ID] {
+				continue
+			}
+			pending = append(pending, p)
+			targets = append(targets, sched.NewJobTarget(p.ID, p.Token, p.Platform, nil, nil, &alertData))
+		}
 
-		query := fmt.Sprintf("SELECT id, token, platform FROM %s",
-			pq.QuoteIdentifier(common.ActiveProbesTable))
-		query += " WHERE is_token_expired = false AND token != '' AND "
-		query += where
-
-		rows, err := db.Query(query)
-		if err != nil {
-			ctx.WithError(err).Error("failed to find targets")
+		if viper.GetBool("notify.dry-run") {
+			fmt.Printf("%d probes match the filters, %d already notified, %d to notify\n\n",
+				len(probes), len(probes)-len(pending), len(pending))
+			printTargetCounts(pending)
 			return
 		}
-		var targets []*sched.JobTarget
-		defer rows.Close()
-		for rows.Next() {
-			var (
-				clientID string
-				token    string
-				plat     string
-			)
-			err = rows.Scan(&clientID, &token, &plat)
+		if len(targets) == 0 {
+			log.Info("no probe to notify")
+			return
+		}
+
+		var progress *os.File
+		if progressPath != "" {
+			progress, err = os.OpenFile(progressPath,
+				os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 			if err != nil {
-				ctx.WithError(err).Error("failed to iterate over targets")
+				log.WithError(err).Error("failed to open the progress file")
 				return
 			}
-			targets = append(targets, sched.NewJobTarget(clientID, token, plat, nil, nil, &alertData))
+			defer progress.Close()
 		}
+
 		reader := bufio.NewReader(os.Stdin)
 		log.Infof("You are about to send to %d users the message: \"%s\". Press enter continue or ctrl-c to cancel.", len(targets), message)
 		reader.ReadString('\n')
 
+		var throttle <-chan time.Time
+		if rate > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+			defer ticker.Stop()
+			throttle = ticker.C
+		}
+		sent := 0
 		for _, target := range targets {
+			if throttle != nil {
+				<-throttle
+			}
 			err = sched.NotifyGorush(
 				viper.GetString("core.gorush-url"),
 				target)
 			if err != nil {
 				ctx.WithError(err).Errorf("failed to notify cid: %s", target.ClientID)
+				continue
+			}
+			sent++
+			if progress != nil {
+				_, err = fmt.Fprintln(progress, target.ClientID)
+				if err == nil {
+					err = progress.Sync()
+				}
+				if err != nil {
+					log.WithError(err).Error("failed to write to the progress file")
+					return
+				}
 			}
 		}
+		log.Infof("notified %d of %d users", sent, len(targets))
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(notify)
 
-	notify.PersistentFlags().StringP("where", "", "", "Where query statement to select users by, example software_version = '2.0.0'")
 	notify.PersistentFlags().StringP("message", "", "", "The content of the message to send")
-	viper.BindPFlag("notify.where", notify.PersistentFlags().Lookup("where"))
-	viper.BindPFlag("notify.message", notify.PersistentFlags().Lookup("message"))
+	notify.PersistentFlags().StringSlice("country", nil, "Only notify the probes in these countries")
+	notify.PersistentFlags().StringSlice("platform", nil, "Only notify the probes on these platforms")
+	notify.PersistentFlags().StringSlice("asn", nil, "Only notify the probes in these networks, example AS1234")
+	notify.PersistentFlags().String("min-version", "", "Only notify the probes with at least this software version")
+	notify.PersistentFlags().String("max-version", "", "Only notify the probes with at most this software version")
+	notify.PersistentFlags().Duration("last-seen", 0, "Only notify the probes seen within this duration, example 720h")
+	notify.PersistentFlags().Bool("all", false, "Notify every probe when no filter is given")
+	notify.PersistentFlags().Bool("dry-run", false, "Print how many probes would be notified without notifying them")
+	notify.PersistentFlags().Float64("rate", 0, "Maximum number of notifications per second, 0 for no limit")
+	notify.PersistentFlags().String("progress-file", "", "File listing the notified probes, used to resume an interrupted run")
+	for _, name := range []string{"message", "country", "platform", "asn",
+		"min-version", "max-version", "last-seen", "all", "dry-run", "rate",
+		"progress-file"} {
+		viper.BindPFlag("notify."+name, notify.PersistentFlags().Lookup(name))
+	}
 }
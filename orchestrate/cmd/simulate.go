@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
+	"github.com/spf13/cobra"
+)
+
+var (
+	simulateSchedules []string
+	simulateStart     string
+	simulateDuration  time.Duration
+)
+
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Print when the given schedules would run",
+	Long: `This command replays the given schedules on a virtual clock and
+prints the time of every run. Nothing is sent to the probes and the database
+is not used.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(simulateSchedules) == 0 {
+			log.Error("at least one --schedule must be given")
+			return
+		}
+		start := time.Now().UTC()
+		if simulateStart != "" {
+			var err error
+			start, err = time.Parse(sched.ISOUTCTimeLayout, simulateStart)
+			if err != nil {
+				log.WithError(err).Error("invalid start time")
+				return
+			}
+		}
+
+		var jobs []*sched.Job
+		for i, s := range simulateSchedules {
+			schedule, err := sched.ParseSchedule(s)
+			if err != nil {
+				log.WithError(err).Errorf("invalid schedule %s", s)
+				return
+			}
+			jobs = append(jobs, sched.NewJob(fmt.Sprintf("job-%d", i+1), s,
+				schedule, 0))
+		}
+
+		runs := sched.Simulate(jobs, start, simulateDuration)
+		for _, r := range runs {
+			fmt.Printf("%s\t%s\t#%d\t%s\n", r.Time.Format(sched.ISOUTCTimeLayout),
+				r.JobID, r.TimesRun, r.Comment)
+		}
+		fmt.Printf("%d runs between %s and %s\n", len(runs),
+			start.Format(sched.ISOUTCTimeLayout),
+			start.Add(simulateDuration).Format(sched.ISOUTCTimeLayout))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(simulateCmd)
+
+	simulateCmd.Flags().StringArrayVar(&simulateSchedules, "schedule", nil, "A schedule to simulate, example R/2018-12-16T16:20:30Z/PT1H (can be repeated)")
+	simulateCmd.Flags().StringVar(&simulateStart, "start", "", "When the simulation starts (default is now)")
+	simulateCmd.Flags().DurationVar(&simulateDuration, "duration", 7*24*time.Hour, "For how long to simulate")
+}
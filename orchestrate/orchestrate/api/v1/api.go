@@ -6,6 +6,7 @@ import (
 
 	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
 )
 
 var ctx = log.WithFields(log.Fields{
@@ -14,15 +15,51 @@ var ctx = log.WithFields(log.Fields{
 })
 
 // BindAPI bind all the request handlers and middleware
-func BindAPI(router *gin.Engine, authMiddleware *middleware.GinJWTMiddleware) error {
+func BindAPI(router *gin.Engine, authMiddleware *middleware.GinJWTMiddleware, db *sqlx.DB) error {
 	v1 := router.Group("/api/v1")
 
+	// Viewers, operators and admins can read everything under /admin, except
+	// that operators only see their own jobs
+	viewer := v1.Group("/admin")
+	viewer.Use(authMiddleware.MiddlewareFunc(middleware.ViewerAuthorizor))
+	{
+		viewer.GET("/jobs", handler.ListJobsHandler)
+		viewer.GET("/job/:job_id/tasks", handler.ListJobTasksHandler)
+		viewer.GET("/job/:job_id/export", handler.ExportJobTasksHandler)
+		viewer.GET("/job-templates", handler.ListJobTemplatesHandler)
+		viewer.GET("/job-templates/:name", handler.GetJobTemplateHandler)
+		viewer.GET("/campaigns", handler.ListCampaignsHandler)
+		viewer.GET("/campaigns/:campaign_id", handler.GetCampaignHandler)
+		viewer.GET("/probe-groups", handler.ListProbeGroupsHandler)
+		viewer.GET("/probe-groups/:name", handler.GetProbeGroupHandler)
+	}
+
+	// Operators create jobs and change the jobs they own
+	operator := v1.Group("/admin")
+	operator.Use(authMiddleware.MiddlewareFunc(middleware.OperatorAuthorizor))
+	operator.Use(middleware.AuditMiddleware(db))
+	{
+		operator.POST("/job", handler.AddJobHandler)
+		operator.DELETE("/job/:job_id", handler.DeleteJobHandler)
+		operator.POST("/job/:job_id/cancel-tasks", handler.CancelJobTasksHandler)
+		operator.POST("/job/:job_id/clone", handler.CloneJobHandler)
+		operator.POST("/job-templates/:name/instantiate", handler.InstantiateJobTemplateHandler)
+	}
+
 	admin := v1.Group("/admin")
 	admin.Use(authMiddleware.MiddlewareFunc(middleware.AdminAuthorizor))
+	admin.Use(middleware.AuditMiddleware(db))
 	{
-		admin.GET("/jobs", handler.ListJobsHandler)
-		admin.POST("/job", handler.AddJobHandler)
-		admin.DELETE("/job/:job_id", handler.DeleteJobHandler)
+		admin.GET("/audit", handler.ListAuditHandler)
+		admin.POST("/job-templates", handler.AddJobTemplateHandler)
+		admin.DELETE("/job-templates/:name", handler.DeleteJobTemplateHandler)
+		admin.POST("/campaigns", handler.AddCampaignHandler)
+		admin.DELETE("/campaigns/:campaign_id", handler.DeleteCampaignHandler)
+		admin.POST("/probe-groups", handler.AddProbeGroupHandler)
+		admin.PUT("/probe-groups/:name", handler.UpdateProbeGroupHandler)
+		admin.DELETE("/probe-groups/:name", handler.DeleteProbeGroupHandler)
+		admin.POST("/probe-groups/:name/members", handler.AddProbeGroupMembersHandler)
+		admin.DELETE("/probe-groups/:name/members/:probe_id", handler.RemoveProbeGroupMemberHandler)
 	}
 
 	rendezvous := v1.Group("/")
@@ -39,10 +76,12 @@ func BindAPI(router *gin.Engine, authMiddleware *middleware.GinJWTMiddleware) er
 	device.Use(authMiddleware.MiddlewareFunc(middleware.DeviceAuthorizor))
 	{
 		device.GET("/tasks", handler.ListTasksHandler)
+		device.POST("/tasks/lease", handler.LeaseTaskHandler)
 		device.GET("/task/:task_id", handler.GetTaskHandler)
 		device.POST("/task/:task_id/accept", handler.AcceptTaskHandler)
 		device.POST("/task/:task_id/reject", handler.RejectTaskHandler)
 		device.POST("/task/:task_id/done", handler.DoneTaskHandler)
+		device.POST("/task/:task_id/lease", handler.ExtendTaskLeaseHandler)
 		device.GET("/test-list/psiphon-config", handler.PsiphonConfigHandler)
 		device.GET("/test-list/tor-targets", handler.TorTargetsHandler)
 	}
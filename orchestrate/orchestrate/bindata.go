@@ -1,15 +1,31 @@
 // Code generated by go-bindata. DO NOT EDIT.
 // sources:
+// common/data/migrations/10_job_templates.sql
+// common/data/migrations/11_idempotency_keys.sql
+// common/data/migrations/12_jobs_paused.sql
+// common/data/migrations/13_jobs_name.sql
+// common/data/migrations/14_campaigns.sql
+// common/data/migrations/15_probe_groups.sql
+// common/data/migrations/16_account_roles.sql
+// common/data/migrations/17_audit_log.sql
+// common/data/migrations/18_accounts_disabled.sql
+// common/data/migrations/19_token_revocations.sql
 // common/data/migrations/1_accounts_create.sql
 // common/data/migrations/1_active_probes_create.sql
 // common/data/migrations/1_jobs_create.sql
 // common/data/migrations/1_probe_updates_create.sql
 // common/data/migrations/1_tasks_create.sql
+// common/data/migrations/20_refresh_tokens.sql
+// common/data/migrations/21_jobs_version.sql
 // common/data/migrations/2_add_jobs_state.sql
 // common/data/migrations/2_add_language_column.sql
 // common/data/migrations/3_add_job_type_tables.sql
 // common/data/migrations/4_rendezvous_tables.sql
 // common/data/migrations/5_token_expiry.sql
+// common/data/migrations/6_task_leases.sql
+// common/data/migrations/7_task_cancelled.sql
+// common/data/migrations/8_tasks_job_index.sql
+// common/data/migrations/9_jobs_end_time.sql
 // orchestrate/data/templates/home.tmpl
 
 package orchestrate
@@ -86,6 +102,401 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
+var _bindataCommonDataMigrations10jobtemplatessql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x90\xcb\x4e\xc3\x30\x14\x44\xf7\xfe\x8a\x59\x82\xa0\x5f\xc0\xca\x69" +
+		"\x8d\x6a\xc8\x4b\xb6\x0b\x94\x4d\xe4\x36\x57\x95\xab\xda\x89\x92\x8b\xf8\x7d\x44\x2a\xc4\x43\x25\x4b\x7b\xce\xcc" +
+		"\x91\xee\x62\x81\x9b\x18\x0e\x83\x67\xc2\xaa\x7b\x4f\xe2\xe7\x87\x65\xcf\x14\x29\x71\x46\x87\x90\x84\x58\x99\xaa" +
+		"\x86\x93\x59\xae\xa0\xef\xa1\x5e\xb4\x75\x16\xc7\x6e\xd7\x30\xc5\xfe\xe4\x99\xc6\x3b\x71\x79\x40\xa5\xf6\x77\xb2" +
+		"\xe9\x67\x4d\x4b\xa3\xa4\x53\xdf\xae\xb2\x72\x17\x7d\xe2\x4a\x00\x40\xf2\x91\xf0\x24\xcd\x72\x2d\x0d\x6a\xa3\x0b" +
+		"\x69\xb6\x78\x54\xdb\xa9\x57\x6e\xf2\xfc\x76\xc2\x5a\x1a\xf7\x43\xe8\x39\x74\xe9\x8b\x3e\x07\xc7\x6e\x87\x07\x5b" +
+		"\x95\xd9\x9f\xc2\x7e\x20\xff\x49\x37\x1c\x22\xc1\xe9\x42\x59\x27\x8b\x1a\xcf\xda\xad\xa7\x27\x5e\xab\x52\x9d\x37" +
+		"\x4e\x7e\xe4\xe6\xad\x6f\x3d\x53\xfb\x2f\x2a\xae\xe7\x2e\xf4\x31\x00\xd9\xd9\xbd\xd0\x8d\x01\x00\x00")
+
+func bindataCommonDataMigrations10jobtemplatessqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations10jobtemplatessql,
+		"common/data/migrations/10_job_templates.sql",
+	)
+}
+
+func bindataCommonDataMigrations10jobtemplatessql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations10jobtemplatessqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/10_job_templates.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
+var _bindataCommonDataMigrations11idempotencykeyssql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x84\x91\xd1\x4e\x83\x30\x14\x86\xef\xfb\x14\xe7\x72\x8b\xdb\x13\xec\xaa" +
+		"\x8c\x9a\x55\xa1\x90\xd2\xe9\xe6\x4d\x43\xe0\x64\x6b\x08\x2d\xd2\x2e\xca\xdb\x9b\x60\x16\x45\x65\x5e\xf6\xfc\x5f" +
+		"\xcf\x97\xfe\x5d\xaf\xe1\xae\x35\xa7\xbe\x0c\x08\xb1\x7b\xb3\xe4\xfb\xa0\x08\x65\xc0\x16\x6d\x88\xf0\x64\x2c\x21" +
+		"\xb1\xcc\x72\x50\x34\x4a\x18\xf0\x7b\x60\x07\x5e\xa8\x02\x4c\x8d\x6d\xe7\x02\xda\x6a\xd0\x0d\x0e\x7e\x43\xfe\xde" +
+		"\xc1\x6c\x3d\x4d\xf6\xdd\x4d\xd9\x56\x32\xaa\xd8\x97\x4e\x64\x6a\x4e\x49\x16\x04\x00\xc0\x57\xae\x43\x78\xa2\x72" +
+		"\xbb\xa3\x72\xe4\xc5\x3e\x49\x56\x63\xd6\xe0\x30\x93\xf4\xf8\x7a\x41\x1f\xf4\xb9\xf4\xe7\x19\xc4\x87\x32\x5c\xbc" +
+		"\xae\x5c\x8d\xc0\x85\xba\xde\xf3\x9d\xb3\x1e\xe1\xa1\xc8\x44\xf4\x39\xab\x7a\x2c\x83\x71\x56\x07\xd3\x22\x28\x9e" +
+		"\xb2\x42\xd1\x34\x87\x67\xae\x76\xe3\x11\x5e\x32\xc1\x7e\x6c\xcf\x25\x4f\xa9\x3c\xc2\x23\x3b\xc2\x62\x7c\xc3\x0a" +
+		"\x1a\x1c\x96\x64\xb9\xb9\x96\xc0\x45\xcc\x0e\xff\x94\xa0\x27\x72\x6d\xea\x77\xc8\xc4\x2f\x0a\x16\x13\x6c\x79\xeb" +
+		"\xb7\x3e\x06\x00\x56\x84\x4d\xf6\x1c\x02\x00\x00")
+
+func bindataCommonDataMigrations11idempotencykeyssqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations11idempotencykeyssql,
+		"common/data/migrations/11_idempotency_keys.sql",
+	)
+}
+
+func bindataCommonDataMigrations11idempotencykeyssql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations11idempotencykeyssqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/11_idempotency_keys.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
+var _bindataCommonDataMigrations12jobspausedsql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x8e\xc1\x4a\xc3\x40\x10\x86\xef\x79\x8a\xff\xd6\x83\xc6\x17\x10\x0f" +
+		"\x29\x59\xb1\x52\x6c\x69\x36\x55\x4f\x32\x49\xc6\xba\xea\xce\x86\xdd\x49\xc5\xb7\x97\x25\x82\x0a\x22\x5e\xe7\x67" +
+		"\xbe\xef\x2b\x4b\x9c\x78\x77\x88\xa4\x8c\x3a\xbc\x49\x51\x96\xd8\xd3\xeb\xc4\x09\x3d\x89\x04\x45\xc7\x88\xec\xc3" +
+		"\x91\x07\x3c\xc6\xe0\x41\x02\x96\xc9\x43\xdf\x47\x3e\x45\x0a\x18\x69\x4a\x3c\xe0\x39\x74\x09\x14\x19\xe3\xa4\xe8" +
+		"\xa8\x7f\xc9\x28\x27\xd0\x27\x06\xf5\xea\x8e\x8c\xa4\x59\xe3\x24\x29\xd3\x70\x56\x7c\x77\x37\x79\xf2\x2c\xba\xe4" +
+		"\x83\x93\xa2\x68\xb7\x75\x65\xcd\x0c\x6d\x8c\xfd\x7c\xbd\xc0\x62\x46\x2d\x70\x7b\x65\x76\xe6\xeb\x3c\x47\x2c\xce" +
+		"\x8b\xdf\xa9\x46\x86\x9f\x4b\x3b\x42\x82\x46\x92\x94\x81\x41\xfe\x8c\xa9\xd6\xd6\xec\x60\xef\xb7\x06\xd7\x9b\xe5" +
+		"\x43\x63\x73\x5a\x55\xd7\xd8\x57\xeb\xd6\x60\x75\x89\x9b\x8d\x85\xb9\x5b\x35\xb6\xf9\x57\xca\xc7\x00\x90\xd5\x6d" +
+		"\x36\x76\x01\x00\x00")
+
+func bindataCommonDataMigrations12jobspausedsqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations12jobspausedsql,
+		"common/data/migrations/12_jobs_paused.sql",
+	)
+}
+
+func bindataCommonDataMigrations12jobspausedsql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations12jobspausedsqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/12_jobs_paused.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
+var _bindataCommonDataMigrations13jobsnamesql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x90\x41\x4f\x02\x31\x14\x84\xef\xfd\x15\x73\x43\xa3\xfc\x02\x4e\x65" +
+		"\x5b\x42\x13\xe8\x6a\xb7\xab\xdc\x48\xa1\x2f\x50\x85\x2e\xa1\x35\xfa\xf3\x4d\x17\x13\x97\x68\x38\xb6\xef\xcd\x7c" +
+		"\xf3\x66\x3c\xc6\xc3\x31\xec\xce\x2e\x13\x44\xf7\x19\xd9\xf0\xa3\xc9\x2e\xd3\x91\x62\x9e\xd2\x2e\x44\xc6\x84\xa9" +
+		"\x9f\xa0\xb4\x90\x2b\xa8\x19\xe4\x4a\x35\xb6\xc1\x5b\xb7\x49\xeb\xe8\x8e\xb4\x0e\xfe\x6b\xc2\xf8\xc2\x4a\x03\xcb" +
+		"\xa7\x0b\xd9\x8f\xd0\x8b\xaa\x7a\xd1\x2e\xf5\x40\x55\x04\x13\xf6\x3f\x4d\x46\x7f\x3d\x69\x4f\x37\x63\xfd\x41\x72" +
+		"\x21\x06\x44\x5d\xdb\x21\x15\x2f\xdc\x54\x73\x6e\x26\xc5\x53\xd0\x81\x32\xf9\x4b\xd2\x77\xa2\x13\xf2\x9e\xc2\xb9" +
+		"\x5f\x7c\x44\xea\x90\xf7\x2e\xc3\xf5\x6f\x6c\x5d\xc4\x86\x70\xa6\x8f\x44\x1e\x5d\xdc\x52\xd9\x2e\xda\x62\xb5\xef" +
+		"\x0e\x3e\xc4\x1d\x42\x46\x48\xf0\x17\x63\x56\x19\xc9\xad\x44\xab\xd5\x73\x2b\x7f\xbb\x1b\x64\xba\xea\x0f\xb5\x2e" +
+		"\x7e\x09\x77\x85\x78\xcf\x00\xe0\x75\x2e\x8d\x44\x2a\x47\x43\x35\x10\xaa\xb1\x4a\x57\x16\x33\x53\x2f\x31\xfa\x01" +
+		"\x8d\x6e\x95\xf9\x3d\x00\x77\x35\xbe\xb2\xe5\x01\x00\x00")
+
+func bindataCommonDataMigrations13jobsnamesqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations13jobsnamesql,
+		"common/data/migrations/13_jobs_name.sql",
+	)
+}
+
+func bindataCommonDataMigrations13jobsnamesql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations13jobsnamesqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/13_jobs_name.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
+var _bindataCommonDataMigrations14campaignssql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x92\x41\x6e\xf2\x30\x10\x85\xf7\x3e\xc5\x2c\x41\xff\xcf\x09\xb2\x32" +
+		"\xf1\x20\xac\x26\x0e\x75\xec\x16\xba\x89\x5c\x6c\x21\x57\x8d\x89\x12\x57\xed\xf1\xab\x24\x20\xa5\x40\x51\x97\x99" +
+		"\xf9\x66\xde\xe4\x3d\x2f\x16\xf0\xaf\xf6\x87\xd6\x44\x07\xec\xf8\x19\xc8\xb4\x50\x46\x13\x5d\xed\x42\x5c\xba\x83" +
+		"\x0f\x84\xd0\x4c\xa1\x04\x45\x97\x19\xc2\xdb\xf1\xb5\x03\x26\x8b\x0d\xa4\x45\xa6\x73\x01\x7c\x05\xb8\xe5\xa5\x2a" +
+		"\x61\x6f\xea\xc6\xf8\x43\xa8\xbc\x4d\xc8\x80\x8c\x23\xd7\x44\x97\x90\xdb\x82\x18\xec\xcf\x8e\x6e\xee\x5e\x96\x4a" +
+		"\xa4\x0a\x4f\xa7\xf1\x15\x88\x42\x5d\x69\x91\x19\x01\x00\xf0\x16\xb4\xe6\x0c\x36\x92\xe7\x54\xee\xe0\x01\x77\x03" +
+		"\x2e\x74\x96\xfd\x1f\x88\x60\x6a\x07\x4f\x54\xa6\x6b\x2a\x41\x0b\xfe\xa8\xf1\x82\xb0\xae\xdb\xb7\xbe\x89\xfe\x18" +
+		"\xce\xe0\x38\xda\x45\xd3\xc6\x2a\xfa\xda\x81\xe2\x39\x96\x8a\xe6\x1b\x78\xe6\x6a\x3d\x7c\xc2\x4b\x21\x2e\x57\xb9" +
+		"\x60\xef\xf3\x23\xb6\x6f\x9d\xe9\xe5\xfe\xc2\xbe\x9b\x2e\x56\x1f\x8d\x35\xd1\xd9\x5f\x51\x32\x4f\x6e\x04\x4a\x19" +
+		"\x9b\xe4\x79\xc3\xc5\xea\x64\xdf\x70\x94\xc4\x15\x4a\x14\x29\x4e\x5c\x86\x99\xb7\x73\x28\x04\x30\xcc\x50\x21\x94" +
+		"\x38\xfe\x6d\x72\xce\x88\x0b\x86\xdb\x8b\xed\xbd\x76\x35\x91\xa8\xbc\xfd\xea\x77\xf4\x75\x98\x4d\x1a\xf3\x7b\x0f" +
+		"\xe6\x7b\x00\xed\x4a\xf4\xd8\xcc\x02\x00\x00")
+
+func bindataCommonDataMigrations14campaignssqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations14campaignssql,
+		"common/data/migrations/14_campaigns.sql",
+	)
+}
+
+func bindataCommonDataMigrations14campaignssql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations14campaignssqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/14_campaigns.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
+var _bindataCommonDataMigrations15probegroupssql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x93\xd1\x8e\x9b\x3c\x10\x85\xef\x79\x8a\x73\x99\xe8\xdf\xec\x0b\xe4" +
+		"\xca\x01\xaf\x96\xbf\x04\x22\x30\x6d\xb7\x55\x85\x9c\x78\x96\xb8\x02\x83\xb0\xb7\xdb\xbe\x7d\x15\x03\x4a\xd2\x6e" +
+		"\x57\x91\x72\x93\x99\x6f\x3c\x67\xce\x30\xab\x15\xfe\x6b\x75\x3d\x48\x47\x88\xba\x57\x13\x5c\x06\x0a\x27\x1d\xb5" +
+		"\x64\xdc\x86\x6a\x6d\x82\x80\x25\x82\xe7\x10\x6c\x93\x70\x7c\xef\xf6\x16\x51\x9e\xed\x10\x66\x49\xb9\x4d\x11\x3f" +
+		"\x80\x7f\x8e\x0b\x51\xc0\xc9\xa1\x26\x57\xd5\x43\xf7\xd2\xdb\x75\xe0\xa1\xb1\xe8\xcc\xf4\x43\xb7\xa7\x11\xa9\x5a" +
+		"\x6a\xf7\x34\xdc\x40\xda\x75\xf0\xb6\x3e\x6e\xd4\x75\xa6\xec\xdf\x1d\x24\xcc\x39\x13\xfc\xdc\x2a\xcd\xc4\x5b\xed" +
+		"\x82\x45\x00\x00\x46\xb6\x84\x8f\x2c\x0f\x1f\x59\x8e\x5d\x1e\x6f\x59\xfe\x84\x0f\xfc\xc9\x97\xa5\x65\x92\xdc\x79" +
+		"\x4c\x91\x3d\x0c\xba\x77\xba\x33\x33\x3d\x26\x56\x2b\x3c\xeb\xc6\xd1\x00\x6d\x21\x0d\x3a\xcf\xc8\x06\x56\xfe\x20" +
+		"\x35\x76\x9c\x88\x7b\x88\x23\x8d\x11\x8b\x56\xba\xc3\x51\x9b\x1a\xda\x41\x0e\x34\xbf\x35\xd9\x85\xee\x19\xee\x48" +
+		"\xf0\x52\x21\x9b\xce\xd4\x78\xd5\xee\xe8\x83\x9d\x21\x0b\xa9\x14\x29\xd0\xcf\xbe\xd1\x07\xed\x9a\x5f\xf7\xfe\x85" +
+		"\x49\xca\xff\x45\x96\x6e\x46\x7d\x87\x81\xe4\x49\x51\xe5\x74\x4b\x10\xf1\x96\x17\x82\x6d\x77\xf8\x14\x8b\x47\xff" +
+		"\x17\x5f\xb2\x94\x8f\x6c\x23\xad\xab\x5e\x7a\x25\x1d\xa9\x7f\xa2\xc1\x72\x7d\xab\xc7\xf3\xf2\x27\xab\xfd\x30\xd5" +
+		"\x95\xe1\xb3\xc9\x3e\x7f\xfa\xe5\xfc\x81\xe7\x3c\x0d\xf9\xf5\xb2\xb0\x38\x95\x2d\x91\xa5\x88\x78\xc2\x05\x47\xc8" +
+		"\x8a\x90\x45\x93\xf0\x11\xd5\x0a\x65\x19\x47\x7f\x6c\xce\x1b\x75\xcb\xf4\x97\xcb\x5f\x9c\xb5\xde\x4d\x42\xb4\x5a" +
+		"\xfa\xd1\xff\xba\x13\x16\x45\x17\x67\x72\xe1\xc4\xd5\xa9\xcc\x23\x7f\xfd\xf6\xde\x77\xfe\x7b\x00\x1e\x32\xf8\x1e" +
+		"\xb2\x03\x00\x00")
+
+func bindataCommonDataMigrations15probegroupssqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations15probegroupssql,
+		"common/data/migrations/15_probe_groups.sql",
+	)
+}
+
+func bindataCommonDataMigrations15probegroupssql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations15probegroupssqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/15_probe_groups.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
+var _bindataCommonDataMigrations16accountrolessql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xa4\x91\xcd\x8e\xa2\x40\x14\x85\xf7\x3c\xc5\xd9\xa1\x19\x99\x17\x30\xb3" +
+		"\x28\xa1\x26\x92\x30\x60\xf8\x71\x9c\x95\x29\xe1\x8e\x4d\xb7\xdc\x32\x45\xa1\xdd\x6f\xdf\x01\xed\x60\x27\x1d\xd3" +
+		"\x89\xdb\x53\xb7\xee\xf9\xf2\x5d\xcf\xc3\x8f\xa6\xde\x1b\x65\x09\x81\x3e\xb3\xe3\x79\x58\xab\x43\x47\x2d\x4a\xc5" +
+		"\xac\x2d\x76\x04\x43\x8d\x3e\x51\x85\xff\x46\x37\x50\x0c\xe2\xae\x81\x7d\x3b\xd2\x0c\xad\x86\x7d\x22\xe8\x23\x19" +
+		"\x65\xb5\x81\xe2\x0a\xa7\x9a\xce\x64\xfa\x4d\xaa\x2c\x75\xc7\xb6\x85\x32\x04\xdb\x19\xa6\x0a\x3b\x55\xbe\xa0\x66" +
+		"\xab\xd1\xb5\x64\xc6\x91\x9a\x5b\x4b\xaa\xfa\xe9\xdc\x22\x65\x56\x59\x6a\x88\xed\x82\xf6\x35\x3b\x4e\xb1\x0a\x44" +
+		"\x2e\xc7\x4f\x99\xcc\x61\xf4\x81\xf0\x0b\x6e\xbf\xce\xc5\xdf\xa5\x4c\xe5\x25\x0b\x63\x4c\xdc\x0f\x32\x77\x06\xf7" +
+		"\x02\xe6\x4e\xe7\x8e\x88\x72\x99\x22\x17\x8b\x48\xe2\x59\xef\x5a\x04\x69\xb2\x82\x9f\x44\xc5\x9f\x18\xfa\xcc\x64" +
+		"\xe6\xce\xd7\x20\x92\xab\xcf\x2f\xc5\x11\xac\xad\x51\xdc\xaa\xd2\xd6\x9a\xef\xf2\x5f\x7b\xff\xad\x24\x84\xef\x27" +
+		"\x45\x9c\x6f\xd3\x24\x92\x10\x41\x80\xb5\x88\x0a\x89\xf0\x37\xe2\x24\x87\xdc\x84\x59\x9e\x61\xc4\xff\x36\xcf\xc3" +
+		"\x95\x57\x4b\x8f\x16\x8e\x6e\xfb\xa6\x5b\xb5\x58\x8b\xd4\x5f\x8a\x74\xee\xf8\xa9\xec\xcf\x19\xc6\x81\xdc\x0c\xb3" +
+		"\xdb\xc1\xfd\xb6\xae\x5e\x91\xc4\x43\x82\xc9\x10\x4d\xef\xe1\xbc\x0f\x00\x21\x02\x79\xac\xc5\x02\x00\x00")
+
+func bindataCommonDataMigrations16accountrolessqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations16accountrolessql,
+		"common/data/migrations/16_account_roles.sql",
+	)
+}
+
+func bindataCommonDataMigrations16accountrolessql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations16accountrolessqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/16_account_roles.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
+var _bindataCommonDataMigrations17auditlogsql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x94\x93\xdd\x6e\xa3\x3c\x10\x86\xcf\x7d\x15\x73\x10\xa9\xad\xbe\xf6\x0a" +
+		"\x38\x72\x60\x42\xac\x8f\x18\x64\xcc\x36\xdd\x13\x44\xb1\x45\x2c\x25\x36\xeb\x38\xdb\xed\xdd\xaf\x42\x9a\x1f\xaa" +
+		"\x24\xd2\x1e\xf2\xbe\x33\x9e\x67\x7e\x78\x79\x81\xff\x36\xa6\xf3\x4d\xd0\x90\xb8\x0f\x4b\x2e\x85\x32\x34\x41\x6f" +
+		"\xb4\x0d\x53\xdd\x19\x4b\x48\x22\xf2\x02\x24\x9d\x66\x08\x6c\x06\xb8\x64\xa5\x2c\xa1\xd9\x29\x13\xea\xb5\xeb\xa2" +
+		"\x83\x3f\xab\x78\x2c\x59\xce\xaf\x85\xd4\x4d\xdf\x6b\xab\x6a\x67\xd7\x9f\x8f\x4f\x11\xb9\x5e\x0d\xad\x1a\x3b\x55" +
+		"\x7f\x17\x2b\x16\x48\x25\x7e\x81\x9d\x6a\x91\x47\x02\x00\x60\x14\x4c\x59\x5a\xa2\x60\x34\x83\x42\xb0\x05\x15\x6f" +
+		"\xf0\x3f\xbe\x01\xcf\x25\xf0\x2a\xcb\x9e\x87\xb0\x60\x36\x1a\x24\x5b\x60\x29\xe9\xa2\x80\x57\x26\xe7\xc3\x27\xfc" +
+		"\xcc\x39\x7e\x8b\x6d\xda\xe0\x3c\xfc\xa0\x22\x9e\x53\x71\x48\xf7\x6e\xad\xc7\x4a\xd3\x06\xe3\xec\x51\xfb\x5e\xad" +
+		"\xf1\x9d\x0e\xb5\x51\xe3\x9c\x77\xa7\x3e\x6b\x65\x3a\xbd\x0d\x63\x63\xeb\x76\xbe\xd5\xb5\xe9\x8f\x32\x79\x8a\x8e" +
+		"\x6d\x33\x9e\xe0\xf2\x72\xc4\x7b\xba\xda\xa8\x3f\x90\xf3\xb3\x0c\x8f\x03\xf5\xed\xb4\x13\xd2\x95\xd4\x93\xf7\xb5" +
+		"\x32\xb9\xd2\x87\x97\x61\x6f\x9b\x2d\x1c\xd6\x0a\xfb\xb5\x3e\x83\x77\x1f\x5b\x68\x1b\x0b\xef\x1a\xac\x36\x61\xa5" +
+		"\x3d\xb4\xab\xc6\x76\x5a\x81\x75\x1e\xbc\xde\xb8\xdf\x5a\x1d\x41\x4e\xf7\x72\xe3\x4a\x40\xa0\xac\x04\x2f\x21\x78" +
+		"\xd3\x75\xda\x03\x2d\x61\x32\x21\x53\x4c\x19\x1f\x86\x29\x28\x2b\x11\x70\x19\x63\x31\xbc\xf3\x70\x26\x1f\xa3\x3d" +
+		"\x44\x04\x79\x12\x91\xc9\x04\x32\xca\xd3\x8a\xa6\x08\xfd\xba\xef\xb6\xbf\xd6\xd1\xf9\x8a\x04\x4b\x53\x14\x17\x93" +
+		"\xb1\xae\xde\xf5\x6a\x7f\x87\x53\x9c\xe5\x02\xa1\x2a\x92\x3d\x78\x2e\x20\xc1\x0c\x25\x8e\xa6\x35\x20\xcd\x72\x01" +
+		"\x48\xe3\x39\x88\xfc\x15\x70\x89\x71\x25\x11\x0a\x91\xc7\x98\x54\x02\x6f\x75\x1a\xdd\x65\x08\x7e\x67\xdb\x0b\x0a" +
+		"\x29\x2a\x1e\xd3\xbb\xd5\x4b\x49\x25\x2e\x90\xcb\x7f\x61\xb8\xfe\xab\xa1\x55\xe4\xef\x00\xc1\xb2\x2b\xd8\x2c\x04" +
+		"\x00\x00")
+
+func bindataCommonDataMigrations17auditlogsqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations17auditlogsql,
+		"common/data/migrations/17_audit_log.sql",
+	)
+}
+
+func bindataCommonDataMigrations17auditlogsql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations17auditlogsqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/17_audit_log.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
+var _bindataCommonDataMigrations18accountsdisabledsql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x84\x8f\x51\x4b\xc3\x30\x14\x85\xdf\xf3\x2b\xce\xa3\x22\xfb\x05\x7d\x4a" +
+		"\x97\x5b\x28\x5c\x13\x6d\x13\xd8\xdb\x88\xcb\x65\x04\x6c\x26\x6b\x8a\xfe\x7c\x51\xd0\xa9\x8c\xfa\x7a\xcf\xe1\x7e" +
+		"\xe7\xdb\x6c\x70\x37\xe5\xe3\x39\x56\x81\x39\xbd\x16\xf5\xf3\x30\xd6\x58\x65\x92\x52\x5b\x39\xe6\xa2\x94\x19\xdc" +
+		"\x03\x7a\x6b\x68\x87\xbe\x03\xed\xfa\xd1\x8f\x88\x87\xc3\x69\x29\x75\xde\x2f\xb3\x9c\x4b\x9c\x64\xbf\xe4\x92\xe4" +
+		"\xad\x51\x9a\x3d\x0d\xf0\xba\x65\xfa\x6e\xe1\xf3\xc7\xd6\x71\xb8\xb7\x48\x79\x8e\x4f\xcf\x92\x1a\x75\x1d\x4b\x25" +
+		"\xfd\x4e\xc2\xcb\xea\xbe\xab\x40\x6d\xcc\x5f\x1e\x5a\xe7\x98\xb4\x85\x75\x1e\x36\x30\xc3\x50\xa7\x03\x7b\x74\x9a" +
+		"\x47\x6a\xd4\x76\x20\xed\x09\xc1\xf6\x8f\x81\x2e\xc6\x1f\xf5\x7f\xac\xe1\xec\x05\x7d\xf3\x15\xde\xae\x19\xbe\x0f" +
+		"\x00\x55\x69\x76\xf6\x83\x01\x00\x00")
+
+func bindataCommonDataMigrations18accountsdisabledsqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations18accountsdisabledsql,
+		"common/data/migrations/18_accounts_disabled.sql",
+	)
+}
+
+func bindataCommonDataMigrations18accountsdisabledsql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations18accountsdisabledsqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/18_accounts_disabled.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
+var _bindataCommonDataMigrations19tokenrevocationssql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x92\x41\x6f\xe2\x30\x14\x84\xef\xfe\x15\x73\x04\xed\xe6\x17\xe4\x14" +
+		"\xc0\x2b\xa2\x85\x04\x25\xa6\x85\x5e\x50\xc0\x0f\x70\x69\xec\xc8\x36\x2d\x3f\xbf\x4a\x9a\x88\x12\x21\xc4\x31\xef" +
+		"\xcd\x9b\x19\x7d\x71\x10\xe0\x4f\xa9\x0e\xb6\xf0\x84\x89\xf9\xd2\xec\xf7\x20\xf7\x85\xa7\x92\xb4\x1f\xd1\x41\x69" +
+		"\xc6\x26\x59\xba\x80\x88\x46\x33\x8e\xf8\x1f\xf8\x2a\xce\x45\x0e\x4b\x9f\xe6\x44\x72\xe3\xcd\x89\xb4\x0b\x1f\x8b" +
+		"\xce\x8e\xac\x0b\xd9\xfd\x14\xae\xe5\xed\x66\x59\x3d\xac\x33\xce\x78\x24\xf8\x35\x2b\x49\xc5\xfd\x52\x6c\xc0\x00" +
+		"\xe0\xdd\x2b\xbc\x44\xd9\x78\x1a\x65\x58\x64\xf1\x3c\xca\xd6\xf8\xcf\xd7\xcd\x5d\xb2\x9c\xcd\xfe\x36\xaa\xba\xa2" +
+		"\x2e\x4a\xea\xa4\x3f\xd3\xce\xb0\xf0\x10\xf1\x9c\xe7\x22\x9a\x2f\xf0\x1a\x8b\x69\xf3\x89\xb7\x34\xe1\x3d\x9f\x20" +
+		"\x80\x38\x52\x83\x67\x57\x78\x65\x34\x76\x85\xc6\x96\x20\xad\xa9\x2a\x92\x30\x7a\x47\xf0\x47\x42\x53\xb2\xd9\x6a" +
+		"\x83\x0f\xa3\x0f\x64\x6b\xdd\xd9\x91\xec\x9c\x8c\x85\xa5\xbd\x25\x77\x6c\x67\x74\xa9\x94\x25\xf7\x54\x1f\x36\x0c" +
+		"\x3b\x56\x71\x32\xe1\xab\x1e\x9d\xcd\xd5\x6b\xa3\xe4\x05\x69\xd2\x13\x60\x70\x55\x0c\xc3\xa7\xb8\xd7\x10\x3b\xec" +
+		"\x7d\xa0\x0f\xd8\xb7\xcc\xda\x58\xb3\x6f\xf0\xd4\xf7\x50\xce\x9d\x49\x62\x4b\x7b\x63\x6b\x6a\xca\xc1\xab\x92\x50" +
+		"\x58\xea\x42\x6f\xfe\x53\x2b\x7c\x8a\xcd\xfd\x47\xc6\xb5\x64\xdf\x03\x00\x4b\x7d\xa7\xe6\x1e\x03\x00\x00")
+
+func bindataCommonDataMigrations19tokenrevocationssqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations19tokenrevocationssql,
+		"common/data/migrations/19_token_revocations.sql",
+	)
+}
+
+func bindataCommonDataMigrations19tokenrevocationssql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations19tokenrevocationssqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/19_token_revocations.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
 var _bindataCommonDataMigrations1accountscreatesql = []byte(
 	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x52\xc1\x8e\xda\x30\x10\xbd\xfb\x2b\xde\x01\x29\xa0\xee\x1e\x7a\x8e" +
 		"\x7a\x30\xc9\x50\xac\x26\x0e\x75\x9c\xee\xd2\x4b\x64\x25\x16\x6b\x09\x4c\x84\x4d\x77\xf7\xef\x2b\x42\xa9\x36\x52" +
@@ -294,6 +705,86 @@ func bindataCommonDataMigrations1taskscreatesql() (*asset, error) {
 	return a, nil
 }
 
+var _bindataCommonDataMigrations20refreshtokenssql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x94\x53\x4f\x6f\xda\x30\x14\xbf\xe7\x53\xfc\x8e\xad\x46\x76\x98\xb4\x5d" +
+		"\x7a\x4a\x89\x27\xa2\x41\x82\xf2\x67\x6b\x77\x89\x2c\xf2\x20\x5e\xc1\x46\x7e\xa6\xd0\x6f\x3f\x91\xd4\x85\x46\x2d" +
+		"\x55\x6f\xe0\xf7\xfb\xfb\xec\x84\x21\xbe\x6c\xd4\xca\x4a\x47\x88\xcd\x5e\x07\xe7\x07\x85\x93\x8e\x36\xa4\xdd\x2d" +
+		"\xad\x94\x0e\x82\x38\xcf\xe6\x28\xa3\xdb\xa9\x40\xf2\x13\xe2\x2e\x29\xca\x02\x96\x96\x96\xb8\xad\x9d\x79\x20\xcd" +
+		"\x37\xc1\xdb\x0a\x42\x37\xaf\x27\xd5\xf6\xa2\xd5\x38\x17\x51\x29\x4e\x66\x69\x56\xbe\x6d\x18\x5c\x05\x00\x10\x86" +
+		"\x28\x5b\x42\x4b\x07\x90\x5e\x98\x86\x1a\x14\x93\xe8\xdb\xf7\x1f\x30\x4b\xb8\x96\xd0\xa1\x47\xa7\x9f\x50\x8e\x69" +
+		"\xbd\x84\x62\x68\xe3\xc0\xce\x58\x6a\x3a\xa9\x0e\x59\xb7\x92\x5b\xfc\x8e\xf2\xf1\x24\xca\x31\xcf\x93\x59\x94\xdf" +
+		"\xe3\x97\xb8\xef\x92\xa4\xd5\x74\x3a\xf2\xbe\xe2\x91\xec\x93\x4f\x05\x4b\xdb\xb5\x5c\x10\x9f\x39\xed\x95\x6b\x21" +
+		"\xa1\x69\x0f\xa3\xc9\x07\x62\xb9\x21\x2c\xe5\x46\xad\x9f\xbe\x9e\x57\xe8\x8f\x90\xc4\xc7\x68\x47\xe0\x3f\xa7\x3c" +
+		"\x47\x2e\x16\xc4\xdc\xcb\x32\x14\xf3\x8e\x9a\x5e\x5e\xb9\x5e\xa4\x67\xd7\xaa\x41\x55\x25\xf1\x20\xec\x8e\xc9\xea" +
+		"\xa3\xad\xef\xf5\x7a\x6c\xcd\x9a\x10\x8d\xc7\x59\x95\x96\x75\x9e\x4d\xc5\x60\xde\xfb\xd5\xd2\xa1\x4c\x66\xa2\x28" +
+		"\xa3\xd9\x1c\x7f\x92\x72\xd2\xfd\xc5\xdf\x2c\x1d\x12\xe8\xb0\x55\x96\xf8\x13\x8c\x30\x44\x41\x0e\xfb\x96\xf4\xf9" +
+		"\x55\xb1\x5f\x6b\x33\xc2\x8e\x95\x5e\x41\x39\xc8\x95\x54\x1a\x96\x1e\xcd\xc3\xf3\xba\xfb\xf2\xbe\xea\xc5\xa4\xcf" +
+		"\x8d\x3b\xf2\x45\x60\x70\x7d\xe3\x1f\x63\x92\xc6\xe2\x6e\xf0\xfc\xea\x97\x85\xd7\xaa\x39\x20\x4b\x07\x73\x5c\xbd" +
+		"\x00\x3e\x10\xf2\x97\xf3\x9e\x8e\x9f\x7f\x20\x73\xda\xf9\x7b\x42\x27\xc4\xf5\xa5\xaf\xf5\xff\x00\x5f\xfa\x22\xf4" +
+		"\x1a\x04\x00\x00")
+
+func bindataCommonDataMigrations20refreshtokenssqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations20refreshtokenssql,
+		"common/data/migrations/20_refresh_tokens.sql",
+	)
+}
+
+func bindataCommonDataMigrations20refreshtokenssql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations20refreshtokenssqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/20_refresh_tokens.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
+var _bindataCommonDataMigrations21jobsversionsql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\xcf\xc1\x6a\xf3\x30\x10\x04\xe0\xbb\x9f\x62\xc8\xf5\xff\x0d\xbd\xf7" +
+		"\x64\x57\x4a\x31\xa8\x76\xb1\x65\xe8\x55\x89\x36\xb6\x42\xbc\x0a\xd2\x3a\xa5\x6f\x5f\x1a\x68\x69\x69\xc9\x75\x06" +
+		"\x66\xf8\xca\x12\xff\x96\x30\x25\x27\x04\x15\x5f\xb9\xf8\x1e\x0c\xe2\x84\x16\x62\xa9\x69\x0a\x5c\x14\x95\xb1\xba" +
+		"\x87\xad\x6a\xa3\x71\x8c\xbb\x0c\xd5\x77\xcf\x78\xe8\xcc\xf8\xd4\xa2\xd9\x42\xbf\x34\x83\x1d\xb0\xb9\x50\xca\x21" +
+		"\xf2\xe6\xbe\xf8\x7b\x4e\xb3\xff\xd9\x8c\xe7\x9b\xbf\x65\x89\x7a\x5d\xce\xe4\x41\x17\x4a\x6f\x90\xb0\x10\x64\x26" +
+		"\x78\x3a\x04\x0e\x12\x22\x23\x1e\xae\xc9\x31\xee\xb0\x9f\x1d\x4f\x94\xff\x23\x47\xc8\xec\xe4\x5a\xa4\x95\x39\xf0" +
+		"\xf4\xf1\x93\xf7\x33\xf9\xf5\x44\x29\x23\xd1\x29\x3a\x8f\x20\xbf\x6d\x95\x52\x9f\xb4\x2f\x10\x9a\xd6\xea\x47\xdd" +
+		"\xa3\xed\x2c\xda\xd1\x18\x28\xbd\xad\x46\x63\x71\x77\xcb\xfa\x3e\x00\x1c\xb0\x45\x55\x65\x01\x00\x00")
+
+func bindataCommonDataMigrations21jobsversionsqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations21jobsversionsql,
+		"common/data/migrations/21_jobs_version.sql",
+	)
+}
+
+func bindataCommonDataMigrations21jobsversionsql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations21jobsversionsqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/21_jobs_version.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
 var _bindataCommonDataMigrations2addjobsstatesql = []byte(
 	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x91\x4f\x8f\x9b\x30\x10\xc5\xef\xfe\x14\xef\x80\xe4\x5d\xb5\x5b\xa9" +
 		"\x67\xd4\x03\x7f\x86\xc6\x15\x31\x11\x38\xda\xed\x09\xd8\x60\x45\xac\xc0\xa0\xe0\xb4\xcd\xb7\xaf\x70\x9b\x7f\x4d" +
@@ -507,6 +998,147 @@ func bindataCommonDataMigrations5tokenexpirysql() (*asset, error) {
 	return a, nil
 }
 
+var _bindataCommonDataMigrations6taskleasessql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x84\x90\x41\x4b\x03\x31\x10\x85\xef\xf9\x15\x43\x4f\x8a\xf6\x17\xec\x29" +
+		"\x6d\x46\x0c\xec\x26\x65\x33\xc5\xe2\x25\x44\x76\x28\x41\x9b\x2e\x9b\x80\xf5\xdf\x8b\xab\xc5\x2e\xca\xf6\x98\xbc" +
+		"\x99\xf7\xbd\x37\xcb\x25\xdc\x1d\xe2\x7e\x08\x85\x41\x1d\xdf\x93\xb8\xfc\x70\x25\x14\x3e\x70\x2a\x2b\xde\xc7\x24" +
+		"\x84\x6a\xed\x06\xb4\x51\xb8\x03\xfd\x00\xb8\xd3\x8e\x1c\x94\x90\x5f\xb3\xef\x87\xe3\x0b\xfb\xd8\xf9\xfc\xb5\xe3" +
+		"\x63\x77\xaa\x84\xac\x09\x5b\x20\xb9\xaa\xf1\x7b\x0a\x46\x83\xb5\xad\xb7\x8d\xb9\x70\x58\xbc\x71\xc8\xec\xf9\xd4" +
+		"\xc7\xe1\x63\x51\x89\xff\x33\x60\xea\xa6\xca\xb6\x9f\x0d\xfb\x97\x2e\x95\x3a\xc3\xa7\x48\x20\xdd\xa0\x23\xd9\x6c" +
+		"\xe0\x49\xd3\xe3\xf8\x84\x67\x6b\xb0\x12\xeb\x16\x25\xe1\x6f\x69\x63\xe9\x5a\x71\xb0\xe6\x07\x78\x73\x16\xef\x61" +
+		"\x3c\xcb\xed\x5c\xb7\xcf\x01\x00\xa7\xf2\x3d\x86\x8a\x01\x00\x00")
+
+func bindataCommonDataMigrations6taskleasessqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations6taskleasessql,
+		"common/data/migrations/6_task_leases.sql",
+	)
+}
+
+func bindataCommonDataMigrations6taskleasessql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations6taskleasessqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/6_task_leases.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
+var _bindataCommonDataMigrations7taskcancelledsql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x8e\xcd\x4a\x34\x31\x10\x45\xf7\x79\x8a\xbb\xeb\xc5\xf7\xb5\x2f\x20" +
+		"\x2e\x22\x1d\x71\x70\xd0\x61\x92\x1e\x75\x25\x65\xa7\x1c\xda\xe9\x54\x86\xa4\x46\xf1\xed\xa5\xc1\x5f\x10\x71\x7d" +
+		"\x0f\xe7\x9e\xb6\xc5\xbf\x34\x6e\x0b\x29\xa3\xcb\xcf\x62\xda\x16\x1b\x9a\x0e\x5c\x31\x90\x48\x56\xdc\x33\x0a\xa7" +
+		"\xfc\xc4\x11\x0f\x25\x27\x90\x80\xe5\x90\xa0\x2f\x7b\xfe\x8f\x9a\x67\x6e\xe0\x69\xe2\x08\xa5\xba\xab\xa0\xc2\xb3" +
+		"\x25\x51\xd9\x71\x04\x55\x14\x7e\xe4\x41\x39\x62\x94\xaa\x4c\xf1\xc8\x7c\x7d\xf5\x4a\xca\x89\x45\x4f\x79\x3b\x8a" +
+		"\x31\xfd\xaa\xb3\xc1\xbd\xb9\xbc\x0b\xa8\x33\x80\x13\x34\xef\x9e\x06\xd7\xe7\x6e\xed\x3e\x87\x8f\x82\xe6\xd8\xfc" +
+		"\xec\x76\x12\xbf\x2f\xfd\x1e\x92\xb5\x90\x54\x1a\x74\xcc\xf2\x6b\x92\x5d\x06\xb7\x46\xb8\x5d\x39\x04\xeb\x2f\xee" +
+		"\x7c\x98\x0b\x6d\xd7\x61\x63\x97\xbd\xc3\xe2\x0c\x97\x57\x01\xee\x66\xe1\x83\xff\x6b\xce\xeb\x00\x2b\x16\x8c\x90" +
+		"\x7a\x01\x00\x00")
+
+func bindataCommonDataMigrations7taskcancelledsqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations7taskcancelledsql,
+		"common/data/migrations/7_task_cancelled.sql",
+	)
+}
+
+func bindataCommonDataMigrations7taskcancelledsql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations7taskcancelledsqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/7_task_cancelled.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
+var _bindataCommonDataMigrations8tasksjobindexsql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xd2\xd5\x55\xd0\xce\xcd\x4c\x2f\x4a\x2c\x49\x55\x70\xc9\x2f\xcf\xe3\x42" +
+		"\x16\x08\x2e\x49\x2c\x49\xcd\x4d\xcd\x2b\x71\x4a\x4d\xcf\xcc\xe3\xe2\x72\x09\xf2\x0f\x50\xf0\xf4\x73\x71\x8d\x50" +
+		"\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56\x28\x49\x2c\xce\x2e\x8e\xcf\xca\x4f\x8a\xcf\x4c\x89\x4f\x2e\x4a\x4d\x2c" +
+		"\xc9\xcc\xcf\x8b\x2f\xc9\xcc\x4d\x8d\xcf\x4c\xa9\xb0\xe6\xc2\x6e\xa2\x6b\x5e\x0a\xaa\x4c\x68\x01\x5e\xab\x9d\x83" +
+		"\x5c\x1d\x43\x5c\x11\x96\xfb\xf9\x87\x10\xe7\x00\x05\x7f\x3f\x88\x13\x15\x34\x20\x4a\x74\x14\x50\xd4\xe8\x28\x64" +
+		"\xa6\x68\xe2\x73\x25\x60\x00\x5f\x2d\x62\x21\x22\x01\x00\x00")
+
+func bindataCommonDataMigrations8tasksjobindexsqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations8tasksjobindexsql,
+		"common/data/migrations/8_tasks_job_index.sql",
+	)
+}
+
+func bindataCommonDataMigrations8tasksjobindexsql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations8tasksjobindexsqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/8_tasks_job_index.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
+var _bindataCommonDataMigrations9jobsendtimesql = []byte(
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xd2\xd5\x55\xd0\xce\xcd\x4c\x2f\x4a\x2c\x49\x55\x70\xc9\x2f\xcf\xe3\x42" +
+		"\x16\x08\x2e\x49\x2c\x49\xcd\x4d\xcd\x2b\x71\x4a\x4d\xcf\xcc\xe3\xe2\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71" +
+		"\x55\xc8\xca\x4f\x2a\x56\x70\x09\xf2\x0f\x50\x70\xf6\xf7\x09\xf5\xf5\x53\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56" +
+		"\x50\x4a\xcd\x4b\x89\x2f\xc9\xcc\x4d\x55\xb2\xe6\xc2\x6e\x9e\x6b\x5e\x0a\xaa\x4c\x68\x01\x69\x16\x3b\xba\xb8\xc0" +
+		"\xec\x45\xd8\xa6\x10\xe2\xe9\xeb\x1a\x1c\xe2\xe8\x1b\xa0\x10\xee\x19\xe2\x01\xe6\x2a\x44\xf9\xfb\xb9\xe2\x73\x06" +
+		"\x60\x00\x67\x07\x59\xba\x01\x01\x00\x00")
+
+func bindataCommonDataMigrations9jobsendtimesqlBytes() ([]byte, error) {
+	return bindataRead(
+		_bindataCommonDataMigrations9jobsendtimesql,
+		"common/data/migrations/9_jobs_end_time.sql",
+	)
+}
+
+func bindataCommonDataMigrations9jobsendtimesql() (*asset, error) {
+	bytes, err := bindataCommonDataMigrations9jobsendtimesqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{
+		name:        "common/data/migrations/9_jobs_end_time.sql",
+		size:        0,
+		md5checksum: "",
+		mode:        os.FileMode(0),
+		modTime:     time.Unix(0, 0),
+	}
+
+	a := &asset{bytes: bytes, info: info}
+
+	return a, nil
+}
+
 var _bindataOrchestrateDataTemplatesHometmpl = []byte(
 	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x54\x41\x73\xdb\x36\x13\x3d\x93\xbf\x62\x3f\xe4\xf6\x8d\x68\x4a\x69" +
 		"\xd3\xda\x34\xc9\x43\xec\x66\x92\x43\xed\x4c\x9d\x1c\x7a\x04\xc1\x25\x89\x06\xc4\x72\x80\x95\x2c\xc5\xa3\xff\xde" +
@@ -623,16 +1255,32 @@ func AssetNames() []string {
 // _bindata is a table, holding each asset generator, mapped to its name.
 //
 var _bindata = map[string]func() (*asset, error){
+	"common/data/migrations/10_job_templates.sql":       bindataCommonDataMigrations10jobtemplatessql,
+	"common/data/migrations/11_idempotency_keys.sql":    bindataCommonDataMigrations11idempotencykeyssql,
+	"common/data/migrations/12_jobs_paused.sql":         bindataCommonDataMigrations12jobspausedsql,
+	"common/data/migrations/13_jobs_name.sql":           bindataCommonDataMigrations13jobsnamesql,
+	"common/data/migrations/14_campaigns.sql":           bindataCommonDataMigrations14campaignssql,
+	"common/data/migrations/15_probe_groups.sql":        bindataCommonDataMigrations15probegroupssql,
+	"common/data/migrations/16_account_roles.sql":       bindataCommonDataMigrations16accountrolessql,
+	"common/data/migrations/17_audit_log.sql":           bindataCommonDataMigrations17auditlogsql,
+	"common/data/migrations/18_accounts_disabled.sql":   bindataCommonDataMigrations18accountsdisabledsql,
+	"common/data/migrations/19_token_revocations.sql":   bindataCommonDataMigrations19tokenrevocationssql,
 	"common/data/migrations/1_accounts_create.sql":      bindataCommonDataMigrations1accountscreatesql,
 	"common/data/migrations/1_active_probes_create.sql": bindataCommonDataMigrations1activeprobescreatesql,
 	"common/data/migrations/1_jobs_create.sql":          bindataCommonDataMigrations1jobscreatesql,
 	"common/data/migrations/1_probe_updates_create.sql": bindataCommonDataMigrations1probeupdatescreatesql,
 	"common/data/migrations/1_tasks_create.sql":         bindataCommonDataMigrations1taskscreatesql,
+	"common/data/migrations/20_refresh_tokens.sql":      bindataCommonDataMigrations20refreshtokenssql,
+	"common/data/migrations/21_jobs_version.sql":        bindataCommonDataMigrations21jobsversionsql,
 	"common/data/migrations/2_add_jobs_state.sql":       bindataCommonDataMigrations2addjobsstatesql,
 	"common/data/migrations/2_add_language_column.sql":  bindataCommonDataMigrations2addlanguagecolumnsql,
 	"common/data/migrations/3_add_job_type_tables.sql":  bindataCommonDataMigrations3addjobtypetablessql,
 	"common/data/migrations/4_rendezvous_tables.sql":    bindataCommonDataMigrations4rendezvoustablessql,
 	"common/data/migrations/5_token_expiry.sql":         bindataCommonDataMigrations5tokenexpirysql,
+	"common/data/migrations/6_task_leases.sql":          bindataCommonDataMigrations6taskleasessql,
+	"common/data/migrations/7_task_cancelled.sql":       bindataCommonDataMigrations7taskcancelledsql,
+	"common/data/migrations/8_tasks_job_index.sql":      bindataCommonDataMigrations8tasksjobindexsql,
+	"common/data/migrations/9_jobs_end_time.sql":        bindataCommonDataMigrations9jobsendtimesql,
 	"orchestrate/data/templates/home.tmpl":              bindataOrchestrateDataTemplatesHometmpl,
 }
 
@@ -690,16 +1338,32 @@ var _bintree = &bintree{Func: nil, Children: map[string]*bintree{
 	"common": {Func: nil, Children: map[string]*bintree{
 		"data": {Func: nil, Children: map[string]*bintree{
 			"migrations": {Func: nil, Children: map[string]*bintree{
+				"10_job_templates.sql":       {Func: bindataCommonDataMigrations10jobtemplatessql, Children: map[string]*bintree{}},
+				"11_idempotency_keys.sql":    {Func: bindataCommonDataMigrations11idempotencykeyssql, Children: map[string]*bintree{}},
+				"12_jobs_paused.sql":         {Func: bindataCommonDataMigrations12jobspausedsql, Children: map[string]*bintree{}},
+				"13_jobs_name.sql":           {Func: bindataCommonDataMigrations13jobsnamesql, Children: map[string]*bintree{}},
+				"14_campaigns.sql":           {Func: bindataCommonDataMigrations14campaignssql, Children: map[string]*bintree{}},
+				"15_probe_groups.sql":        {Func: bindataCommonDataMigrations15probegroupssql, Children: map[string]*bintree{}},
+				"16_account_roles.sql":       {Func: bindataCommonDataMigrations16accountrolessql, Children: map[string]*bintree{}},
+				"17_audit_log.sql":           {Func: bindataCommonDataMigrations17auditlogsql, Children: map[string]*bintree{}},
+				"18_accounts_disabled.sql":   {Func: bindataCommonDataMigrations18accountsdisabledsql, Children: map[string]*bintree{}},
+				"19_token_revocations.sql":   {Func: bindataCommonDataMigrations19tokenrevocationssql, Children: map[string]*bintree{}},
 				"1_accounts_create.sql":      {Func: bindataCommonDataMigrations1accountscreatesql, Children: map[string]*bintree{}},
 				"1_active_probes_create.sql": {Func: bindataCommonDataMigrations1activeprobescreatesql, Children: map[string]*bintree{}},
 				"1_jobs_create.sql":          {Func: bindataCommonDataMigrations1jobscreatesql, Children: map[string]*bintree{}},
 				"1_probe_updates_create.sql": {Func: bindataCommonDataMigrations1probeupdatescreatesql, Children: map[string]*bintree{}},
 				"1_tasks_create.sql":         {Func: bindataCommonDataMigrations1taskscreatesql, Children: map[string]*bintree{}},
+				"20_refresh_tokens.sql":      {Func: bindataCommonDataMigrations20refreshtokenssql, Children: map[string]*bintree{}},
+				"21_jobs_version.sql":        {Func: bindataCommonDataMigrations21jobsversionsql, Children: map[string]*bintree{}},
 				"2_add_jobs_state.sql":       {Func: bindataCommonDataMigrations2addjobsstatesql, Children: map[string]*bintree{}},
 				"2_add_language_column.sql":  {Func: bindataCommonDataMigrations2addlanguagecolumnsql, Children: map[string]*bintree{}},
 				"3_add_job_type_tables.sql":  {Func: bindataCommonDataMigrations3addjobtypetablessql, Children: map[string]*bintree{}},
 				"4_rendezvous_tables.sql":    {Func: bindataCommonDataMigrations4rendezvoustablessql, Children: map[string]*bintree{}},
 				"5_token_expiry.sql":         {Func: bindataCommonDataMigrations5tokenexpirysql, Children: map[string]*bintree{}},
+				"6_task_leases.sql":          {Func: bindataCommonDataMigrations6taskleasessql, Children: map[string]*bintree{}},
+				"7_task_cancelled.sql":       {Func: bindataCommonDataMigrations7taskcancelledsql, Children: map[string]*bintree{}},
+				"8_tasks_job_index.sql":      {Func: bindataCommonDataMigrations8tasksjobindexsql, Children: map[string]*bintree{}},
+				"9_jobs_end_time.sql":        {Func: bindataCommonDataMigrations9jobsendtimesql, Children: map[string]*bintree{}},
 			}},
 		}},
 	}},
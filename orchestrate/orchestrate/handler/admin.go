@@ -6,15 +6,19 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/types"
 	"github.com/lib/pq"
 	uuid "github.com/satori/go.uuid"
 
 	common "github.com/ooni/orchestra/common"
+	"github.com/ooni/orchestra/common/middleware"
 	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
 )
 
@@ -22,6 +26,9 @@ import (
 type Target struct {
 	Countries []string `json:"countries"`
 	Platforms []string `json:"platforms"`
+	// Groups are names of probe groups. When set only the probes in at
+	// least one of the groups are targeted.
+	Groups []string `json:"groups,omitempty"`
 }
 
 // URLTestArg are the URL arguments for the test
@@ -33,189 +40,407 @@ type URLTestArg struct {
 
 // JobData struct for containing all Job metadata (both alert and tasks)
 type JobData struct {
-	ID        string           `json:"id"`
-	Schedule  string           `json:"schedule" binding:"required"`
-	Delay     int64            `json:"delay"`
-	Comment   string           `json:"comment" binding:"required"`
-	TaskData  *sched.TaskData  `json:"task"`
-	AlertData *sched.AlertData `json:"alert"`
-	Target    Target           `json:"target"`
-	State     string           `json:"state"`
+	ID         string           `json:"id"`
+	Name       string           `json:"name,omitempty"`
+	CampaignID string           `json:"campaign_id,omitempty"`
+	Owner      string           `json:"owner,omitempty"`
+	Schedule   string           `json:"schedule" binding:"required"`
+	Delay      int64            `json:"delay"`
+	Comment    string           `json:"comment" binding:"required"`
+	TaskData   *sched.TaskData  `json:"task"`
+	AlertData  *sched.AlertData `json:"alert"`
+	Target     Target           `json:"target"`
+	State      string           `json:"state"`
+	// EndTime is when the job stops repeating. It can also be given as part
+	// of the schedule with the R/start/end/P[duration] form.
+	EndTime *time.Time `json:"end_time"`
+	// TaskCounts is the number of tasks of the job in every task state
+	TaskCounts map[string]int64 `json:"task_counts,omitempty"`
 
 	CreationTime time.Time `json:"creation_time"`
 }
 
-// AddJob adds a job to the database and run it
-func AddJob(db *sqlx.DB, jd JobData, s *sched.Scheduler) (string, error) {
-	var (
-		taskNo  sql.NullInt64
-		alertNo sql.NullInt64
-		err     error
-	)
+// jobSchedule validates the schedule and end time of the job and returns
+// its parsed schedule
+func jobSchedule(jd JobData) (sched.Schedule, error) {
 	schedule, err := sched.ParseSchedule(jd.Schedule)
 	if err != nil {
 		ctx.WithError(err).Error("invalid schedule format")
-		return "", err
+		return schedule, err
+	}
+	if jd.EndTime != nil {
+		endTime := jd.EndTime.UTC()
+		if !schedule.EndTime.IsZero() && !schedule.EndTime.Equal(endTime) {
+			return schedule, errors.New("end_time does not match the schedule end time")
+		}
+		if !endTime.After(schedule.StartTime) {
+			return schedule, errors.New("end time must be after start time")
+		}
+		schedule.EndTime = endTime
+	}
+	if schedule.HasEnded(time.Now().UTC()) {
+		return schedule, errors.New("end time is in the past")
+	}
+	if jd.TaskData == nil && jd.AlertData == nil {
+		return schedule, errors.New("task or alert must be defined")
+	}
+	if jd.CampaignID != "" {
+		if _, err = uuid.FromString(jd.CampaignID); err != nil {
+			return schedule, errors.New("invalid campaign_id")
+		}
+	}
+	return schedule, nil
+}
+
+// checkJobCampaign returns ErrCampaignNotFound when the job is part of a
+// campaign that does not exist
+func checkJobCampaign(store sched.Store, jd JobData) error {
+	if jd.CampaignID == "" {
+		return nil
+	}
+	exists, err := store.CampaignExists(jd.CampaignID)
+	if err != nil {
+		return err
 	}
+	if !exists {
+		return ErrCampaignNotFound
+	}
+	return nil
+}
+
+// ValidateJob returns an error if AddJob would refuse the job
+func ValidateJob(jd JobData) error {
+	_, err := jobSchedule(jd)
+	return err
+}
+
+// recordJobAudit writes the audit entry of an action on a job. The action
+// already happened, so failing to write the entry is only logged.
+func recordJobAudit(store sched.Store, audit middleware.AuditEntry, action string, jobID string) {
+	audit.Action = action
+	audit.TargetID = jobID
+	audit.Time = time.Now().UTC()
+	if err := store.RecordAudit(audit); err != nil {
+		ctx.WithError(err).Errorf("failed to record %s of job %s", action, jobID)
+	}
+}
 
-	tx, err := db.Begin()
+// AddJob adds a job to the store and, if a scheduler is given, runs it. The
+// creation is recorded in the audit log with the given entry.
+func AddJob(store sched.Store, jd JobData, s *sched.Scheduler, audit middleware.AuditEntry) (string, error) {
+	schedule, err := jobSchedule(jd)
 	if err != nil {
-		ctx.WithError(err).Error("failed to open transaction")
 		return "", err
 	}
+	if err = checkJobCampaign(store, jd); err != nil {
+		return "", err
+	}
+	if len(jd.Target.Groups) > 0 {
+		_, err = store.ResolveProbeGroups(jd.Target.Groups)
+		if err != nil {
+			return "", err
+		}
+	}
+	var endTime *time.Time
+	if !schedule.EndTime.IsZero() {
+		endTime = &schedule.EndTime
+	}
 
 	jd.ID = uuid.NewV4().String()
-	{
-		if jd.AlertData != nil {
-			query := fmt.Sprintf(`INSERT INTO %s (
-				alert_no,
-				message,
-				extra
-			) VALUES (DEFAULT, $1, $2)
-			RETURNING alert_no;`,
-				pq.QuoteIdentifier(common.JobAlertsTable))
-			stmt, err := tx.Prepare(query)
-			if err != nil {
-				ctx.WithError(err).Error("failed to prepare jobs-alerts query")
-				return "", err
-			}
-			defer stmt.Close()
+	err = store.CreateJob(sched.JobSpec{
+		ID:           jd.ID,
+		Name:         jd.Name,
+		CampaignID:   jd.CampaignID,
+		Owner:        jd.Owner,
+		Comment:      jd.Comment,
+		Schedule:     jd.Schedule,
+		Delay:        jd.Delay,
+		Countries:    jd.Target.Countries,
+		Platforms:    jd.Target.Platforms,
+		Groups:       jd.Target.Groups,
+		TaskData:     jd.TaskData,
+		AlertData:    jd.AlertData,
+		EndTime:      endTime,
+		CreationTime: time.Now().UTC(),
+		NextRunAt:    schedule.StartTime,
+	})
+	if err != nil {
+		return "", err
+	}
+	recordJobAudit(store, audit, "job.create", jd.ID)
 
-			alertExtraStr, err := json.Marshal(jd.AlertData.Extra)
-			if err != nil {
-				tx.Rollback()
-				ctx.WithError(err).Error("failed to serialise alert args")
-			}
-			err = stmt.QueryRow(jd.AlertData.Message, alertExtraStr).Scan(&alertNo)
-			if err != nil {
-				tx.Rollback()
-				ctx.WithError(err).Error("failed to insert into job-alerts table")
-				return "", err
-			}
-		} else if jd.TaskData != nil {
-			query := fmt.Sprintf(`INSERT INTO %s (
-				task_no,
-				test_name,
-				arguments
-			) VALUES (DEFAULT, $1, $2)
-			RETURNING task_no;`,
-				pq.QuoteIdentifier(common.JobTasksTable))
-			stmt, err := tx.Prepare(query)
-			if err != nil {
-				ctx.WithError(err).Error("failed to prepare jobs-tasks query")
-				return "", err
-			}
-			defer stmt.Close()
+	if s != nil {
+		j := sched.NewJob(jd.ID,
+			jd.Comment,
+			schedule,
+			jd.Delay)
+		go s.RunJob(j)
+	}
 
-			taskArgsStr, err := json.Marshal(jd.TaskData.Arguments)
-			if err != nil {
-				tx.Rollback()
-				ctx.WithError(err).Error("failed to serialise task args")
+	return jd.ID, nil
+}
+
+// UpdateJob changes the definition of the job in place. The job keeps its
+// ID and run count, so a job with a fixed number of repetitions does not
+// start over. Its name, owner and state are not changed.
+func UpdateJob(store sched.Store, jobID string, jd JobData, s *sched.Scheduler, audit middleware.AuditEntry) error {
+	schedule, err := jobSchedule(jd)
+	if err != nil {
+		return err
+	}
+	if err = checkJobCampaign(store, jd); err != nil {
+		return err
+	}
+	if len(jd.Target.Groups) > 0 {
+		_, err = store.ResolveProbeGroups(jd.Target.Groups)
+		if err != nil {
+			return err
+		}
+	}
+	var endTime *time.Time
+	if !schedule.EndTime.IsZero() {
+		endTime = &schedule.EndTime
+	}
+
+	err = store.UpdateJob(sched.JobSpec{
+		ID:         jobID,
+		CampaignID: jd.CampaignID,
+		Comment:    jd.Comment,
+		Schedule:   jd.Schedule,
+		Delay:      jd.Delay,
+		Countries:  jd.Target.Countries,
+		Platforms:  jd.Target.Platforms,
+		Groups:     jd.Target.Groups,
+		TaskData:   jd.TaskData,
+		AlertData:  jd.AlertData,
+		EndTime:    endTime,
+		NextRunAt:  schedule.StartTime,
+	})
+	if err != nil {
+		return err
+	}
+	recordJobAudit(store, audit, "job.update", jobID)
+
+	// The running job is reloaded with its new schedule
+	if s != nil {
+		s.RefreshJobs()
+	}
+	return nil
+}
+
+// getTaskCounts returns the number of tasks in every state for each of the
+// given jobs
+func getTaskCounts(db *sqlx.DB, jobIDs []string) (map[string]map[string]int64, error) {
+	taskCounts := make(map[string]map[string]int64)
+	if len(jobIDs) == 0 {
+		return taskCounts, nil
+	}
+
+	query := fmt.Sprintf(`SELECT
+		job_id,
+		COALESCE(state, 'ready') AS task_state,
+		COUNT(*)
+		FROM %s
+		WHERE job_id = ANY($1)
+		GROUP BY job_id, task_state`,
+		pq.QuoteIdentifier(common.TasksTable))
+	rows, err := db.Query(query, pq.StringArray(jobIDs))
+	if err != nil {
+		ctx.WithError(err).Error("failed to count tasks")
+		return taskCounts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			jobID string
+			state string
+			count int64
+		)
+		err = rows.Scan(&jobID, &state, &count)
+		if err != nil {
+			ctx.WithError(err).Error("failed to iterate over task counts")
+			return taskCounts, err
+		}
+		if _, ok := taskCounts[jobID]; !ok {
+			taskCounts[jobID] = make(map[string]int64)
+		}
+		taskCounts[jobID][state] = count
+	}
+	return taskCounts, nil
+}
+
+// JobsQuery is the query for the job listing. State, Type, TestName and
+// CountryCode are comma separated lists of values.
+type JobsQuery struct {
+	Limit int64  `form:"limit" binding:"max=1000"`
+	State string `form:"state"`
+	// Type is either alert or task
+	Type     string `form:"type"`
+	TestName string `form:"test_name"`
+	// CountryCode matches the jobs targeting one of the countries, including
+	// the jobs that target every country
+	CountryCode string `form:"country_code"`
+	// Since and Until are RFC3339 bounds on the creation time
+	Since string `form:"since"`
+	Until string `form:"until"`
+	// Search is a case insensitive substring of the comment
+	Search string `form:"q"`
+	// CampaignID only matches the jobs of the campaign
+	CampaignID string `form:"campaign_id"`
+	// Owner only matches the jobs created by the account. It is always set
+	// to their own username for operators.
+	Owner string `form:"owner"`
+	// Cursor is the next_cursor returned in the metadata of the previous
+	// page
+	Cursor string `form:"cursor"`
+}
+
+// ErrInvalidJobState the state filter contains an unknown job state
+var ErrInvalidJobState = errors.New("invalid job state")
+
+// ErrInvalidJobType the type filter is not alert or task
+var ErrInvalidJobType = errors.New("invalid job type")
+
+// ErrInvalidTimeRange since or until are not valid RFC3339 times
+var ErrInvalidTimeRange = errors.New("invalid time range")
+
+// escapeLike escapes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// filterJobs adds the WHERE conditions of the query, except the cursor, to
+// the jobs query
+func filterJobs(q JobsQuery, query string, args []interface{}) (string, []interface{}, error) {
+	query += " WHERE TRUE"
+	if q.State != "" {
+		states := strings.Split(q.State, ",")
+		for _, s := range states {
+			valid := false
+			for _, js := range sched.JobStates {
+				if s == js {
+					valid = true
+				}
 			}
-			err = stmt.QueryRow(jd.TaskData.TestName, taskArgsStr).Scan(&taskNo)
-			if err != nil {
-				tx.Rollback()
-				ctx.WithError(err).Error("failed to insert into job-tasks table")
-				return "", err
+			if !valid {
+				return query, args, ErrInvalidJobState
 			}
-		} else {
-			return "", errors.New("task or alert must be defined")
 		}
-
-		query := fmt.Sprintf(`INSERT INTO %s (
-			id, comment,
-			schedule, delay,
-			target_countries,
-			target_platforms,
-			creation_time,
-			times_run,
-			next_run_at,
-			is_done,
-			state,
-			task_no,
-			alert_no
-		) VALUES (
-			$1, $2,
-			$3, $4,
-			$5,
-			$6,
-			$7,
-			$8,
-			$9,
-			$10,
-			$11,
-			$12,
-			$13)`,
-			pq.QuoteIdentifier(common.JobsTable))
-
-		stmt, err := tx.Prepare(query)
+		args = append(args, pq.StringArray(states))
+		query += fmt.Sprintf(" AND COALESCE(jobs.state, 'active') = ANY($%d)", len(args))
+	}
+	switch q.Type {
+	case "":
+	case "alert":
+		query += " AND jobs.alert_no IS NOT NULL"
+	case "task":
+		query += " AND jobs.task_no IS NOT NULL"
+	default:
+		return query, args, ErrInvalidJobType
+	}
+	if q.TestName != "" {
+		args = append(args, pq.StringArray(strings.Split(q.TestName, ",")))
+		query += fmt.Sprintf(" AND job_tasks.test_name = ANY($%d)", len(args))
+	}
+	if q.CountryCode != "" {
+		args = append(args, pq.StringArray(
+			common.MapToUppercase(strings.Split(q.CountryCode, ","))))
+		query += fmt.Sprintf(` AND (jobs.target_countries && $%d
+			OR COALESCE(cardinality(jobs.target_countries), 0) = 0)`, len(args))
+	}
+	if q.Since != "" {
+		since, err := time.Parse(time.RFC3339, q.Since)
 		if err != nil {
-			ctx.WithError(err).Error("failed to prepare jobs query")
-			return "", err
+			return query, args, ErrInvalidTimeRange
 		}
-		defer stmt.Close()
-
-		_, err = stmt.Exec(jd.ID, jd.Comment,
-			jd.Schedule, jd.Delay,
-			pq.Array(jd.Target.Countries),
-			pq.Array(jd.Target.Platforms),
-			time.Now().UTC(),
-			0,
-			schedule.StartTime,
-			false,
-			"active",
-			taskNo,
-			alertNo)
+		args = append(args, since.UTC())
+		query += fmt.Sprintf(" AND jobs.creation_time >= $%d", len(args))
+	}
+	if q.Until != "" {
+		until, err := time.Parse(time.RFC3339, q.Until)
 		if err != nil {
-			tx.Rollback()
-			ctx.WithError(err).Error("failed to insert into jobs table")
-			return "", err
+			return query, args, ErrInvalidTimeRange
 		}
+		args = append(args, until.UTC())
+		query += fmt.Sprintf(" AND jobs.creation_time < $%d", len(args))
 	}
-
-	if err = tx.Commit(); err != nil {
-		ctx.WithError(err).Error("failed to commit transaction, rolling back")
-		return "", err
+	if q.Search != "" {
+		args = append(args, "%"+escapeLike(q.Search)+"%")
+		query += fmt.Sprintf(" AND jobs.comment ILIKE $%d", len(args))
 	}
-	j := sched.NewJob(jd.ID,
-		jd.Comment,
-		schedule,
-		jd.Delay)
-	go s.RunJob(j)
-
-	return jd.ID, nil
+	if q.CampaignID != "" {
+		args = append(args, q.CampaignID)
+		query += fmt.Sprintf(" AND jobs.campaign_id::text = $%d", len(args))
+	}
+	if q.Owner != "" {
+		args = append(args, q.Owner)
+		query += fmt.Sprintf(" AND jobs.owner = $%d", len(args))
+	}
+	return query, args, nil
 }
 
-// ListJobs list all the jobs present in the database
-func ListJobs(db *sqlx.DB, showDeleted bool) ([]JobData, error) {
+// jobsFrom is the FROM clause shared by the job listing and its count
+var jobsFrom = fmt.Sprintf(`FROM %s
+		LEFT OUTER JOIN job_alerts ON (job_alerts.alert_no = jobs.alert_no)
+		LEFT OUTER JOIN job_tasks ON (job_tasks.task_no = jobs.task_no)`,
+	pq.QuoteIdentifier(common.JobsTable))
+
+// DefaultJobsLimit is the page size of the job listing when a cursor is
+// given without a limit
+const DefaultJobsLimit = 100
+
+// ListJobs lists the jobs matching the query ordered by creation time. It
+// returns the cursor of the next page, which is empty when there are no
+// more jobs. A zero Limit lists all the jobs.
+func ListJobs(db *sqlx.DB, q JobsQuery) ([]JobData, string, error) {
 	// XXX this can probably be unified with JobDB.GetAll()
 	var (
 		currentJobs []JobData
+		nextCursor  string
 	)
-	query := fmt.Sprintf(`SELECT
-		id, comment,
+
+	query, args, err := filterJobs(q, `SELECT
+		id, COALESCE(name, ''),
+		COALESCE(campaign_id::text, ''),
+		COALESCE(owner, ''),
+		comment,
 		creation_time,
 		schedule, delay,
 		target_countries,
 		target_platforms,
+		target_groups,
 		jobs.alert_no,
 		job_alerts.message,
 		job_alerts.extra,
 		jobs.task_no,
 		job_tasks.test_name,
 		job_tasks.arguments,
-		COALESCE(state, 'active') AS state
-		FROM %s
-		LEFT OUTER JOIN job_alerts ON (job_alerts.alert_no = jobs.alert_no)
-		LEFT OUTER JOIN job_tasks ON (job_tasks.task_no = jobs.task_no)`,
-		pq.QuoteIdentifier(common.JobsTable))
-	if showDeleted == false {
-		query += " WHERE state = 'active'"
+		COALESCE(state, 'active') AS state,
+		end_time
+		`+jobsFrom, []interface{}{})
+	if err != nil {
+		return currentJobs, nextCursor, err
+	}
+	if q.Cursor != "" {
+		creationTime, jobID, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return currentJobs, nextCursor, err
+		}
+		args = append(args, creationTime, jobID)
+		query += fmt.Sprintf(" AND (jobs.creation_time, jobs.id) > ($%d, $%d)",
+			len(args)-1, len(args))
+	}
+	query += " ORDER BY jobs.creation_time ASC, jobs.id ASC"
+	if q.Limit > 0 {
+		// We fetch one more row than requested to know if there is a next page
+		args = append(args, q.Limit+1)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
 	}
-	rows, err := db.Query(query)
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		ctx.WithError(err).Error("failed to list jobs")
-		return currentJobs, err
+		return currentJobs, nextCursor, err
 	}
 	defer rows.Close()
 	for rows.Next() {
@@ -229,21 +454,23 @@ func ListJobs(db *sqlx.DB, showDeleted bool) ([]JobData, error) {
 			taskTestName sql.NullString
 			taskArgs     types.JSONText
 		)
-		err := rows.Scan(&jd.ID, &jd.Comment,
+		err := rows.Scan(&jd.ID, &jd.Name, &jd.CampaignID, &jd.Owner, &jd.Comment,
 			&jd.CreationTime,
 			&jd.Schedule, &jd.Delay,
 			pq.Array(&jd.Target.Countries),
 			pq.Array(&jd.Target.Platforms),
+			pq.Array(&jd.Target.Groups),
 			&alertNo,
 			&alertMessage,
 			&alertExtra,
 			&taskNo,
 			&taskTestName,
 			&taskArgs,
-			&jd.State)
+			&jd.State,
+			&jd.EndTime)
 		if err != nil {
 			ctx.WithError(err).Error("failed to iterate over jobs")
-			return currentJobs, err
+			return currentJobs, nextCursor, err
 		}
 		if taskNo.Valid {
 			td := sched.TaskData{}
@@ -255,7 +482,7 @@ func ListJobs(db *sqlx.DB, showDeleted bool) ([]JobData, error) {
 			err = taskArgs.Unmarshal(&td.Arguments)
 			if err != nil {
 				ctx.WithError(err).Error("failed to unmarshal task args JSON")
-				return currentJobs, err
+				return currentJobs, nextCursor, err
 			}
 			jd.TaskData = &td
 		}
@@ -268,32 +495,99 @@ func ListJobs(db *sqlx.DB, showDeleted bool) ([]JobData, error) {
 			err = alertExtra.Unmarshal(&ad.Extra)
 			if err != nil {
 				ctx.WithError(err).Error("failed to unmarshal alert extra JSON")
-				return currentJobs, err
+				return currentJobs, nextCursor, err
 			}
 			jd.AlertData = &ad
 		}
 		currentJobs = append(currentJobs, jd)
 	}
-	return currentJobs, nil
+	if q.Limit > 0 && int64(len(currentJobs)) > q.Limit {
+		currentJobs = currentJobs[:q.Limit]
+		last := currentJobs[len(currentJobs)-1]
+		nextCursor = encodeCursor(last.CreationTime, last.ID)
+	}
+
+	var jobIDs []string
+	for _, jd := range currentJobs {
+		if jd.TaskData != nil {
+			jobIDs = append(jobIDs, jd.ID)
+		}
+	}
+	taskCounts, err := getTaskCounts(db, jobIDs)
+	if err != nil {
+		return currentJobs, nextCursor, err
+	}
+	for i := range currentJobs {
+		if currentJobs[i].TaskData == nil {
+			continue
+		}
+		currentJobs[i].TaskCounts = make(map[string]int64)
+		for _, state := range sched.TaskStates {
+			currentJobs[i].TaskCounts[state] = taskCounts[currentJobs[i].ID][state]
+		}
+	}
+	return currentJobs, nextCursor, nil
+}
+
+// MakeMetadata generates the metadata for the job listing
+func (q JobsQuery) MakeMetadata(db *sqlx.DB, count int, nextCursor string) (gin.H, error) {
+	metadata := gin.H{
+		"limit":       nil,
+		"count":       count,
+		"next_cursor": nil,
+		"next_url":    nil,
+	}
+	if q.Limit > 0 {
+		metadata["limit"] = q.Limit
+	}
+
+	query, args, err := filterJobs(q, "SELECT COUNT(*) "+jobsFrom, []interface{}{})
+	if err != nil {
+		return metadata, err
+	}
+	var totalCount int64
+	err = db.QueryRow(query, args...).Scan(&totalCount)
+	if err != nil {
+		ctx.WithError(err).Error("failed to count jobs")
+		return metadata, err
+	}
+	metadata["total_count"] = totalCount
+
+	if nextCursor != "" {
+		metadata["next_cursor"] = nextCursor
+		v := url.Values{}
+		v.Set("state", q.State)
+		v.Set("type", q.Type)
+		v.Set("test_name", q.TestName)
+		v.Set("country_code", q.CountryCode)
+		v.Set("since", q.Since)
+		v.Set("until", q.Until)
+		v.Set("q", q.Search)
+		v.Set("campaign_id", q.CampaignID)
+		v.Set("owner", q.Owner)
+		v.Set("limit", fmt.Sprintf("%d", q.Limit))
+		v.Set("cursor", nextCursor)
+		metadata["next_url"] = fmt.Sprintf("/api/v1/admin/jobs?%s", v.Encode())
+	}
+	return metadata, nil
 }
 
 // ErrJobNotFound did not found the job in the DB
-var ErrJobNotFound = errors.New("job not found")
+var ErrJobNotFound = sched.ErrJobNotFound
 
 // DeleteJob mark the job as deleted
-func DeleteJob(jobID string, db *sqlx.DB, s *sched.Scheduler) error {
-	query := fmt.Sprintf(`UPDATE %s SET
-		state = $2
-		WHERE id = $1`,
-		pq.QuoteIdentifier(common.JobsTable))
-	_, err := db.Exec(query, jobID, "deleted")
+func DeleteJob(jobID string, store sched.Store, s *sched.Scheduler, audit middleware.AuditEntry) error {
+	err := store.SetJobState(jobID, "deleted")
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return ErrJobNotFound
+		if err != ErrJobNotFound {
+			ctx.WithError(err).Error("failed delete job")
 		}
-		ctx.WithError(err).Error("failed delete job")
 		return err
 	}
+	recordJobAudit(store, audit, "job.delete", jobID)
+	if s == nil {
+		return nil
+	}
 	err = s.DeleteJob(jobID)
 	if err != nil {
 		ctx.WithError(err).Error("failed to delete job from runningJobs")
@@ -301,53 +595,296 @@ func DeleteJob(jobID string, db *sqlx.DB, s *sched.Scheduler) error {
 	return nil
 }
 
+// ErrInvalidJobTransition the job is not in a state from which it can be
+// paused or resumed
+var ErrInvalidJobTransition = errors.New("invalid job state transition")
+
+// GetJob returns the job with the given ID
+func GetJob(store sched.Store, jobID string) (JobData, error) {
+	spec, err := store.GetJobSpec(jobID)
+	if err != nil {
+		return JobData{}, err
+	}
+	return JobData{
+		ID:         spec.ID,
+		Name:       spec.Name,
+		CampaignID: spec.CampaignID,
+		Owner:      spec.Owner,
+		Schedule:   spec.Schedule,
+		Delay:      spec.Delay,
+		Comment:    spec.Comment,
+		TaskData:   spec.TaskData,
+		AlertData:  spec.AlertData,
+		Target: Target{
+			Countries: spec.Countries,
+			Platforms: spec.Platforms,
+			Groups:    spec.Groups,
+		},
+		State:        spec.State,
+		EndTime:      spec.EndTime,
+		CreationTime: spec.CreationTime,
+	}, nil
+}
+
+// setJobState moves the job from the state from to the state to
+func setJobState(jobID string, from string, to string, store sched.Store) error {
+	spec, err := store.GetJobSpec(jobID)
+	if err != nil {
+		return err
+	}
+	if spec.State != from {
+		return ErrInvalidJobTransition
+	}
+	err = store.SetJobState(jobID, to)
+	if err != nil {
+		ctx.WithError(err).Errorf("failed to set job state to %s", to)
+		return err
+	}
+	return nil
+}
+
+// PauseJob stops running an active job until it is resumed. When s is nil
+// the running schedulers notice the change on their next refresh.
+func PauseJob(jobID string, store sched.Store, s *sched.Scheduler, audit middleware.AuditEntry) error {
+	err := setJobState(jobID, "active", "paused", store)
+	if err != nil {
+		return err
+	}
+	recordJobAudit(store, audit, "job.pause", jobID)
+	if s != nil {
+		s.DeleteJob(jobID)
+	}
+	return nil
+}
+
+// ResumeJob runs a paused job again. If runs were missed while the job was
+// paused it runs once right away and then follows its schedule.
+func ResumeJob(jobID string, store sched.Store, s *sched.Scheduler, audit middleware.AuditEntry) error {
+	err := setJobState(jobID, "paused", "active", store)
+	if err != nil {
+		return err
+	}
+	recordJobAudit(store, audit, "job.resume", jobID)
+	if s != nil {
+		return s.RefreshJobs()
+	}
+	return nil
+}
+
+// requestAccount returns the account that made the request
+func requestAccount(c *gin.Context) middleware.Account {
+	return middleware.Account{
+		Username: c.GetString("userID"),
+		Role:     c.GetString("role"),
+	}
+}
+
+// authorizeJob checks that the account of the request can access the job.
+// Changes require middleware.CanManageJob, while reading is only denied to
+// the operators that do not own the job. When access is denied it writes
+// the error response and returns false.
+func authorizeJob(c *gin.Context, store sched.Store, jobID string, write bool) bool {
+	spec, err := store.GetJobSpec(jobID)
+	if err != nil {
+		if err == ErrJobNotFound {
+			c.JSON(http.StatusNotFound,
+				gin.H{"error": "job not found"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return false
+	}
+	account := requestAccount(c)
+	allowed := middleware.CanManageJob(account, spec.Owner)
+	if !write && account.Role != "operator" {
+		allowed = true
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden,
+			gin.H{"error": "not allowed to access the job"})
+		return false
+	}
+	return true
+}
+
+// jobExists returns ErrJobNotFound if there is no job with the given ID
+func jobExists(jobID string, db *sqlx.DB) error {
+	var found bool
+	query := fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM %s WHERE id = $1)`,
+		pq.QuoteIdentifier(common.JobsTable))
+	err := db.QueryRow(query, jobID).Scan(&found)
+	if err != nil {
+		ctx.WithError(err).Error("failed to lookup job")
+		return err
+	}
+	if !found {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// CancelJobTasks cancels all the outstanding tasks of the job
+func CancelJobTasks(jobID string, notify bool, now time.Time, store sched.Store) (int64, error) {
+	_, err := store.GetJobSpec(jobID)
+	if err != nil {
+		return 0, err
+	}
+	return sched.CancelJobTasks(jobID, notify, now, store)
+}
+
 // ListJobsHandler lists the jobs in the database
 func ListJobsHandler(c *gin.Context) {
+	var (
+		err       error
+		jobsQuery JobsQuery
+	)
 	db := c.MustGet("DB").(*sqlx.DB)
 
-	jobList, err := ListJobs(db, true)
-	if err != nil {
+	if err = c.Bind(&jobsQuery); err != nil {
 		c.JSON(http.StatusBadRequest,
 			gin.H{"error": err.Error()})
 		return
 	}
+	_, hasLimit := c.GetQuery("limit")
+	if !hasLimit && jobsQuery.Cursor != "" {
+		jobsQuery.Limit = DefaultJobsLimit
+	}
+	// Without limit nor cursor all the jobs are listed, as the clients that
+	// predate pagination expect
+	if (hasLimit || jobsQuery.Cursor != "") && jobsQuery.Limit <= 0 {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid limit"})
+		return
+	}
+	// Operators only see their own jobs
+	if account := requestAccount(c); account.Role == "operator" {
+		jobsQuery.Owner = account.Username
+	}
+
+	jobList, nextCursor, err := ListJobs(db, jobsQuery)
+	if err != nil {
+		switch err {
+		case ErrInvalidCursor, ErrInvalidJobState, ErrInvalidJobType,
+			ErrInvalidTimeRange:
+			c.JSON(http.StatusBadRequest,
+				gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError,
+				gin.H{"error": "server side error"})
+		}
+		return
+	}
+	metadata, err := jobsQuery.MakeMetadata(db, len(jobList), nextCursor)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
 	c.JSON(http.StatusOK,
-		gin.H{"jobs": jobList})
+		gin.H{
+			"jobs":     jobList,
+			"metadata": metadata,
+		})
 	return
 }
 
-// AddJobHandler adds a job to the job DB
+// AddJobHandler adds a job to the job DB. When the request carries an
+// Idempotency-Key header, retries of the same request return the job that
+// was created the first time instead of creating a new one.
 func AddJobHandler(c *gin.Context) {
 	db := c.MustGet("DB").(*sqlx.DB)
+	store := c.MustGet("Store").(sched.Store)
 	scheduler := c.MustGet("Scheduler").(*sched.Scheduler)
 
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid request"})
+		return
+	}
+
+	var idemKey *IdempotencyKey
+	if key := c.GetHeader(IdempotencyKeyHeader); key != "" {
+		var stored *StoredResponse
+		// Keys are scoped by account so that they do not reveal the jobs
+		// of the other accounts
+		scope := "add_job:" + requestAccount(c).Username
+		idemKey, stored, err = ReserveIdempotencyKey(db, scope, key, body)
+		switch err {
+		case nil:
+		case ErrInvalidIdempotencyKey:
+			c.JSON(http.StatusBadRequest,
+				gin.H{"error": err.Error()})
+			return
+		case ErrIdempotencyKeyInUse:
+			c.JSON(http.StatusConflict,
+				gin.H{"error": err.Error()})
+			return
+		case ErrIdempotencyKeyMismatch:
+			c.JSON(http.StatusUnprocessableEntity,
+				gin.H{"error": err.Error()})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError,
+				gin.H{"error": "server side error"})
+			return
+		}
+		if stored != nil {
+			c.Header("Idempotent-Replayed", "true")
+			c.JSON(stored.StatusCode, stored.Body)
+			return
+		}
+	}
+	release := func() {
+		if idemKey != nil {
+			idemKey.Release()
+		}
+	}
+
 	var jobData JobData
-	err := c.BindJSON(&jobData)
+	err = json.Unmarshal(body, &jobData)
+	if err == nil {
+		err = binding.Validator.ValidateStruct(&jobData)
+	}
 	if err != nil {
+		release()
 		ctx.WithError(err).Error("invalid request")
 		c.JSON(http.StatusBadRequest,
 			gin.H{"error": "invalid request"})
 		return
 	}
-	jobID, err := AddJob(db, jobData, scheduler)
+	jobData.Owner = requestAccount(c).Username
+	jobID, err := AddJob(store, jobData, scheduler, middleware.RequestAuditEntry(c))
 	if err != nil {
+		release()
 		c.JSON(http.StatusBadRequest,
 			gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK,
-		gin.H{"id": jobID})
+	middleware.MarkAudited(c)
+	response := gin.H{"id": jobID}
+	if idemKey != nil {
+		err = idemKey.Complete(http.StatusOK, response)
+		if err != nil {
+			ctx.WithError(err).Errorf("failed to store the response for job %s", jobID)
+		}
+	}
+	c.JSON(http.StatusOK, response)
 	return
 }
 
 // DeleteJobHandler deletes a job
 func DeleteJobHandler(c *gin.Context) {
-	db := c.MustGet("DB").(*sqlx.DB)
+	store := c.MustGet("Store").(sched.Store)
 	scheduler := c.MustGet("Scheduler").(*sched.Scheduler)
 
 	jobID := c.Param("job_id")
-	err := DeleteJob(jobID, db, scheduler)
+	if !authorizeJob(c, store, jobID, true) {
+		return
+	}
+	err := DeleteJob(jobID, store, scheduler, middleware.RequestAuditEntry(c))
 	if err != nil {
 		if err == ErrJobNotFound {
 			c.JSON(http.StatusNotFound,
@@ -358,6 +895,33 @@ func DeleteJobHandler(c *gin.Context) {
 			gin.H{"error": "server side error"})
 		return
 	}
+	middleware.MarkAudited(c)
 	c.JSON(http.StatusOK,
 		gin.H{"status": "deleted"})
 }
+
+// CancelJobTasksHandler cancels the outstanding tasks of a job. Passing
+// notify=true also tells the probes to drop the task with a silent push.
+func CancelJobTasksHandler(c *gin.Context) {
+	store := c.MustGet("Store").(sched.Store)
+
+	jobID := c.Param("job_id")
+	if !authorizeJob(c, store, jobID, true) {
+		return
+	}
+	notify := c.DefaultQuery("notify", "false") == "true"
+	count, err := CancelJobTasks(jobID, notify, schedulerNow(c), store)
+	if err != nil {
+		if err == ErrJobNotFound {
+			c.JSON(http.StatusNotFound,
+				gin.H{"error": "job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"status": "cancelled",
+			"cancelled_tasks": count})
+}
@@ -0,0 +1,247 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/ooni/orchestra/common/middleware"
+	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestListJobs(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := sqlx.NewDb(mockDB, "sqlmock")
+
+	now := time.Now().UTC()
+	columns := []string{"id", "name", "campaign_id", "owner", "comment", "creation_time", "schedule", "delay",
+		"target_countries", "target_platforms", "target_groups",
+		"alert_no", "message", "extra",
+		"task_no", "test_name", "arguments",
+		"state", "end_time"}
+	rows := sqlmock.NewRows(columns).
+		AddRow("job-1", "", "", "alice", "weekly IT run", now, "R/2017-01-01T00:00:00Z/P7D", 0,
+			[]byte("{IT}"), []byte("{}"), nil, nil, nil, nil,
+			1, "web_connectivity", []byte(`{}`), "active", nil).
+		AddRow("job-2", "", "", "alice", "daily IT run", now, "R/2017-01-01T00:00:00Z/P1D", 0,
+			[]byte("{IT}"), []byte("{}"), nil, nil, nil, nil,
+			2, "web_connectivity", []byte(`{}`), "active", nil)
+	mock.ExpectQuery("^SELECT (.+) FROM").
+		WithArgs(pq.StringArray([]string{"active"}),
+			pq.StringArray([]string{"IT"}), "%IT\\_run%", int64(2)).
+		WillReturnRows(rows)
+	mock.ExpectQuery("^SELECT (.+) FROM").
+		WithArgs(pq.StringArray([]string{"job-1"})).
+		WillReturnRows(sqlmock.NewRows([]string{"job_id", "task_state", "count"}).
+			AddRow("job-1", "done", 3))
+
+	jobs, nextCursor, err := ListJobs(db, JobsQuery{
+		Limit:       1,
+		State:       "active",
+		Type:        "task",
+		CountryCode: "it",
+		Search:      "IT_run",
+	})
+	if err != nil {
+		t.Fatalf("error in calling ListJobs: %s", err)
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("inconsistent count: %d", len(jobs))
+	}
+	if jobs[0].TaskCounts["done"] != 3 {
+		t.Errorf("wrong task counts: %v", jobs[0].TaskCounts)
+	}
+	creationTime, jobID, err := decodeCursor(nextCursor)
+	if err != nil {
+		t.Fatalf("invalid next cursor: %s", err)
+	}
+	if jobID != "job-1" || !creationTime.Equal(now) {
+		t.Errorf("cursor does not point to the last job: %s %s",
+			jobID, creationTime)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	_, _, err = ListJobs(db, JobsQuery{Limit: 1, State: "bogus"})
+	if err != ErrInvalidJobState {
+		t.Errorf("expected an invalid state error, got %v", err)
+	}
+}
+
+func TestListAllJobs(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := sqlx.NewDb(mockDB, "sqlmock")
+
+	now := time.Now().UTC()
+	columns := []string{"id", "name", "campaign_id", "owner", "comment", "creation_time", "schedule", "delay",
+		"target_countries", "target_platforms", "target_groups",
+		"alert_no", "message", "extra",
+		"task_no", "test_name", "arguments",
+		"state", "end_time"}
+	rows := sqlmock.NewRows(columns)
+	for i := 0; i < 3; i++ {
+		rows.AddRow(fmt.Sprintf("job-%d", i), "", "", "alice", "", now, "R/2017-01-01T00:00:00Z/P1D", 0,
+			[]byte("{}"), []byte("{}"), nil, nil, nil, nil,
+			i+1, "web_connectivity", []byte(`{}`), "active", nil)
+	}
+	// Without a limit the query is not paginated
+	mock.ExpectQuery("ORDER BY jobs.creation_time ASC, jobs.id ASC$").
+		WithArgs().
+		WillReturnRows(rows)
+	mock.ExpectQuery("^SELECT (.+) FROM").
+		WillReturnRows(sqlmock.NewRows([]string{"job_id", "task_state", "count"}))
+
+	jobs, nextCursor, err := ListJobs(db, JobsQuery{})
+	if err != nil {
+		t.Fatalf("error in calling ListJobs: %s", err)
+	}
+	if len(jobs) != 3 || nextCursor != "" {
+		t.Errorf("expected all the jobs in one page, got %d jobs and cursor %q",
+			len(jobs), nextCursor)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDeleteJobOwnership(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := sched.NewMemoryStore()
+	jobID, err := AddJob(store, JobData{
+		Owner:    "alice",
+		Schedule: "R/2030-01-01T00:00:00Z/P1D",
+		Comment:  "daily run",
+		TaskData: &sched.TaskData{TestName: "web_connectivity"},
+	}, nil, middleware.AuditEntry{})
+	if err != nil {
+		t.Fatalf("failed to add job: %s", err)
+	}
+
+	deleteJob := func(userID string, role string) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set("Store", sched.Store(store))
+		c.Set("Scheduler", (*sched.Scheduler)(nil))
+		c.Set("userID", userID)
+		c.Set("role", role)
+		c.Request = httptest.NewRequest("DELETE", "/api/v1/admin/job/"+jobID, nil)
+		c.Params = gin.Params{{Key: "job_id", Value: jobID}}
+		DeleteJobHandler(c)
+		return w.Code
+	}
+
+	if code := deleteJob("bob", "operator"); code != http.StatusForbidden {
+		t.Errorf("expected 403 for another operator (got: %d)", code)
+	}
+	if code := deleteJob("carol", "viewer"); code != http.StatusForbidden {
+		t.Errorf("expected 403 for a viewer (got: %d)", code)
+	}
+	if code := deleteJob("alice", "operator"); code != http.StatusOK {
+		t.Errorf("expected the owner to delete the job (got: %d)", code)
+	}
+
+	entries := store.AuditEntries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 audit entries (got: %v)", entries)
+	}
+	if entries[1].Action != "job.delete" || entries[1].Actor != "alice" ||
+		entries[1].TargetID != jobID {
+		t.Errorf("unexpected audit entry: %v", entries[1])
+	}
+}
+
+func TestCloneJobOwnership(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := sched.NewMemoryStore()
+	jobID, err := AddJob(store, JobData{
+		Owner:    "alice",
+		Schedule: "R/2030-01-01T00:00:00Z/P1D",
+		Comment:  "daily run",
+		TaskData: &sched.TaskData{TestName: "web_connectivity"},
+	}, nil, middleware.AuditEntry{})
+	if err != nil {
+		t.Fatalf("failed to add job: %s", err)
+	}
+
+	cloneJob := func(userID string, role string) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set("Store", sched.Store(store))
+		c.Set("Scheduler", (*sched.Scheduler)(nil))
+		c.Set("userID", userID)
+		c.Set("role", role)
+		c.Request = httptest.NewRequest("POST", "/api/v1/admin/job/"+jobID+"/clone", nil)
+		c.Params = gin.Params{{Key: "job_id", Value: jobID}}
+		CloneJobHandler(c)
+		return w.Code
+	}
+
+	if code := cloneJob("bob", "operator"); code != http.StatusForbidden {
+		t.Errorf("expected 403 for another operator (got: %d)", code)
+	}
+	if code := cloneJob("alice", "operator"); code != http.StatusOK {
+		t.Errorf("expected the owner to clone the job (got: %d)", code)
+	}
+	if code := cloneJob("root", "admin"); code != http.StatusOK {
+		t.Errorf("expected an admin to clone the job (got: %d)", code)
+	}
+}
+
+func TestAddJobHandlerCampaignNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := sched.NewMemoryStore()
+	store.AddCampaign(dummyCampaignID)
+
+	addJob := func(campaignID string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{
+			"schedule": "R/2030-01-01T00:00:00Z/P1D",
+			"delay": 0,
+			"comment": "daily run",
+			"campaign_id": "%s",
+			"target": {},
+			"task": {"test_name": "web_connectivity", "arguments": {}}
+		}`, campaignID)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set("DB", (*sqlx.DB)(nil))
+		c.Set("Store", sched.Store(store))
+		c.Set("Scheduler", (*sched.Scheduler)(nil))
+		c.Set("userID", "alice")
+		c.Set("role", "operator")
+		c.Request = httptest.NewRequest("POST", "/api/v1/admin/job",
+			strings.NewReader(body))
+		AddJobHandler(c)
+		return w
+	}
+
+	w := addJob("1b4e28ba-2fa1-11d2-883f-0016d3cca427")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a missing campaign (got: %d)", w.Code)
+	}
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["error"] != "campaign not found" {
+		t.Errorf("unexpected response: %s", w.Body.String())
+	}
+	if w := addJob(dummyCampaignID); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for an existing campaign (got: %d %s)",
+			w.Code, w.Body.String())
+	}
+}
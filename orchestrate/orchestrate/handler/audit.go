@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/ooni/orchestra/common/middleware"
+)
+
+// parseTimeParam parses the optional RFC3339 time in the query parameter
+func parseTimeParam(c *gin.Context, name string) (time.Time, error) {
+	v := c.Query(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return t, ErrInvalidTimeRange
+	}
+	return t, nil
+}
+
+// ListAuditHandler lists the audit log, the most recent entry first
+func ListAuditHandler(c *gin.Context) {
+	var (
+		err   error
+		query middleware.AuditQuery
+	)
+	// This is equivalent to setting the default value
+	query.Limit = 100
+
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	if err = c.Bind(&query); err != nil {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if query.Limit <= 0 {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid limit"})
+		return
+	}
+	query.Since, err = parseTimeParam(c, "since")
+	if err == nil {
+		query.Until, err = parseTimeParam(c, "until")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+
+	entries, err := middleware.ListAuditEntries(db, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	metadata := gin.H{
+		"limit":    query.Limit,
+		"count":    len(entries),
+		"next_url": nil,
+	}
+	if int64(len(entries)) == query.Limit {
+		v := url.Values{}
+		v.Set("actor", query.Actor)
+		v.Set("action", query.Action)
+		v.Set("target_id", query.TargetID)
+		v.Set("since", c.Query("since"))
+		v.Set("until", c.Query("until"))
+		v.Set("limit", fmt.Sprintf("%d", query.Limit))
+		v.Set("before", fmt.Sprintf("%d", entries[len(entries)-1].ID))
+		metadata["next_url"] = fmt.Sprintf("/api/v1/admin/audit?%s", v.Encode())
+	}
+	c.JSON(http.StatusOK,
+		gin.H{
+			"entries":  entries,
+			"metadata": metadata,
+		})
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestListAuditHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := sqlx.NewDb(mockDB, "sqlmock")
+
+	since := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"id", "time", "actor", "role", "action",
+		"target_id", "body_digest", "source_ip"}).
+		AddRow(7, since, "alice", "operator", "job.delete", "job-1", "", "10.0.0.1")
+	mock.ExpectQuery("^SELECT (.+) FROM \"audit_log\" WHERE actor = \\$1 AND time >= \\$2").
+		WithArgs("alice", since, int64(1)).
+		WillReturnRows(rows)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("DB", db)
+	c.Request = httptest.NewRequest("GET",
+		"/api/v1/admin/audit?actor=alice&since=2018-01-01T00:00:00Z&limit=1", nil)
+	ListAuditHandler(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 (got: %d %s)", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Metadata struct {
+			NextURL string `json:"next_url"`
+		} `json:"metadata"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Metadata.NextURL == "" {
+		t.Error("expected a next_url for a full page")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Set("DB", db)
+	c.Request = httptest.NewRequest("GET", "/api/v1/admin/audit?since=yesterday", nil)
+	ListAuditHandler(c)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid time (got: %d)", w.Code)
+	}
+}
@@ -0,0 +1,363 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	common "github.com/ooni/orchestra/common"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Campaign groups related jobs, for example the jobs run across several
+// countries in response to an incident
+type Campaign struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name" binding:"required"`
+	Description string     `json:"description"`
+	StartTime   time.Time  `json:"start_time" binding:"required"`
+	EndTime     *time.Time `json:"end_time"`
+
+	CreationTime time.Time `json:"creation_time"`
+	LastUpdated  time.Time `json:"last_updated"`
+}
+
+// CampaignProgress is the aggregate progress of the jobs of a campaign
+type CampaignProgress struct {
+	Jobs int64 `json:"jobs"`
+	// ProbesReached is the number of probes that got at least one task out
+	// of the ready state
+	ProbesReached int64 `json:"probes_reached"`
+	TasksDone     int64 `json:"tasks_done"`
+	// TaskCounts is the number of tasks in every task state
+	TaskCounts map[string]int64 `json:"task_counts"`
+	// CountriesTargeted lists the target countries of the jobs. It is empty
+	// when every job targets all the countries.
+	CountriesTargeted []string `json:"countries_targeted"`
+	// CountriesCovered lists the countries with at least one task done
+	CountriesCovered []string `json:"countries_covered"`
+}
+
+// ErrCampaignNotFound did not find the campaign in the DB
+var ErrCampaignNotFound = errors.New("campaign not found")
+
+// ErrCampaignExists a campaign with the same name already exists
+var ErrCampaignExists = errors.New("campaign already exists")
+
+// ErrInvalidCampaignTime the end time of the campaign is not after its
+// start time
+var ErrInvalidCampaignTime = errors.New("end time must be after start time")
+
+// reachedTaskStates are the states of the tasks that reached their probe
+var reachedTaskStates = []string{"notified", "accepted", "rejected", "done"}
+
+// CreateCampaign stores a new campaign and returns its ID
+func CreateCampaign(db *sqlx.DB, c Campaign) (string, error) {
+	if c.EndTime != nil && !c.EndTime.After(c.StartTime) {
+		return "", ErrInvalidCampaignTime
+	}
+	c.ID = uuid.NewV4().String()
+	now := time.Now().UTC()
+	query := fmt.Sprintf(`INSERT INTO %s (
+		id, name, description,
+		start_time, end_time,
+		creation_time,
+		last_updated
+	) VALUES ($1, $2, $3, $4, $5, $6, $6)
+	ON CONFLICT DO NOTHING`,
+		pq.QuoteIdentifier(common.CampaignsTable))
+	res, err := db.Exec(query, c.ID, c.Name, c.Description,
+		c.StartTime.UTC(), c.EndTime, now)
+	if err != nil {
+		ctx.WithError(err).Error("failed to insert campaign")
+		return "", err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return "", err
+	}
+	if n == 0 {
+		return "", ErrCampaignExists
+	}
+	return c.ID, nil
+}
+
+func scanCampaign(row interface {
+	Scan(dest ...interface{}) error
+}) (Campaign, error) {
+	var (
+		c           Campaign
+		description sql.NullString
+		endTime     pq.NullTime
+	)
+	err := row.Scan(&c.ID, &c.Name, &description,
+		&c.StartTime, &endTime,
+		&c.CreationTime, &c.LastUpdated)
+	if err != nil {
+		return c, err
+	}
+	c.Description = description.String
+	if endTime.Valid {
+		c.EndTime = &endTime.Time
+	}
+	return c, nil
+}
+
+const campaignColumns = `id, name, description,
+		start_time, end_time,
+		creation_time,
+		last_updated`
+
+// GetCampaign returns the campaign with the given ID
+func GetCampaign(db *sqlx.DB, campaignID string) (Campaign, error) {
+	if _, err := uuid.FromString(campaignID); err != nil {
+		return Campaign{}, ErrCampaignNotFound
+	}
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE id = $1`,
+		campaignColumns,
+		pq.QuoteIdentifier(common.CampaignsTable))
+	c, err := scanCampaign(db.QueryRow(query, campaignID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c, ErrCampaignNotFound
+		}
+		ctx.WithError(err).Error("failed to get campaign")
+		return c, err
+	}
+	return c, nil
+}
+
+// ListCampaigns lists all the campaigns, the most recent first
+func ListCampaigns(db *sqlx.DB) ([]Campaign, error) {
+	campaigns := []Campaign{}
+	query := fmt.Sprintf(`SELECT %s FROM %s ORDER BY start_time DESC`,
+		campaignColumns,
+		pq.QuoteIdentifier(common.CampaignsTable))
+	rows, err := db.Query(query)
+	if err != nil {
+		ctx.WithError(err).Error("failed to list campaigns")
+		return campaigns, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			ctx.WithError(err).Error("failed to iterate over campaigns")
+			return campaigns, err
+		}
+		campaigns = append(campaigns, c)
+	}
+	return campaigns, nil
+}
+
+// DeleteCampaign deletes the campaign. Its jobs are kept and no longer
+// belong to a campaign.
+func DeleteCampaign(db *sqlx.DB, campaignID string) error {
+	if _, err := uuid.FromString(campaignID); err != nil {
+		return ErrCampaignNotFound
+	}
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id = $1`,
+		pq.QuoteIdentifier(common.CampaignsTable))
+	res, err := db.Exec(query, campaignID)
+	if err != nil {
+		ctx.WithError(err).Error("failed to delete campaign")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return err
+	}
+	if n == 0 {
+		return ErrCampaignNotFound
+	}
+	return nil
+}
+
+// GetCampaignProgress aggregates the tasks of all the jobs of the campaign
+// that are not deleted
+func GetCampaignProgress(db *sqlx.DB, campaignID string) (CampaignProgress, error) {
+	progress := CampaignProgress{
+		TaskCounts:        make(map[string]int64),
+		CountriesTargeted: []string{},
+		CountriesCovered:  []string{},
+	}
+	_, err := GetCampaign(db, campaignID)
+	if err != nil {
+		return progress, err
+	}
+
+	campaignJobs := fmt.Sprintf(`SELECT id FROM %s
+		WHERE campaign_id = $1 AND state IS DISTINCT FROM 'deleted'`,
+		pq.QuoteIdentifier(common.JobsTable))
+
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM (%s) AS campaign_jobs`,
+		campaignJobs)
+	err = db.QueryRow(query, campaignID).Scan(&progress.Jobs)
+	if err != nil {
+		ctx.WithError(err).Error("failed to count campaign jobs")
+		return progress, err
+	}
+
+	query = fmt.Sprintf(`SELECT
+		COALESCE(state, 'ready') AS task_state,
+		COUNT(*)
+		FROM %s
+		WHERE job_id IN (%s)
+		GROUP BY task_state`,
+		pq.QuoteIdentifier(common.TasksTable), campaignJobs)
+	rows, err := db.Query(query, campaignID)
+	if err != nil {
+		ctx.WithError(err).Error("failed to count campaign tasks")
+		return progress, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			state string
+			count int64
+		)
+		err = rows.Scan(&state, &count)
+		if err != nil {
+			ctx.WithError(err).Error("failed to iterate over task counts")
+			return progress, err
+		}
+		progress.TaskCounts[state] = count
+	}
+	progress.TasksDone = progress.TaskCounts["done"]
+
+	query = fmt.Sprintf(`SELECT COUNT(DISTINCT probe_id)
+		FROM %s
+		WHERE job_id IN (%s) AND state = ANY($2)`,
+		pq.QuoteIdentifier(common.TasksTable), campaignJobs)
+	err = db.QueryRow(query, campaignID,
+		pq.StringArray(reachedTaskStates)).Scan(&progress.ProbesReached)
+	if err != nil {
+		ctx.WithError(err).Error("failed to count reached probes")
+		return progress, err
+	}
+
+	query = fmt.Sprintf(`SELECT DISTINCT unnest(target_countries) AS cc
+		FROM %s
+		WHERE campaign_id = $1 AND state IS DISTINCT FROM 'deleted'
+		ORDER BY cc`,
+		pq.QuoteIdentifier(common.JobsTable))
+	err = db.Select(&progress.CountriesTargeted, query, campaignID)
+	if err != nil {
+		ctx.WithError(err).Error("failed to list targeted countries")
+		return progress, err
+	}
+
+	query = fmt.Sprintf(`SELECT DISTINCT active_probes.probe_cc
+		FROM %s
+		JOIN %s ON (active_probes.id = tasks.probe_id)
+		WHERE tasks.job_id IN (%s) AND tasks.state = 'done'
+		AND active_probes.probe_cc IS NOT NULL
+		ORDER BY active_probes.probe_cc`,
+		pq.QuoteIdentifier(common.TasksTable),
+		pq.QuoteIdentifier(common.ActiveProbesTable), campaignJobs)
+	err = db.Select(&progress.CountriesCovered, query, campaignID)
+	if err != nil {
+		ctx.WithError(err).Error("failed to list covered countries")
+		return progress, err
+	}
+	return progress, nil
+}
+
+// ListCampaignsHandler lists the campaigns
+func ListCampaignsHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	campaigns, err := ListCampaigns(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"campaigns": campaigns})
+}
+
+// AddCampaignHandler creates a new campaign
+func AddCampaignHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	var campaign Campaign
+	err := c.BindJSON(&campaign)
+	if err != nil {
+		ctx.WithError(err).Error("invalid request")
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid request"})
+		return
+	}
+	campaignID, err := CreateCampaign(db, campaign)
+	if err != nil {
+		switch err {
+		case ErrCampaignExists:
+			c.JSON(http.StatusConflict,
+				gin.H{"error": err.Error()})
+		case ErrInvalidCampaignTime:
+			c.JSON(http.StatusBadRequest,
+				gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError,
+				gin.H{"error": "server side error"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"id": campaignID})
+}
+
+// GetCampaignHandler returns a campaign along with its progress
+func GetCampaignHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	campaign, err := GetCampaign(db, c.Param("campaign_id"))
+	if err != nil {
+		if err == ErrCampaignNotFound {
+			c.JSON(http.StatusNotFound,
+				gin.H{"error": "campaign not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	progress, err := GetCampaignProgress(db, campaign.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{
+			"campaign": campaign,
+			"progress": progress,
+		})
+}
+
+// DeleteCampaignHandler deletes a campaign
+func DeleteCampaignHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	err := DeleteCampaign(db, c.Param("campaign_id"))
+	if err != nil {
+		if err == ErrCampaignNotFound {
+			c.JSON(http.StatusNotFound,
+				gin.H{"error": "campaign not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"status": "deleted"})
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var dummyCampaignID = "6f1a7c9e-3b8d-4c2a-9e5f-0d1b2c3a4f5e"
+
+func TestGetCampaignProgress(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := sqlx.NewDb(mockDB, "sqlmock")
+
+	now := time.Now().UTC()
+	mock.ExpectQuery("^SELECT (.+) FROM \"campaigns\"").
+		WithArgs(dummyCampaignID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description",
+			"start_time", "end_time", "creation_time", "last_updated"}).
+			AddRow(dummyCampaignID, "elections", nil, now, nil, now, now))
+	mock.ExpectQuery("^SELECT COUNT\\(\\*\\)").
+		WithArgs(dummyCampaignID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery("^SELECT (.+) GROUP BY task_state").
+		WithArgs(dummyCampaignID).
+		WillReturnRows(sqlmock.NewRows([]string{"task_state", "count"}).
+			AddRow("done", 12).
+			AddRow("ready", 4))
+	mock.ExpectQuery("^SELECT COUNT\\(DISTINCT probe_id\\)").
+		WithArgs(dummyCampaignID, pq.StringArray(reachedTaskStates)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+	mock.ExpectQuery("^SELECT DISTINCT unnest").
+		WithArgs(dummyCampaignID).
+		WillReturnRows(sqlmock.NewRows([]string{"cc"}).
+			AddRow("BY").AddRow("RU"))
+	mock.ExpectQuery("^SELECT DISTINCT active_probes.probe_cc").
+		WithArgs(dummyCampaignID).
+		WillReturnRows(sqlmock.NewRows([]string{"probe_cc"}).AddRow("RU"))
+
+	progress, err := GetCampaignProgress(db, dummyCampaignID)
+	if err != nil {
+		t.Fatalf("error in calling GetCampaignProgress: %s", err)
+	}
+	if progress.Jobs != 3 || progress.TasksDone != 12 || progress.ProbesReached != 10 {
+		t.Errorf("unexpected progress: %+v", progress)
+	}
+	if len(progress.CountriesTargeted) != 2 || len(progress.CountriesCovered) != 1 {
+		t.Errorf("unexpected countries: %+v", progress)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	_, err = GetCampaignProgress(db, "not-a-uuid")
+	if err != ErrCampaignNotFound {
+		t.Errorf("expected campaign not found (got: %v)", err)
+	}
+}
+
+func TestAddCampaignHandlerErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := sqlx.NewDb(mockDB, "sqlmock")
+
+	addCampaign := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set("DB", db)
+		c.Request = httptest.NewRequest("POST", "/api/v1/admin/campaigns",
+			strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		AddCampaignHandler(c)
+		return w
+	}
+
+	w := addCampaign(`{"name": "elections",
+		"start_time": "2018-02-01T00:00:00Z",
+		"end_time": "2018-01-01T00:00:00Z"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an end time before the start time (got: %d)", w.Code)
+	}
+
+	mock.ExpectExec("^INSERT INTO \"campaigns\"").
+		WillReturnError(errors.New("pq: relation \"campaigns\" does not exist"))
+	w = addCampaign(`{"name": "elections", "start_time": "2018-01-01T00:00:00Z"}`)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 for a database error (got: %d)", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "relation") {
+		t.Errorf("the database error leaked to the client: %s", w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
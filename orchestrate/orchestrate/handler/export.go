@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
+)
+
+// exportFlushEvery is after how many rows the export is flushed to the client
+const exportFlushEvery = 500
+
+// exportCSVHeader are the columns of the CSV export
+var exportCSVHeader = []string{
+	"task_id", "probe_id", "probe_cc", "probe_asn", "platform",
+	"test_name", "arguments", "state",
+	"creation_time", "notification_time", "accept_time", "done_time",
+}
+
+// ExportJobTasks calls fn for every task of the job. The tasks are read
+// from the database one at a time, so that exports of large jobs do not
+// need to be held in memory. An unknown job has no tasks.
+func ExportJobTasks(db *sqlx.DB, jobID string, fn func(JobTask) error) error {
+	query := jobTasksQuery()
+	query += " ORDER BY tasks.creation_time ASC, tasks.id ASC"
+	rows, err := db.Query(query, jobID)
+	if err != nil {
+		ctx.WithError(err).Error("failed to export job tasks")
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t, err := scanJobTask(rows)
+		if err != nil {
+			return err
+		}
+		if err = fn(t); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func formatExportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvExporter writes every task as a CSV record
+func csvExporter(w io.Writer) (func(JobTask) error, func() error) {
+	cw := csv.NewWriter(w)
+	header := false
+	write := func(t JobTask) error {
+		if !header {
+			if err := cw.Write(exportCSVHeader); err != nil {
+				return err
+			}
+			header = true
+		}
+		args, err := json.Marshal(t.Arguments)
+		if err != nil {
+			return err
+		}
+		return cw.Write([]string{
+			t.ID, t.ProbeID, t.ProbeCC, t.ProbeASN, t.Platform,
+			t.TestName, string(args), t.State,
+			formatExportTime(&t.CreationTime),
+			formatExportTime(t.NotificationTime),
+			formatExportTime(t.AcceptTime),
+			formatExportTime(t.DoneTime),
+		})
+	}
+	flush := func() error {
+		if !header {
+			// Jobs without tasks still get the header
+			if err := cw.Write(exportCSVHeader); err != nil {
+				return err
+			}
+			header = true
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return write, flush
+}
+
+// ndjsonExporter writes every task as a JSON object on its own line
+func ndjsonExporter(w io.Writer) (func(JobTask) error, func() error) {
+	enc := json.NewEncoder(w)
+	write := func(t JobTask) error {
+		return enc.Encode(t)
+	}
+	flush := func() error {
+		return nil
+	}
+	return write, flush
+}
+
+// ExportJobTasksHandler streams every task of a job as CSV or as
+// newline-delimited JSON, depending on the format query parameter
+func ExportJobTasksHandler(c *gin.Context) {
+	var (
+		contentType string
+		exporter    func(io.Writer) (func(JobTask) error, func() error)
+	)
+	db := c.MustGet("DB").(*sqlx.DB)
+	store := c.MustGet("Store").(sched.Store)
+
+	jobID := c.Param("job_id")
+	if !authorizeJob(c, store, jobID, false) {
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	switch format {
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+		exporter = csvExporter
+	case "ndjson":
+		contentType = "application/x-ndjson"
+		exporter = ndjsonExporter
+	default:
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid format"})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"job-%s.%s\"", jobID, format))
+	c.Status(http.StatusOK)
+
+	write, flush := exporter(c.Writer)
+	count := 0
+	err := ExportJobTasks(db, jobID, func(t JobTask) error {
+		if err := write(t); err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushEvery == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// The status line has already been sent, so all we can do is to
+		// truncate the export
+		ctx.WithError(err).Errorf("failed to export job %s", jobID)
+		return
+	}
+	if err = flush(); err != nil {
+		ctx.WithError(err).Errorf("failed to export job %s", jobID)
+	}
+}
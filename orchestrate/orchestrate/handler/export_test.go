@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestExportJobTasksCSV(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := sqlx.NewDb(mockDB, "sqlmock")
+
+	creationTime := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	doneTime := creationTime.Add(time.Hour)
+	rows := sqlmock.NewRows([]string{"id", "probe_id", "test_name",
+		"arguments", "state", "probe_cc", "probe_asn", "platform",
+		"creation_time", "notification_time", "accept_time", "done_time",
+		"lease_expiry"}).
+		AddRow("task-1", "probe-1", "web_connectivity",
+			[]byte(`{"urls":["http://example.com"]}`), "done",
+			"IT", "AS1234", "android",
+			creationTime, nil, nil, doneTime, nil)
+	mock.ExpectQuery("^SELECT (.+) FROM").
+		WithArgs("job-1").
+		WillReturnRows(rows)
+
+	var buf bytes.Buffer
+	write, flush := csvExporter(&buf)
+	err = ExportJobTasks(db, "job-1", write)
+	if err != nil {
+		t.Fatalf("error in calling ExportJobTasks: %s", err)
+	}
+	if err = flush(); err != nil {
+		t.Fatalf("failed to flush: %s", err)
+	}
+	expected := "task_id,probe_id,probe_cc,probe_asn,platform,test_name,arguments,state,creation_time,notification_time,accept_time,done_time\n" +
+		`task-1,probe-1,IT,AS1234,android,web_connectivity,"{""urls"":[""http://example.com""]}",done,2018-05-01T12:00:00Z,,,2018-05-01T13:00:00Z` + "\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected export:\n%s", buf.String())
+	}
+}
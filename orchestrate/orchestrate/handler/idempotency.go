@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+	common "github.com/ooni/orchestra/common"
+	"github.com/spf13/viper"
+)
+
+// IdempotencyKeyHeader is the header clients use to make a request safe to
+// retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyRetention is for how long the response to a request
+// with an idempotency key is kept
+const DefaultIdempotencyRetention = 24 * time.Hour
+
+// DefaultIdempotencyTimeout is after how long a request that neither
+// completed nor released its key is considered abandoned, for example
+// because the process crashed, and the key can be taken over by a retry
+const DefaultIdempotencyTimeout = time.Minute
+
+// idempotencyRenewals is how many times a reservation is renewed within
+// IdempotencyTimeout while its request runs
+const idempotencyRenewals = 3
+
+// maxIdempotencyKeyLength is the longest idempotency key we accept
+const maxIdempotencyKeyLength = 255
+
+// ErrIdempotencyKeyInUse another request with the same key is in progress
+var ErrIdempotencyKeyInUse = errors.New("a request with this idempotency key is in progress")
+
+// ErrIdempotencyKeyMismatch the key was used for a different request
+var ErrIdempotencyKeyMismatch = errors.New("idempotency key was used for a different request")
+
+// ErrInvalidIdempotencyKey the key is empty or too long
+var ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+
+// IdempotencyRetention returns the configured retention of idempotency keys
+func IdempotencyRetention() time.Duration {
+	if viper.IsSet("core.idempotency-retention") {
+		return viper.GetDuration("core.idempotency-retention")
+	}
+	return DefaultIdempotencyRetention
+}
+
+// IdempotencyTimeout returns the configured timeout of idempotency keys
+func IdempotencyTimeout() time.Duration {
+	if viper.IsSet("core.idempotency-timeout") {
+		return viper.GetDuration("core.idempotency-timeout")
+	}
+	return DefaultIdempotencyTimeout
+}
+
+// StoredResponse is the response recorded for an idempotency key
+type StoredResponse struct {
+	StatusCode int
+	Body       map[string]interface{}
+}
+
+// IdempotencyKey is a reserved idempotency key. The request it guards must
+// end with either Complete or Release.
+type IdempotencyKey struct {
+	db    *sqlx.DB
+	scope string
+	key   string
+
+	lock sync.Mutex
+	// reservedAt tells this reservation apart from a later one that took
+	// over the key after it timed out. It moves forward every time the
+	// reservation is renewed.
+	reservedAt time.Time
+	done       chan struct{}
+}
+
+func hashRequest(body []byte) string {
+	h := sha256.Sum256(body)
+	return hex.EncodeToString(h[:])
+}
+
+// ReserveIdempotencyKey claims the key for a request with the given body.
+// When the key was already used for the same request and the request
+// completed, it returns the stored response instead of a reservation. A
+// reservation of the same request that is in progress for longer than
+// IdempotencyTimeout is taken over.
+func ReserveIdempotencyKey(db *sqlx.DB, scope string, key string, body []byte) (*IdempotencyKey, *StoredResponse, error) {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return nil, nil, ErrInvalidIdempotencyKey
+	}
+	// The database keeps microseconds, reservedAt must compare equal
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	requestHash := hashRequest(body)
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE creation_time < $1`,
+		pq.QuoteIdentifier(common.IdempotencyKeysTable))
+	_, err := db.Exec(query, now.Add(-IdempotencyRetention()))
+	if err != nil {
+		ctx.WithError(err).Error("failed to purge idempotency keys")
+		return nil, nil, err
+	}
+
+	query = fmt.Sprintf(`INSERT INTO %s (
+		scope, key,
+		request_hash,
+		creation_time
+	) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (scope, key) DO UPDATE SET creation_time = EXCLUDED.creation_time
+	WHERE %s.status_code IS NULL
+	AND %s.request_hash = EXCLUDED.request_hash
+	AND %s.creation_time < $5`,
+		pq.QuoteIdentifier(common.IdempotencyKeysTable),
+		pq.QuoteIdentifier(common.IdempotencyKeysTable),
+		pq.QuoteIdentifier(common.IdempotencyKeysTable),
+		pq.QuoteIdentifier(common.IdempotencyKeysTable))
+	res, err := db.Exec(query, scope, key, requestHash, now,
+		now.Add(-IdempotencyTimeout()))
+	if err != nil {
+		ctx.WithError(err).Error("failed to reserve idempotency key")
+		return nil, nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return nil, nil, err
+	}
+	if n == 1 {
+		k := &IdempotencyKey{
+			db:         db,
+			scope:      scope,
+			key:        key,
+			reservedAt: now,
+			done:       make(chan struct{}),
+		}
+		go k.keepAlive(IdempotencyTimeout() / idempotencyRenewals)
+		return k, nil, nil
+	}
+
+	var (
+		storedHash string
+		statusCode sql.NullInt64
+		response   types.NullJSONText
+	)
+	query = fmt.Sprintf(`SELECT
+		request_hash,
+		status_code,
+		response
+		FROM %s
+		WHERE scope = $1 AND key = $2`,
+		pq.QuoteIdentifier(common.IdempotencyKeysTable))
+	err = db.QueryRow(query, scope, key).Scan(&storedHash, &statusCode, &response)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// The key was released in the meantime
+			return nil, nil, ErrIdempotencyKeyInUse
+		}
+		ctx.WithError(err).Error("failed to lookup idempotency key")
+		return nil, nil, err
+	}
+	if storedHash != requestHash {
+		return nil, nil, ErrIdempotencyKeyMismatch
+	}
+	if !statusCode.Valid {
+		return nil, nil, ErrIdempotencyKeyInUse
+	}
+	stored := &StoredResponse{StatusCode: int(statusCode.Int64)}
+	if response.Valid {
+		err = response.Unmarshal(&stored.Body)
+		if err != nil {
+			ctx.WithError(err).Error("failed to unmarshal stored response")
+			return nil, nil, err
+		}
+	}
+	return nil, stored, nil
+}
+
+// keepAlive renews the reservation every interval until the request ends,
+// so that a retry only takes over the key of a request that is no longer
+// running
+func (k *IdempotencyKey) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-ticker.C:
+			k.renew()
+		}
+	}
+}
+
+// renew pushes the creation time of the reservation forward. It does
+// nothing once the request ended or the key was taken over.
+func (k *IdempotencyKey) renew() error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	select {
+	case <-k.done:
+		return nil
+	default:
+	}
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	query := fmt.Sprintf(`UPDATE %s SET
+		creation_time = $4
+		WHERE scope = $1 AND key = $2 AND creation_time = $3
+		AND status_code IS NULL`,
+		pq.QuoteIdentifier(common.IdempotencyKeysTable))
+	res, err := k.db.Exec(query, k.scope, k.key, k.reservedAt, now)
+	if err != nil {
+		ctx.WithError(err).Error("failed to renew idempotency key")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return err
+	}
+	if n == 1 {
+		k.reservedAt = now
+	}
+	return nil
+}
+
+// end stops renewing the reservation and returns the creation time it
+// currently holds
+func (k *IdempotencyKey) end() time.Time {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	select {
+	case <-k.done:
+	default:
+		close(k.done)
+	}
+	return k.reservedAt
+}
+
+// Complete stores the response so that it is returned to retries. It does
+// nothing when the reservation timed out and was taken over.
+func (k *IdempotencyKey) Complete(statusCode int, body map[string]interface{}) error {
+	reservedAt := k.end()
+	responseStr, err := json.Marshal(body)
+	if err != nil {
+		ctx.WithError(err).Error("failed to serialise response")
+		return err
+	}
+	query := fmt.Sprintf(`UPDATE %s SET
+		status_code = $3,
+		response = $4
+		WHERE scope = $1 AND key = $2 AND creation_time = $5`,
+		pq.QuoteIdentifier(common.IdempotencyKeysTable))
+	_, err = k.db.Exec(query, k.scope, k.key, statusCode, responseStr,
+		reservedAt)
+	if err != nil {
+		ctx.WithError(err).Error("failed to complete idempotency key")
+		return err
+	}
+	return nil
+}
+
+// Release frees the key so that the request can be retried, for example
+// after it failed
+func (k *IdempotencyKey) Release() error {
+	reservedAt := k.end()
+	query := fmt.Sprintf(`DELETE FROM %s
+		WHERE scope = $1 AND key = $2 AND creation_time = $3`,
+		pq.QuoteIdentifier(common.IdempotencyKeysTable))
+	_, err := k.db.Exec(query, k.scope, k.key, reservedAt)
+	if err != nil {
+		ctx.WithError(err).Error("failed to release idempotency key")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestReserveIdempotencyKey(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := sqlx.NewDb(mockDB, "sqlmock")
+	body := []byte(`{"comment":"test"}`)
+
+	mock.ExpectExec("^DELETE FROM").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^INSERT INTO").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	key, stored, err := ReserveIdempotencyKey(db, "add_job", "key-1", body)
+	if err != nil {
+		t.Fatalf("error in reserving the key: %s", err)
+	}
+	if key == nil || stored != nil {
+		t.Fatal("expected a new reservation")
+	}
+
+	mock.ExpectExec("^DELETE FROM").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^INSERT INTO").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("^SELECT (.+) FROM").
+		WithArgs("add_job", "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash",
+			"status_code", "response"}).
+			AddRow(hashRequest(body), 200, []byte(`{"id":"job-1"}`)))
+	key, stored, err = ReserveIdempotencyKey(db, "add_job", "key-1", body)
+	if err != nil {
+		t.Fatalf("error in reserving the key: %s", err)
+	}
+	if key != nil || stored == nil {
+		t.Fatal("expected the stored response")
+	}
+	if stored.StatusCode != 200 || stored.Body["id"] != "job-1" {
+		t.Errorf("unexpected stored response: %v", stored)
+	}
+
+	mock.ExpectExec("^DELETE FROM").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^INSERT INTO").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("^SELECT (.+) FROM").
+		WithArgs("add_job", "key-1").
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash",
+			"status_code", "response"}).
+			AddRow(hashRequest(body), 200, []byte(`{"id":"job-1"}`)))
+	_, _, err = ReserveIdempotencyKey(db, "add_job", "key-1", []byte(`{}`))
+	if err != ErrIdempotencyKeyMismatch {
+		t.Errorf("expected a mismatch, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTakeOverAbandonedIdempotencyKey(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := sqlx.NewDb(mockDB, "sqlmock")
+	body := []byte(`{"comment":"test"}`)
+
+	// The reservation of a crashed request is taken over by the upsert
+	mock.ExpectExec("^DELETE FROM").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^INSERT INTO (.+) ON CONFLICT \\(scope, key\\) DO UPDATE (.+)status_code IS NULL").
+		WithArgs("add_job", "key-1", hashRequest(body),
+			sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	key, stored, err := ReserveIdempotencyKey(db, "add_job", "key-1", body)
+	if err != nil {
+		t.Fatalf("error in reserving the key: %s", err)
+	}
+	if key == nil || stored != nil {
+		t.Fatal("expected the abandoned key to be taken over")
+	}
+
+	// Only the reservation that currently holds the key is completed
+	mock.ExpectExec("^UPDATE (.+) AND creation_time = \\$5").
+		WithArgs("add_job", "key-1", 201, sqlmock.AnyArg(), key.reservedAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err = key.Complete(201, map[string]interface{}{"id": "job-1"}); err != nil {
+		t.Fatalf("failed to complete the key: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRenewIdempotencyKey(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := sqlx.NewDb(mockDB, "sqlmock")
+	body := []byte(`{"comment":"test"}`)
+
+	mock.ExpectExec("^DELETE FROM").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^INSERT INTO").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	key, _, err := ReserveIdempotencyKey(db, "add_job", "key-1", body)
+	if err != nil {
+		t.Fatalf("error in reserving the key: %s", err)
+	}
+	reservedAt := key.reservedAt
+
+	// A running request keeps its reservation from timing out
+	mock.ExpectExec("^UPDATE (.+) SET creation_time = \\$4").
+		WithArgs("add_job", "key-1", reservedAt, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err = key.renew(); err != nil {
+		t.Fatalf("failed to renew the key: %s", err)
+	}
+	if !key.reservedAt.After(reservedAt) {
+		t.Error("expected the reservation to move forward")
+	}
+
+	mock.ExpectExec("^DELETE FROM (.+) AND creation_time = \\$3").
+		WithArgs("add_job", "key-1", key.reservedAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err = key.Release(); err != nil {
+		t.Fatalf("failed to release the key: %s", err)
+	}
+	// Once the request ended the reservation is no longer renewed
+	if err = key.renew(); err != nil {
+		t.Fatalf("failed to renew the key: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAddJobIdempotencyKeyScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := sqlx.NewDb(mockDB, "sqlmock")
+
+	// The key of another account is not found in the scope of bob
+	mock.ExpectExec("^DELETE FROM").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("^INSERT INTO").
+		WithArgs("add_job:bob", "key-1", sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("^SELECT (.+) FROM").
+		WithArgs("add_job:bob", "key-1").
+		WillReturnError(sql.ErrNoRows)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("DB", db)
+	c.Set("Store", sched.Store(sched.NewMemoryStore()))
+	c.Set("Scheduler", (*sched.Scheduler)(nil))
+	c.Set("userID", "bob")
+	c.Set("role", "operator")
+	c.Request = httptest.NewRequest("POST", "/api/v1/admin/job",
+		strings.NewReader(`{"comment":"test"}`))
+	c.Request.Header.Set(IdempotencyKeyHeader, "key-1")
+	AddJobHandler(c)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
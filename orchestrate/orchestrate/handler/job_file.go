@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"fmt"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// yamlToJSON converts the maps decoded by yaml, whose keys can be of any
+// type, into maps that can be encoded as JSON
+func yamlToJSON(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for k, v := range t {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid key %v", k)
+			}
+			value, err := yamlToJSON(v)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, v := range t {
+			value, err := yamlToJSON(v)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = value
+		}
+		return l, nil
+	}
+	return v, nil
+}
+
+// ParseJobDefinition parses a job written in YAML or JSON, in the same
+// format accepted by AddJobHandler
+func ParseJobDefinition(b []byte) (JobData, error) {
+	// JSON documents are also valid YAML
+	var doc interface{}
+	err := yaml.Unmarshal(b, &doc)
+	if err != nil {
+		return JobData{}, err
+	}
+	v, err := yamlToJSON(doc)
+	if err != nil {
+		return JobData{}, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return JobData{}, fmt.Errorf("the job definition must be a mapping")
+	}
+	return jobDataFromMap(m)
+}
@@ -0,0 +1,49 @@
+package handler
+
+import "testing"
+
+func TestParseJobDefinition(t *testing.T) {
+	yamlJob := []byte(`
+schedule: "R/2030-01-01T00:00:00Z/P1D"
+comment: daily web connectivity
+target:
+  countries: [IT, GR]
+task:
+  test_name: web_connectivity
+  arguments:
+    urls: ["http://example.com/"]
+`)
+	jsonJob := []byte(`{
+	"schedule": "R/2030-01-01T00:00:00Z/P1D",
+	"comment": "daily web connectivity",
+	"target": {"countries": ["IT", "GR"]},
+	"task": {
+		"test_name": "web_connectivity",
+		"arguments": {"urls": ["http://example.com/"]}
+	}
+}`)
+	for _, b := range [][]byte{yamlJob, jsonJob} {
+		jd, err := ParseJobDefinition(b)
+		if err != nil {
+			t.Fatalf("failed to parse the job: %s", err)
+		}
+		if jd.TaskData == nil || jd.TaskData.TestName != "web_connectivity" {
+			t.Errorf("wrong task: %v", jd.TaskData)
+		}
+		if len(jd.Target.Countries) != 2 {
+			t.Errorf("wrong target: %v", jd.Target)
+		}
+		urls, ok := jd.TaskData.Arguments["urls"].([]interface{})
+		if !ok || len(urls) != 1 {
+			t.Errorf("wrong arguments: %v", jd.TaskData.Arguments)
+		}
+		if err := ValidateJob(jd); err != nil {
+			t.Errorf("the job should be valid: %s", err)
+		}
+	}
+
+	_, err := ParseJobDefinition([]byte(`comment: missing schedule`))
+	if err == nil {
+		t.Error("a job without a schedule should be refused")
+	}
+}
@@ -0,0 +1,283 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+	common "github.com/ooni/orchestra/common"
+	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
+)
+
+// JobTask is a task of a job as seen by the admin API
+type JobTask struct {
+	ID        string                 `json:"id"`
+	ProbeID   string                 `json:"probe_id"`
+	TestName  string                 `json:"test_name"`
+	Arguments map[string]interface{} `json:"arguments"`
+	State     string                 `json:"state"`
+
+	ProbeCC  string `json:"probe_cc"`
+	ProbeASN string `json:"probe_asn"`
+	Platform string `json:"platform"`
+
+	CreationTime     time.Time  `json:"creation_time"`
+	NotificationTime *time.Time `json:"notification_time"`
+	AcceptTime       *time.Time `json:"accept_time"`
+	DoneTime         *time.Time `json:"done_time"`
+	LeaseExpiry      *time.Time `json:"lease_expiry"`
+}
+
+// JobTasksQuery is the query for the tasks of a job. Every filter is a
+// comma separated list of values.
+type JobTasksQuery struct {
+	Limit       int64  `form:"limit" binding:"max=1000"`
+	State       string `form:"state"`
+	CountryCode string `form:"country_code"`
+	Platform    string `form:"platform"`
+	// Cursor is the next_cursor returned in the metadata of the previous
+	// page
+	Cursor string `form:"cursor"`
+}
+
+// ErrInvalidCursor the pagination cursor could not be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrInvalidTaskState the state filter contains an unknown task state
+var ErrInvalidTaskState = errors.New("invalid task state")
+
+// encodeCursor returns the cursor pointing right after the row with the
+// given creation time and ID. It is used to paginate both jobs and tasks.
+func encodeCursor(creationTime time.Time, id string) string {
+	c := fmt.Sprintf("%s_%s", creationTime.UTC().Format(time.RFC3339Nano), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(c))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(b), "_", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return t, parts[1], nil
+}
+
+func filterJobTasks(q JobTasksQuery, query string, args []interface{}) (string, []interface{}, error) {
+	if q.State != "" {
+		states := strings.Split(q.State, ",")
+		for _, s := range states {
+			valid := false
+			for _, ts := range sched.TaskStates {
+				if s == ts {
+					valid = true
+				}
+			}
+			if !valid {
+				return query, args, ErrInvalidTaskState
+			}
+		}
+		args = append(args, pq.StringArray(states))
+		query += fmt.Sprintf(" AND COALESCE(tasks.state, 'ready') = ANY($%d)", len(args))
+	}
+	if q.CountryCode != "" {
+		args = append(args, pq.StringArray(
+			common.MapToUppercase(strings.Split(q.CountryCode, ","))))
+		query += fmt.Sprintf(" AND active_probes.probe_cc = ANY($%d)", len(args))
+	}
+	if q.Platform != "" {
+		args = append(args, pq.StringArray(strings.Split(q.Platform, ",")))
+		query += fmt.Sprintf(" AND active_probes.platform = ANY($%d)", len(args))
+	}
+	if q.Cursor != "" {
+		creationTime, taskID, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return query, args, err
+		}
+		args = append(args, creationTime, taskID)
+		query += fmt.Sprintf(" AND (tasks.creation_time, tasks.id) > ($%d, $%d)",
+			len(args)-1, len(args))
+	}
+	return query, args, nil
+}
+
+// jobTasksQuery returns the query selecting the tasks of the job $1 joined
+// with the metadata of their probe. The result rows are read by scanJobTask.
+func jobTasksQuery() string {
+	return fmt.Sprintf(`SELECT
+		tasks.id,
+		tasks.probe_id,
+		tasks.test_name,
+		tasks.arguments,
+		COALESCE(tasks.state, 'ready'),
+		COALESCE(active_probes.probe_cc, ''),
+		COALESCE(active_probes.probe_asn, ''),
+		COALESCE(active_probes.platform, ''),
+		tasks.creation_time,
+		tasks.notification_time,
+		tasks.accept_time,
+		tasks.done_time,
+		tasks.lease_expiry
+		FROM %s
+		LEFT OUTER JOIN %s ON (active_probes.id = tasks.probe_id)
+		WHERE tasks.job_id = $1`,
+		pq.QuoteIdentifier(common.TasksTable),
+		pq.QuoteIdentifier(common.ActiveProbesTable))
+}
+
+func scanJobTask(rows *sql.Rows) (JobTask, error) {
+	var (
+		t        JobTask
+		taskArgs types.JSONText
+	)
+	err := rows.Scan(&t.ID,
+		&t.ProbeID,
+		&t.TestName,
+		&taskArgs,
+		&t.State,
+		&t.ProbeCC,
+		&t.ProbeASN,
+		&t.Platform,
+		&t.CreationTime,
+		&t.NotificationTime,
+		&t.AcceptTime,
+		&t.DoneTime,
+		&t.LeaseExpiry)
+	if err != nil {
+		ctx.WithError(err).Error("failed to iterate over job tasks")
+		return t, err
+	}
+	err = taskArgs.Unmarshal(&t.Arguments)
+	if err != nil {
+		ctx.WithError(err).Error("failed to unmarshal task args JSON")
+		return t, err
+	}
+	return t, nil
+}
+
+// ListJobTasks lists the tasks of a job matching the query, ordered by
+// creation time. It returns the cursor of the next page, which is empty
+// when there are no more tasks.
+func ListJobTasks(db *sqlx.DB, jobID string, q JobTasksQuery) ([]JobTask, string, error) {
+	var (
+		err        error
+		tasks      []JobTask
+		nextCursor string
+	)
+	err = jobExists(jobID, db)
+	if err != nil {
+		return tasks, nextCursor, err
+	}
+
+	args := []interface{}{jobID}
+	query, args, err := filterJobTasks(q, jobTasksQuery(), args)
+	if err != nil {
+		return tasks, nextCursor, err
+	}
+	// We fetch one more row than requested to know if there is a next page
+	args = append(args, q.Limit+1)
+	query += fmt.Sprintf(" ORDER BY tasks.creation_time ASC, tasks.id ASC LIMIT $%d",
+		len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		ctx.WithError(err).Error("failed to list job tasks")
+		return tasks, nextCursor, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t, err := scanJobTask(rows)
+		if err != nil {
+			return tasks, nextCursor, err
+		}
+		tasks = append(tasks, t)
+	}
+	if int64(len(tasks)) > q.Limit {
+		tasks = tasks[:q.Limit]
+		last := tasks[len(tasks)-1]
+		nextCursor = encodeCursor(last.CreationTime, last.ID)
+	}
+	return tasks, nextCursor, nil
+}
+
+// ListJobTasksHandler is the admin handler for listing the tasks of a job
+func ListJobTasksHandler(c *gin.Context) {
+	var (
+		err   error
+		query JobTasksQuery
+	)
+	// This is equivalent to setting the default value
+	query.Limit = 100
+
+	db := c.MustGet("DB").(*sqlx.DB)
+	store := c.MustGet("Store").(sched.Store)
+
+	jobID := c.Param("job_id")
+	if !authorizeJob(c, store, jobID, false) {
+		return
+	}
+	if err = c.Bind(&query); err != nil {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if query.Limit <= 0 {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid limit"})
+		return
+	}
+
+	tasks, nextCursor, err := ListJobTasks(db, jobID, query)
+	if err != nil {
+		switch err {
+		case ErrJobNotFound:
+			c.JSON(http.StatusNotFound,
+				gin.H{"error": "job not found"})
+		case ErrInvalidCursor, ErrInvalidTaskState:
+			c.JSON(http.StatusBadRequest,
+				gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError,
+				gin.H{"error": "server side error"})
+		}
+		return
+	}
+
+	metadata := gin.H{
+		"limit":       query.Limit,
+		"count":       len(tasks),
+		"next_cursor": nil,
+		"next_url":    nil,
+	}
+	if nextCursor != "" {
+		metadata["next_cursor"] = nextCursor
+		v := url.Values{}
+		v.Set("state", query.State)
+		v.Set("country_code", query.CountryCode)
+		v.Set("platform", query.Platform)
+		v.Set("limit", fmt.Sprintf("%d", query.Limit))
+		v.Set("cursor", nextCursor)
+		metadata["next_url"] = fmt.Sprintf("/api/v1/admin/job/%s/tasks?%s",
+			jobID, v.Encode())
+	}
+	c.JSON(http.StatusOK,
+		gin.H{
+			"results":  tasks,
+			"metadata": metadata,
+		})
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestListJobTasks(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := sqlx.NewDb(mockDB, "sqlmock")
+
+	mock.ExpectQuery("^SELECT EXISTS").
+		WithArgs("job-1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	now := time.Now().UTC()
+	rows := sqlmock.NewRows([]string{"id", "probe_id", "test_name",
+		"arguments", "state", "probe_cc", "probe_asn", "platform",
+		"creation_time",
+		"notification_time", "accept_time", "done_time", "lease_expiry"}).
+		AddRow("task-1", "probe-1", "web_connectivity", []byte(`{}`), "done",
+			"IT", "AS1234", "android", now, nil, nil, nil, nil).
+		AddRow("task-2", "probe-2", "web_connectivity", []byte(`{}`), "done",
+			"IT", "AS1234", "android", now, nil, nil, nil, nil)
+	mock.ExpectQuery("^SELECT (.+) FROM").
+		WithArgs("job-1", pq.StringArray([]string{"done"}),
+			pq.StringArray([]string{"IT"}), int64(2)).
+		WillReturnRows(rows)
+
+	tasks, nextCursor, err := ListJobTasks(db, "job-1", JobTasksQuery{
+		Limit:       1,
+		State:       "done",
+		CountryCode: "it",
+	})
+	if err != nil {
+		t.Fatalf("error in calling ListJobTasks: %s", err)
+	}
+	if len(tasks) != 1 {
+		t.Errorf("inconsistent count: %d", len(tasks))
+	}
+	creationTime, taskID, err := decodeCursor(nextCursor)
+	if err != nil {
+		t.Fatalf("invalid next cursor: %s", err)
+	}
+	if taskID != "task-1" || !creationTime.Equal(now) {
+		t.Errorf("cursor does not point to the last task: %s %s",
+			taskID, creationTime)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestListJobTasksInvalidState(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := sqlx.NewDb(mockDB, "sqlmock")
+
+	mock.ExpectQuery("^SELECT EXISTS").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	_, _, err = ListJobTasks(db, "job-1", JobTasksQuery{
+		Limit: 10,
+		State: "ready,bogus",
+	})
+	if err != ErrInvalidTaskState {
+		t.Errorf("expected ErrInvalidTaskState (got: %v)", err)
+	}
+}
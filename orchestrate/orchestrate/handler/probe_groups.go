@@ -0,0 +1,468 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+	common "github.com/ooni/orchestra/common"
+	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ProbeGroup is a named set of probes that jobs can target. Its members
+// are the probes added to it and, if it has a filter, the probes matching
+// the filter.
+type ProbeGroup struct {
+	Name        string             `json:"name" binding:"required"`
+	Description string             `json:"description"`
+	Filter      *sched.ProbeFilter `json:"filter"`
+
+	CreationTime time.Time `json:"creation_time"`
+	LastUpdated  time.Time `json:"last_updated"`
+}
+
+// ErrProbeGroupNotFound did not find the probe group in the DB
+var ErrProbeGroupNotFound = sched.ErrProbeGroupNotFound
+
+// ErrProbeGroupExists a probe group with the same name already exists
+var ErrProbeGroupExists = errors.New("probe group already exists")
+
+// ErrProbeGroupInUse jobs that are not deleted still target the group
+var ErrProbeGroupInUse = errors.New("probe group is targeted by jobs")
+
+// ErrInvalidProbeID the probe ID is not a UUID
+var ErrInvalidProbeID = errors.New("invalid probe id")
+
+// ErrProbeNotInGroup the probe is not a member of the probe group
+var ErrProbeNotInGroup = errors.New("probe is not a member of the group")
+
+func marshalProbeFilter(f *sched.ProbeFilter) (types.NullJSONText, error) {
+	if f == nil {
+		return types.NullJSONText{}, nil
+	}
+	if err := f.Validate(); err != nil {
+		return types.NullJSONText{}, err
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		ctx.WithError(err).Error("failed to serialise probe filter")
+		return types.NullJSONText{}, err
+	}
+	return types.NullJSONText{JSONText: b, Valid: true}, nil
+}
+
+// CreateProbeGroup stores a new probe group
+func CreateProbeGroup(db *sqlx.DB, g ProbeGroup) error {
+	filter, err := marshalProbeFilter(g.Filter)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	query := fmt.Sprintf(`INSERT INTO %s (
+		name, description,
+		filter,
+		creation_time,
+		last_updated
+	) VALUES ($1, $2, $3, $4, $4)
+	ON CONFLICT DO NOTHING`,
+		pq.QuoteIdentifier(common.ProbeGroupsTable))
+	res, err := db.Exec(query, g.Name, g.Description, filter, now)
+	if err != nil {
+		ctx.WithError(err).Error("failed to insert probe group")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return err
+	}
+	if n == 0 {
+		return ErrProbeGroupExists
+	}
+	return nil
+}
+
+// UpdateProbeGroup replaces the description and the filter of the group
+func UpdateProbeGroup(db *sqlx.DB, g ProbeGroup) error {
+	filter, err := marshalProbeFilter(g.Filter)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`UPDATE %s SET
+		description = $2,
+		filter = $3,
+		last_updated = $4
+		WHERE name = $1`,
+		pq.QuoteIdentifier(common.ProbeGroupsTable))
+	res, err := db.Exec(query, g.Name, g.Description, filter,
+		time.Now().UTC())
+	if err != nil {
+		ctx.WithError(err).Error("failed to update probe group")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return err
+	}
+	if n == 0 {
+		return ErrProbeGroupNotFound
+	}
+	return nil
+}
+
+func scanProbeGroup(row interface {
+	Scan(dest ...interface{}) error
+}) (ProbeGroup, error) {
+	var (
+		g           ProbeGroup
+		description sql.NullString
+		filter      types.NullJSONText
+	)
+	err := row.Scan(&g.Name, &description, &filter,
+		&g.CreationTime, &g.LastUpdated)
+	if err != nil {
+		return g, err
+	}
+	g.Description = description.String
+	if filter.Valid {
+		g.Filter = &sched.ProbeFilter{}
+		err = filter.Unmarshal(g.Filter)
+		if err != nil {
+			ctx.WithError(err).Error("failed to unmarshal probe filter JSON")
+			return g, err
+		}
+	}
+	return g, nil
+}
+
+// GetProbeGroup returns the probe group with the given name
+func GetProbeGroup(db *sqlx.DB, name string) (ProbeGroup, error) {
+	query := fmt.Sprintf(`SELECT
+		name, description,
+		filter,
+		creation_time,
+		last_updated
+		FROM %s
+		WHERE name = $1`,
+		pq.QuoteIdentifier(common.ProbeGroupsTable))
+	g, err := scanProbeGroup(db.QueryRow(query, name))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return g, ErrProbeGroupNotFound
+		}
+		ctx.WithError(err).Error("failed to get probe group")
+		return g, err
+	}
+	return g, nil
+}
+
+// ListProbeGroups lists all the probe groups
+func ListProbeGroups(db *sqlx.DB) ([]ProbeGroup, error) {
+	groups := []ProbeGroup{}
+	query := fmt.Sprintf(`SELECT
+		name, description,
+		filter,
+		creation_time,
+		last_updated
+		FROM %s
+		ORDER BY name`,
+		pq.QuoteIdentifier(common.ProbeGroupsTable))
+	rows, err := db.Query(query)
+	if err != nil {
+		ctx.WithError(err).Error("failed to list probe groups")
+		return groups, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		g, err := scanProbeGroup(rows)
+		if err != nil {
+			ctx.WithError(err).Error("failed to iterate over probe groups")
+			return groups, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// DeleteProbeGroup deletes the probe group unless jobs still target it
+func DeleteProbeGroup(db *sqlx.DB, name string) error {
+	var inUse bool
+	query := fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM %s
+		WHERE $1 = ANY(target_groups) AND state IS DISTINCT FROM 'deleted')`,
+		pq.QuoteIdentifier(common.JobsTable))
+	err := db.QueryRow(query, name).Scan(&inUse)
+	if err != nil {
+		ctx.WithError(err).Error("failed to lookup jobs targeting the group")
+		return err
+	}
+	if inUse {
+		return ErrProbeGroupInUse
+	}
+
+	query = fmt.Sprintf(`DELETE FROM %s WHERE name = $1`,
+		pq.QuoteIdentifier(common.ProbeGroupsTable))
+	res, err := db.Exec(query, name)
+	if err != nil {
+		ctx.WithError(err).Error("failed to delete probe group")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return err
+	}
+	if n == 0 {
+		return ErrProbeGroupNotFound
+	}
+	return nil
+}
+
+// ListProbeGroupMembers returns the probes added to the group. It does not
+// include the probes matching the filter of the group.
+func ListProbeGroupMembers(db *sqlx.DB, name string) ([]string, error) {
+	probeIDs := []string{}
+	query := fmt.Sprintf(`SELECT probe_id FROM %s
+		WHERE group_name = $1
+		ORDER BY added_time, probe_id`,
+		pq.QuoteIdentifier(common.ProbeGroupMembersTable))
+	err := db.Select(&probeIDs, query, name)
+	if err != nil {
+		ctx.WithError(err).Error("failed to list probe group members")
+		return probeIDs, err
+	}
+	return probeIDs, nil
+}
+
+// AddProbeGroupMembers adds the probes to the group. Probes that are
+// already members are ignored.
+func AddProbeGroupMembers(db *sqlx.DB, name string, probeIDs []string) error {
+	for _, id := range probeIDs {
+		if _, err := uuid.FromString(id); err != nil {
+			return ErrInvalidProbeID
+		}
+	}
+	_, err := GetProbeGroup(db, name)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`INSERT INTO %s (group_name, probe_id, added_time)
+		SELECT $1, unnest($2::uuid[]), $3
+		ON CONFLICT DO NOTHING`,
+		pq.QuoteIdentifier(common.ProbeGroupMembersTable))
+	_, err = db.Exec(query, name, pq.StringArray(probeIDs), time.Now().UTC())
+	if err != nil {
+		ctx.WithError(err).Error("failed to add probe group members")
+		return err
+	}
+	return nil
+}
+
+// RemoveProbeGroupMember removes the probe from the group
+func RemoveProbeGroupMember(db *sqlx.DB, name string, probeID string) error {
+	if _, err := uuid.FromString(probeID); err != nil {
+		return ErrInvalidProbeID
+	}
+	query := fmt.Sprintf(`DELETE FROM %s WHERE group_name = $1 AND probe_id = $2`,
+		pq.QuoteIdentifier(common.ProbeGroupMembersTable))
+	res, err := db.Exec(query, name, probeID)
+	if err != nil {
+		ctx.WithError(err).Error("failed to remove probe group member")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return err
+	}
+	if n == 0 {
+		return ErrProbeNotInGroup
+	}
+	return nil
+}
+
+// writeProbeGroupError maps the errors of the probe group functions to a
+// response
+func writeProbeGroupError(c *gin.Context, err error) {
+	switch err {
+	case ErrProbeGroupNotFound:
+		c.JSON(http.StatusNotFound,
+			gin.H{"error": "probe group not found"})
+	case ErrProbeNotInGroup:
+		c.JSON(http.StatusNotFound,
+			gin.H{"error": err.Error()})
+	case ErrInvalidProbeID:
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+	case ErrProbeGroupExists, ErrProbeGroupInUse:
+		c.JSON(http.StatusConflict,
+			gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+	}
+}
+
+// validProbeGroupFilter checks the saved filter of the group. When it is
+// invalid it writes the error response and returns false.
+func validProbeGroupFilter(c *gin.Context, g ProbeGroup) bool {
+	if g.Filter == nil {
+		return true
+	}
+	if err := g.Filter.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// ListProbeGroupsHandler lists the probe groups
+func ListProbeGroupsHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	groups, err := ListProbeGroups(db)
+	if err != nil {
+		writeProbeGroupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"groups": groups})
+}
+
+// GetProbeGroupHandler returns a probe group with its members
+func GetProbeGroupHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+	store := c.MustGet("Store").(sched.Store)
+
+	g, err := GetProbeGroup(db, c.Param("name"))
+	if err != nil {
+		writeProbeGroupError(c, err)
+		return
+	}
+	members, err := ListProbeGroupMembers(db, g.Name)
+	if err != nil {
+		writeProbeGroupError(c, err)
+		return
+	}
+	resolved, err := store.ResolveProbeGroups([]string{g.Name})
+	if err != nil {
+		writeProbeGroupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{
+			"group":   g,
+			"members": members,
+			// Includes the probes matching the filter
+			"probe_count": len(resolved),
+		})
+}
+
+// AddProbeGroupHandler creates a probe group
+func AddProbeGroupHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	var g ProbeGroup
+	err := c.BindJSON(&g)
+	if err != nil {
+		ctx.WithError(err).Error("invalid request")
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid request"})
+		return
+	}
+	if !validProbeGroupFilter(c, g) {
+		return
+	}
+	err = CreateProbeGroup(db, g)
+	if err != nil {
+		writeProbeGroupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"name": g.Name})
+}
+
+// UpdateProbeGroupHandler replaces the description and filter of a group
+func UpdateProbeGroupHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	var g ProbeGroup
+	body, err := c.GetRawData()
+	if err == nil {
+		err = json.Unmarshal(body, &g)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid request"})
+		return
+	}
+	// The name comes from the path, groups cannot be renamed
+	g.Name = c.Param("name")
+	if !validProbeGroupFilter(c, g) {
+		return
+	}
+	err = UpdateProbeGroup(db, g)
+	if err != nil {
+		writeProbeGroupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"name": g.Name})
+}
+
+// DeleteProbeGroupHandler deletes a probe group
+func DeleteProbeGroupHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	err := DeleteProbeGroup(db, c.Param("name"))
+	if err != nil {
+		writeProbeGroupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"status": "deleted"})
+}
+
+// AddProbeGroupMembersHandler adds the probe_ids of the body to a group
+func AddProbeGroupMembersHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	var req struct {
+		ProbeIDs []string `json:"probe_ids" binding:"required"`
+	}
+	err := c.BindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid request"})
+		return
+	}
+	err = AddProbeGroupMembers(db, c.Param("name"), req.ProbeIDs)
+	if err != nil {
+		writeProbeGroupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"status": "added"})
+}
+
+// RemoveProbeGroupMemberHandler removes a probe from a group
+func RemoveProbeGroupMemberHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	err := RemoveProbeGroupMember(db, c.Param("name"), c.Param("probe_id"))
+	if err != nil {
+		writeProbeGroupError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"status": "removed"})
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestProbeGroupHandlerErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := sqlx.NewDb(mockDB, "sqlmock")
+	probeID := "1b4e28ba-2fa1-11d2-883f-0016d3cca427"
+
+	removeMember := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set("DB", db)
+		c.Params = gin.Params{{Key: "name", Value: "testers"},
+			{Key: "probe_id", Value: probeID}}
+		RemoveProbeGroupMemberHandler(c)
+		return w
+	}
+	mock.ExpectExec("^DELETE FROM \"probe_group_members\"").
+		WithArgs("testers", probeID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if w := removeMember(); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a probe outside the group (got: %d)", w.Code)
+	}
+	mock.ExpectExec("^DELETE FROM \"probe_group_members\"").
+		WithArgs("testers", probeID).
+		WillReturnError(errors.New("connection refused"))
+	w := removeMember()
+	if w.Code != http.StatusInternalServerError ||
+		strings.Contains(w.Body.String(), "connection refused") {
+		t.Errorf("expected a 500 without the database error (got: %d %s)",
+			w.Code, w.Body.String())
+	}
+
+	addGroup := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set("DB", db)
+		c.Request = httptest.NewRequest("POST", "/api/v1/admin/probe-groups",
+			strings.NewReader(body))
+		AddProbeGroupHandler(c)
+		return w
+	}
+	if w := addGroup(`{"name": "testers", "filter": {"min_version": "x"}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid filter (got: %d)", w.Code)
+	}
+	mock.ExpectExec("^INSERT INTO \"probe_groups\"").
+		WillReturnError(errors.New("connection refused"))
+	w = addGroup(`{"name": "testers"}`)
+	if w.Code != http.StatusInternalServerError ||
+		strings.Contains(w.Body.String(), "connection refused") {
+		t.Errorf("expected a 500 without the database error (got: %d %s)",
+			w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	common "github.com/ooni/orchestra/common"
+	"github.com/ooni/orchestra/common/middleware"
 )
 
 // DomainFrontedCollector is a {"domain": "a", "front": "b"} map
@@ -135,7 +136,7 @@ func CollectorsHandler(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK,
+	middleware.SignedJSON(c, http.StatusOK,
 		gin.H{"results": collectors})
 	return
 }
@@ -152,7 +153,7 @@ func TestHelpersHandler(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK,
+	middleware.SignedJSON(c, http.StatusOK,
 		gin.H{"results": testHelpers})
 	return
 }
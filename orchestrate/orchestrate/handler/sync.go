@@ -0,0 +1,258 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ooni/orchestra/common/middleware"
+	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
+)
+
+// The actions of a job sync plan
+const (
+	SyncCreate = "create"
+	SyncUpdate = "update"
+	SyncPause  = "pause"
+	SyncResume = "resume"
+	SyncDelete = "delete"
+)
+
+// SyncAction is a step of a job sync plan
+type SyncAction struct {
+	Action string
+	Name   string
+	// JobID is the ID of the existing job, empty for SyncCreate
+	JobID string
+	// Job is the definition from the files, nil for SyncDelete
+	Job *JobData
+	// Changes are the fields that differ, only set for SyncUpdate
+	Changes []string
+}
+
+// String describes the action in the plan printed by jobs sync
+func (a SyncAction) String() string {
+	switch a.Action {
+	case SyncCreate:
+		return fmt.Sprintf("+ create  %s", a.Name)
+	case SyncUpdate:
+		return fmt.Sprintf("~ update  %s (%s), changed: %s", a.Name, a.JobID,
+			strings.Join(a.Changes, ", "))
+	case SyncDelete:
+		return fmt.Sprintf("- delete  %s (%s)", a.Name, a.JobID)
+	}
+	return fmt.Sprintf("  %-7s %s (%s)", a.Action, a.Name, a.JobID)
+}
+
+// isJobFile returns true for the files read by LoadJobDefinitions
+func isJobFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// LoadJobDefinitions reads the YAML and JSON job files in dir and its
+// subdirectories. The jobs are keyed by their name, which defaults to the
+// file name without its extension.
+func LoadJobDefinitions(dir string) (map[string]JobData, error) {
+	jobs := make(map[string]JobData)
+	paths := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isJobFile(path) {
+			return nil
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		jd, err := ParseJobDefinition(b)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		if jd.Name == "" {
+			jd.Name = strings.TrimSuffix(info.Name(), filepath.Ext(path))
+		}
+		switch jd.State {
+		case "":
+			jd.State = "active"
+		case "active", "paused":
+		default:
+			return fmt.Errorf("%s: state must be active or paused", path)
+		}
+		if err = ValidateJob(jd); err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		if other, ok := paths[jd.Name]; ok {
+			return fmt.Errorf("%s and %s both define the job %s", other, path,
+				jd.Name)
+		}
+		paths[jd.Name] = path
+		jobs[jd.Name] = jd
+		return nil
+	})
+	return jobs, err
+}
+
+// syncSpec returns the part of the job compared by jobs sync
+func syncSpec(jd JobData) sched.JobSpec {
+	spec := sched.JobSpec{
+		CampaignID: jd.CampaignID,
+		Comment:    jd.Comment,
+		Schedule:   jd.Schedule,
+		Delay:      jd.Delay,
+		Countries:  jd.Target.Countries,
+		Platforms:  jd.Target.Platforms,
+		Groups:     jd.Target.Groups,
+		TaskData:   jd.TaskData,
+		AlertData:  jd.AlertData,
+	}
+	// The end time can be part of the schedule and is stored separately
+	if schedule, err := jobSchedule(jd); err == nil && !schedule.EndTime.IsZero() {
+		endTime := schedule.EndTime.UTC()
+		spec.EndTime = &endTime
+	}
+	return spec
+}
+
+// specChanges returns the fields of the job definitions that differ
+func specChanges(current sched.JobSpec, wanted sched.JobSpec) []string {
+	normalize := func(spec sched.JobSpec) map[string]interface{} {
+		if len(spec.Countries) == 0 {
+			spec.Countries = nil
+		}
+		if len(spec.Platforms) == 0 {
+			spec.Platforms = nil
+		}
+		if len(spec.Groups) == 0 {
+			spec.Groups = nil
+		}
+		if spec.EndTime != nil {
+			endTime := spec.EndTime.UTC().Truncate(time.Second)
+			spec.EndTime = &endTime
+		}
+		return jobToMap(spec)
+	}
+	c, w := normalize(current), normalize(wanted)
+	var changes []string
+	for _, k := range []string{"comment", "schedule", "delay", "end_time",
+		"target", "task", "alert", "campaign_id"} {
+		if !reflect.DeepEqual(c[k], w[k]) {
+			changes = append(changes, k)
+		}
+	}
+	return changes
+}
+
+// PlanJobSync returns the actions that make the named jobs of the store
+// match the given definitions. Jobs without a name are left alone.
+func PlanJobSync(store sched.Store, jobs map[string]JobData) ([]SyncAction, error) {
+	var plan []SyncAction
+	current, err := store.ListNamedJobs()
+	if err != nil {
+		return plan, err
+	}
+	existing := make(map[string]sched.JobSpec)
+	for _, spec := range current {
+		existing[spec.Name] = spec
+	}
+
+	var names []string
+	for name := range jobs {
+		names = append(names, name)
+	}
+	for name := range existing {
+		if _, ok := jobs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		jd, wanted := jobs[name]
+		spec, found := existing[name]
+		switch {
+		case !found:
+			plan = append(plan, SyncAction{Action: SyncCreate, Name: name,
+				Job: &jd})
+		case !wanted:
+			plan = append(plan, SyncAction{Action: SyncDelete, Name: name,
+				JobID: spec.ID})
+		default:
+			changes := specChanges(spec, syncSpec(jd))
+			if len(changes) > 0 {
+				plan = append(plan, SyncAction{Action: SyncUpdate, Name: name,
+					JobID: spec.ID, Job: &jd, Changes: changes})
+			} else if spec.State == "active" && jd.State == "paused" {
+				plan = append(plan, SyncAction{Action: SyncPause, Name: name,
+					JobID: spec.ID, Job: &jd})
+			} else if spec.State == "paused" && jd.State == "active" {
+				plan = append(plan, SyncAction{Action: SyncResume, Name: name,
+					JobID: spec.ID, Job: &jd})
+			}
+		}
+	}
+	return plan, nil
+}
+
+// ApplySyncAction carries out one action of a job sync plan. An updated job
+// keeps its ID and run count.
+func ApplySyncAction(store sched.Store, a SyncAction, s *sched.Scheduler, audit middleware.AuditEntry) error {
+	switch a.Action {
+	case SyncPause:
+		return PauseJob(a.JobID, store, s, audit)
+	case SyncResume:
+		return ResumeJob(a.JobID, store, s, audit)
+	case SyncDelete:
+		return DeleteJob(a.JobID, store, s, audit)
+	case SyncUpdate:
+		return updateSyncedJob(store, a, s, audit)
+	case SyncCreate:
+	default:
+		return fmt.Errorf("unknown sync action %s", a.Action)
+	}
+
+	jobID, err := AddJob(store, *a.Job, s, audit)
+	if err != nil {
+		return err
+	}
+	if a.Job.State == "paused" {
+		return PauseJob(jobID, store, s, audit)
+	}
+	return nil
+}
+
+// updateSyncedJob updates the job and then pauses or resumes it to match
+// the state of its file
+func updateSyncedJob(store sched.Store, a SyncAction, s *sched.Scheduler, audit middleware.AuditEntry) error {
+	err := UpdateJob(store, a.JobID, *a.Job, s, audit)
+	if err != nil {
+		return err
+	}
+	spec, err := store.GetJobSpec(a.JobID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case spec.State == "active" && a.Job.State == "paused":
+		return PauseJob(a.JobID, store, s, audit)
+	case spec.State == "paused" && a.Job.State == "active":
+		return ResumeJob(a.JobID, store, s, audit)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ooni/orchestra/common/middleware"
+	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
+)
+
+func writeJobFile(t *testing.T, dir string, name string, content string) {
+	err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("failed to write %s: %s", name, err)
+	}
+}
+
+func planActions(t *testing.T, store sched.Store, dir string) []SyncAction {
+	jobs, err := LoadJobDefinitions(dir)
+	if err != nil {
+		t.Fatalf("failed to load the job files: %s", err)
+	}
+	plan, err := PlanJobSync(store, jobs)
+	if err != nil {
+		t.Fatalf("failed to plan the sync: %s", err)
+	}
+	return plan
+}
+
+func TestJobSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs-sync")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := sched.NewMemoryStore()
+	_, err = AddJob(store, JobData{
+		Schedule:  "R/2030-01-01T00:00:00Z/P1D",
+		Comment:   "added from the API",
+		AlertData: &sched.AlertData{Message: "hello"},
+	}, nil, middleware.AuditEntry{})
+	if err != nil {
+		t.Fatalf("failed to add job: %s", err)
+	}
+
+	writeJobFile(t, dir, "daily-it.yaml", `
+schedule: "R/2030-01-01T00:00:00Z/P1D"
+comment: daily web connectivity in Italy
+target:
+  countries: [IT]
+task:
+  test_name: web_connectivity
+  arguments: {}
+`)
+	writeJobFile(t, dir, "weekly.json", `{
+	"name": "weekly-all",
+	"state": "paused",
+	"schedule": "R/2030-01-01T00:00:00Z/P7D",
+	"comment": "weekly everywhere",
+	"task": {"test_name": "http_invalid_request_line", "arguments": {}}
+}`)
+	writeJobFile(t, dir, "README.md", "not a job")
+
+	plan := planActions(t, store, dir)
+	if len(plan) != 2 || plan[0].Action != SyncCreate || plan[0].Name != "daily-it" ||
+		plan[1].Action != SyncCreate || plan[1].Name != "weekly-all" {
+		t.Fatalf("unexpected plan: %v", plan)
+	}
+	for _, a := range plan {
+		if err = ApplySyncAction(store, a, nil, middleware.AuditEntry{}); err != nil {
+			t.Fatalf("failed to apply %s: %s", a, err)
+		}
+	}
+	if plan = planActions(t, store, dir); len(plan) != 0 {
+		t.Fatalf("expected an empty plan after the sync: %v", plan)
+	}
+	named, _ := store.ListNamedJobs()
+	if len(named) != 2 || named[1].State != "paused" {
+		t.Fatalf("unexpected jobs: %v", named)
+	}
+
+	writeJobFile(t, dir, "daily-it.yaml", `
+schedule: "R/2030-01-01T00:00:00Z/P2D"
+comment: daily web connectivity in Italy
+target:
+  countries: [IT]
+task:
+  test_name: web_connectivity
+  arguments: {}
+`)
+	os.Remove(filepath.Join(dir, "weekly.json"))
+	plan = planActions(t, store, dir)
+	if len(plan) != 2 || plan[0].Action != SyncUpdate ||
+		len(plan[0].Changes) != 1 || plan[0].Changes[0] != "schedule" ||
+		plan[1].Action != SyncDelete {
+		t.Fatalf("unexpected plan: %v", plan)
+	}
+	for _, a := range plan {
+		if err = ApplySyncAction(store, a, nil, middleware.AuditEntry{}); err != nil {
+			t.Fatalf("failed to apply %s: %s", a, err)
+		}
+	}
+	named, _ = store.ListNamedJobs()
+	if len(named) != 1 || named[0].Schedule != "R/2030-01-01T00:00:00Z/P2D" {
+		t.Fatalf("unexpected jobs: %v", named)
+	}
+	active, _ := store.ListActiveJobs()
+	if len(active) != 2 {
+		t.Errorf("expected the API job and daily-it to be active: %v", active)
+	}
+}
+
+func TestJobSyncUpdateKeepsRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs-sync")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := sched.NewMemoryStore()
+	job := `
+schedule: "R5/2030-01-01T00:00:00Z/P1D"
+comment: %s
+task:
+  test_name: web_connectivity
+  arguments: {}
+`
+	writeJobFile(t, dir, "five-times.yaml", fmt.Sprintf(job, "five runs"))
+	for _, a := range planActions(t, store, dir) {
+		if err = ApplySyncAction(store, a, nil, middleware.AuditEntry{}); err != nil {
+			t.Fatalf("failed to apply %s: %s", a, err)
+		}
+	}
+	active, _ := store.ListActiveJobs()
+	if len(active) != 1 {
+		t.Fatalf("expected one active job: %v", active)
+	}
+	jobID := active[0].ID
+	active[0].TimesRun = 3
+	if err = store.SaveJob(active[0]); err != nil {
+		t.Fatalf("failed to save job: %s", err)
+	}
+
+	writeJobFile(t, dir, "five-times.yaml", fmt.Sprintf(job, "five runs in total"))
+	plan := planActions(t, store, dir)
+	if len(plan) != 1 || plan[0].Action != SyncUpdate ||
+		len(plan[0].Changes) != 1 || plan[0].Changes[0] != "comment" {
+		t.Fatalf("unexpected plan: %v", plan)
+	}
+	if err = ApplySyncAction(store, plan[0], nil, middleware.AuditEntry{}); err != nil {
+		t.Fatalf("failed to apply %s: %s", plan[0], err)
+	}
+	active, _ = store.ListActiveJobs()
+	if len(active) != 1 || active[0].ID != jobID || active[0].TimesRun != 3 {
+		t.Fatalf("expected %s to be updated in place: %v", jobID, active)
+	}
+	if active[0].Comment != "five runs in total" {
+		t.Errorf("comment was not updated: %s", active[0].Comment)
+	}
+}
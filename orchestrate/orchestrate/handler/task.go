@@ -1,78 +1,26 @@
 package handler
 
 import (
-	"database/sql"
-	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jmoiron/sqlx"
-	"github.com/jmoiron/sqlx/types"
-	"github.com/lib/pq"
-	common "github.com/ooni/orchestra/common"
 	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
 )
 
-// GetTasksForUser lists all the tasks a user has
-func GetTasksForUser(uID string, since string,
-	db *sqlx.DB) ([]sched.TaskData, error) {
-	var (
-		err   error
-		tasks []sched.TaskData
-	)
-	query := fmt.Sprintf(`SELECT
-		id,
-		test_name,
-		arguments
-		FROM %s
-		WHERE
-		state = 'ready' AND
-		probe_id = $1 AND creation_time >= $2`,
-		pq.QuoteIdentifier(common.TasksTable))
-
-	rows, err := db.Query(query, uID, since)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return tasks, nil
-		}
-		ctx.WithError(err).Error("failed to get task list")
-		return tasks, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var (
-			taskArgs types.JSONText
-			task     sched.TaskData
-		)
-		rows.Scan(&task.ID, &task.TestName, &taskArgs)
-		if err != nil {
-			ctx.WithError(err).Error("failed to get task")
-			return tasks, err
-		}
-		err = taskArgs.Unmarshal(&task.Arguments)
-		if err != nil {
-			ctx.WithError(err).Error("failed to unmarshal json")
-			return tasks, err
-		}
-		tasks = append(tasks, task)
-	}
-	return tasks, nil
-}
-
 // ListTasksHandler lists all the tasks for a user
 func ListTasksHandler(c *gin.Context) {
-	db := c.MustGet("DB").(*sqlx.DB)
+	store := c.MustGet("Store").(sched.Store)
 
 	userID := c.MustGet("userID").(string)
-	since := c.DefaultQuery("since", "2016-10-20T10:30:00Z")
-	_, err := time.Parse(sched.ISOUTCTimeLayout, since)
+	since, err := time.Parse(sched.ISOUTCTimeLayout,
+		c.DefaultQuery("since", "2016-10-20T10:30:00Z"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest,
 			gin.H{"error": "invalid since specified"})
 		return
 	}
-	tasks, err := GetTasksForUser(userID, since, db)
+	tasks, err := sched.ListReadyTasks(userID, since, store)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError,
 			gin.H{"error": "server side error"})
@@ -85,11 +33,11 @@ func ListTasksHandler(c *gin.Context) {
 
 // GetTaskHandler get a specific task
 func GetTaskHandler(c *gin.Context) {
-	db := c.MustGet("DB").(*sqlx.DB)
+	store := c.MustGet("Store").(sched.Store)
 
 	taskID := c.Param("task_id")
 	userID := c.MustGet("userID").(string)
-	task, err := sched.GetTask(taskID, userID, db)
+	task, err := sched.GetTask(taskID, userID, store)
 	if err != nil {
 		if err == sched.ErrAccessDenied {
 			c.JSON(http.StatusUnauthorized,
@@ -112,38 +60,55 @@ func GetTaskHandler(c *gin.Context) {
 	c.JSON(http.StatusOK,
 		gin.H{"id": task.ID,
 			"test_name": task.TestName,
-			"arguments": task.Arguments})
+			"arguments": task.Arguments,
+			"state":     task.State})
 	return
 }
 
+// writeTaskStateError writes the response for a failed task state change.
+// It returns false if there was no error to report.
+func writeTaskStateError(c *gin.Context, err error, inconsistentMsg string) bool {
+	if err == nil {
+		return false
+	}
+	switch err {
+	case sched.ErrInconsistentState:
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": inconsistentMsg})
+	case sched.ErrAccessDenied:
+		c.JSON(http.StatusUnauthorized,
+			gin.H{"error": "access denied"})
+	case sched.ErrTaskNotFound:
+		c.JSON(http.StatusNotFound,
+			gin.H{"error": "task not found"})
+	default:
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+	}
+	return true
+}
+
+// schedulerNow returns the current time on the clock of the scheduler, so
+// that task times and leases agree with the ones it sets
+func schedulerNow(c *gin.Context) time.Time {
+	scheduler, _ := c.MustGet("Scheduler").(*sched.Scheduler)
+	return scheduler.Now()
+}
+
 // AcceptTaskHandler mark a task as accepted
 func AcceptTaskHandler(c *gin.Context) {
-	db := c.MustGet("DB").(*sqlx.DB)
+	store := c.MustGet("Store").(sched.Store)
 
 	taskID := c.Param("task_id")
 	userID := c.MustGet("userID").(string)
 	err := sched.SetTaskState(taskID,
 		userID,
 		"accepted",
-		[]string{"ready", "notified"},
 		"accept_time",
-		db)
-	if err != nil {
-		if err == sched.ErrInconsistentState {
-			c.JSON(http.StatusBadRequest,
-				gin.H{"error": "task already accepted"})
-			return
-		}
-		if err == sched.ErrAccessDenied {
-			c.JSON(http.StatusUnauthorized,
-				gin.H{"error": "access denied"})
-			return
-		}
-		if err == sched.ErrTaskNotFound {
-			c.JSON(http.StatusNotFound,
-				gin.H{"error": "task not found"})
-			return
-		}
+		schedulerNow(c),
+		store)
+	if writeTaskStateError(c, err, "task already accepted") {
+		return
 	}
 	c.JSON(http.StatusOK,
 		gin.H{"status": "accepted"})
@@ -152,32 +117,18 @@ func AcceptTaskHandler(c *gin.Context) {
 
 // RejectTaskHandler reject a certain task
 func RejectTaskHandler(c *gin.Context) {
-	db := c.MustGet("DB").(*sqlx.DB)
+	store := c.MustGet("Store").(sched.Store)
 
 	taskID := c.Param("task_id")
 	userID := c.MustGet("userID").(string)
 	err := sched.SetTaskState(taskID,
 		userID,
 		"rejected",
-		[]string{"ready", "notified", "accepted"},
 		"done_time",
-		db)
-	if err != nil {
-		if err == sched.ErrInconsistentState {
-			c.JSON(http.StatusBadRequest,
-				gin.H{"error": "task already done"})
-			return
-		}
-		if err == sched.ErrAccessDenied {
-			c.JSON(http.StatusUnauthorized,
-				gin.H{"error": "access denied"})
-			return
-		}
-		if err == sched.ErrTaskNotFound {
-			c.JSON(http.StatusNotFound,
-				gin.H{"error": "task not found"})
-			return
-		}
+		schedulerNow(c),
+		store)
+	if writeTaskStateError(c, err, "task already done") {
+		return
 	}
 	c.JSON(http.StatusOK,
 		gin.H{"status": "rejected"})
@@ -186,34 +137,67 @@ func RejectTaskHandler(c *gin.Context) {
 
 // DoneTaskHandler mark a certain task as done
 func DoneTaskHandler(c *gin.Context) {
-	db := c.MustGet("DB").(*sqlx.DB)
+	store := c.MustGet("Store").(sched.Store)
 
 	taskID := c.Param("task_id")
 	userID := c.MustGet("userID").(string)
 	err := sched.SetTaskState(taskID,
 		userID,
 		"done",
-		[]string{"accepted"},
 		"done_time",
-		db)
+		schedulerNow(c),
+		store)
+	if writeTaskStateError(c, err, "task already done") {
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"status": "done"})
+	return
+}
+
+// LeaseTaskHandler claims the next ready task of the probe for the duration
+// of a lease
+func LeaseTaskHandler(c *gin.Context) {
+	store := c.MustGet("Store").(sched.Store)
+
+	userID := c.MustGet("userID").(string)
+	task, leaseExpiry, err := sched.LeaseTask(userID,
+		schedulerNow(c),
+		sched.TaskLeaseDuration(),
+		store)
 	if err != nil {
-		if err == sched.ErrInconsistentState {
-			c.JSON(http.StatusBadRequest,
-				gin.H{"error": "task already done"})
-			return
-		}
-		if err == sched.ErrAccessDenied {
-			c.JSON(http.StatusUnauthorized,
-				gin.H{"error": "access denied"})
-			return
-		}
-		if err == sched.ErrTaskNotFound {
+		if err == sched.ErrNoTaskAvailable {
 			c.JSON(http.StatusNotFound,
-				gin.H{"error": "task not found"})
+				gin.H{"error": "no task available"})
 			return
 		}
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
 	}
 	c.JSON(http.StatusOK,
-		gin.H{"status": "done"})
+		gin.H{"id": task.ID,
+			"test_name":    task.TestName,
+			"arguments":    task.Arguments,
+			"lease_expiry": leaseExpiry})
+	return
+}
+
+// ExtendTaskLeaseHandler extends the lease of an accepted task
+func ExtendTaskLeaseHandler(c *gin.Context) {
+	store := c.MustGet("Store").(sched.Store)
+
+	taskID := c.Param("task_id")
+	userID := c.MustGet("userID").(string)
+	leaseExpiry, err := sched.ExtendTaskLease(taskID,
+		userID,
+		schedulerNow(c),
+		sched.TaskLeaseDuration(),
+		store)
+	if writeTaskStateError(c, err, "task is not accepted") {
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"lease_expiry": leaseExpiry})
 	return
 }
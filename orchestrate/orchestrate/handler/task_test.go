@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
+)
+
+func TestAcceptTaskHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := sched.NewMemoryStore()
+	taskID, err := store.CreateTask("job-1", "probe-1",
+		&sched.TaskData{TestName: "web_connectivity"}, time.Now().UTC())
+	if err != nil {
+		t.Fatalf("failed to create task: %s", err)
+	}
+
+	accept := func(userID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set("Store", sched.Store(store))
+		c.Set("Scheduler", (*sched.Scheduler)(nil))
+		c.Set("userID", userID)
+		c.Params = gin.Params{{Key: "task_id", Value: taskID}}
+		AcceptTaskHandler(c)
+		return w
+	}
+
+	if w := accept("probe-2"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for another probe (got: %d)", w.Code)
+	}
+	w := accept("probe-1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 (got: %d)", w.Code)
+	}
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["status"] != "accepted" {
+		t.Errorf("unexpected response: %s", w.Body.String())
+	}
+	if w := accept("probe-1"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 when accepting twice (got: %d)", w.Code)
+	}
+}
+
+func TestLeaseTaskHandlerUsesSchedulerClock(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := sched.NewMemoryStore()
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := sched.NewFakeClock(now)
+	scheduler := sched.NewSchedulerWithStore(store, clock)
+	_, err := store.CreateTask("job-1", "probe-1",
+		&sched.TaskData{TestName: "web_connectivity"}, now)
+	if err != nil {
+		t.Fatalf("failed to create task: %s", err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("Store", sched.Store(store))
+	c.Set("Scheduler", scheduler)
+	c.Set("userID", "probe-1")
+	LeaseTaskHandler(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 (got: %d)", w.Code)
+	}
+	var resp struct {
+		LeaseExpiry time.Time `json:"lease_expiry"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !resp.LeaseExpiry.Equal(now.Add(sched.TaskLeaseDuration())) {
+		t.Errorf("lease expiry is not based on the scheduler clock: %s",
+			resp.LeaseExpiry)
+	}
+}
@@ -0,0 +1,425 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+	common "github.com/ooni/orchestra/common"
+	"github.com/ooni/orchestra/common/middleware"
+	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
+)
+
+// JobTemplate is a named, partial job definition. Jobs are created from it
+// by merging overrides into Job.
+type JobTemplate struct {
+	Name        string                 `json:"name" binding:"required"`
+	Description string                 `json:"description"`
+	Job         map[string]interface{} `json:"job" binding:"required"`
+
+	CreationTime time.Time `json:"creation_time"`
+	LastUpdated  time.Time `json:"last_updated"`
+}
+
+// ErrTemplateNotFound did not find the job template in the DB
+var ErrTemplateNotFound = errors.New("job template not found")
+
+// ErrTemplateExists a job template with the same name already exists
+var ErrTemplateExists = errors.New("job template already exists")
+
+// mergePatch applies the JSON merge patch (RFC 7396) patch to target.
+// Objects are merged recursively, null removes a key and every other value
+// replaces the one in target.
+func mergePatch(target map[string]interface{}, patch map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{})
+	for k, v := range target {
+		merged[k] = v
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		pv, isObj := v.(map[string]interface{})
+		tv, wasObj := merged[k].(map[string]interface{})
+		if isObj && wasObj {
+			merged[k] = mergePatch(tv, pv)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// jobDataFromMap turns a JSON job definition into JobData, applying the
+// same validation as AddJobHandler
+func jobDataFromMap(m map[string]interface{}) (JobData, error) {
+	var jd JobData
+	b, err := json.Marshal(m)
+	if err != nil {
+		return jd, err
+	}
+	if err = json.Unmarshal(b, &jd); err != nil {
+		return jd, err
+	}
+	if err = binding.Validator.ValidateStruct(&jd); err != nil {
+		return jd, err
+	}
+	return jd, nil
+}
+
+// jobToMap returns the definition of an existing job in the form accepted
+// by AddJobHandler
+func jobToMap(spec sched.JobSpec) map[string]interface{} {
+	m := map[string]interface{}{
+		"schedule": spec.Schedule,
+		"delay":    spec.Delay,
+		"comment":  spec.Comment,
+		"target": map[string]interface{}{
+			"countries": spec.Countries,
+			"platforms": spec.Platforms,
+		},
+	}
+	if len(spec.Groups) > 0 {
+		m["target"].(map[string]interface{})["groups"] = spec.Groups
+	}
+	if spec.TaskData != nil {
+		m["task"] = map[string]interface{}{
+			"test_name": spec.TaskData.TestName,
+			"arguments": spec.TaskData.Arguments,
+		}
+	}
+	if spec.AlertData != nil {
+		m["alert"] = map[string]interface{}{
+			"message": spec.AlertData.Message,
+			"extra":   spec.AlertData.Extra,
+		}
+	}
+	if spec.EndTime != nil {
+		m["end_time"] = spec.EndTime.UTC()
+	}
+	if spec.CampaignID != "" {
+		m["campaign_id"] = spec.CampaignID
+	}
+	// Round trip through JSON so that overrides are merged into plain
+	// JSON values
+	b, _ := json.Marshal(m)
+	var plain map[string]interface{}
+	json.Unmarshal(b, &plain)
+	return plain
+}
+
+// CloneJob creates a new job with the definition of jobID and the overrides
+// merged into it. The actor of the audit entry owns the new job.
+func CloneJob(store sched.Store, jobID string, overrides map[string]interface{}, s *sched.Scheduler, audit middleware.AuditEntry) (string, error) {
+	spec, err := store.GetJobSpec(jobID)
+	if err != nil {
+		return "", err
+	}
+	jd, err := jobDataFromMap(mergePatch(jobToMap(spec), overrides))
+	if err != nil {
+		return "", err
+	}
+	jd.Owner = audit.Actor
+	return AddJob(store, jd, s, audit)
+}
+
+// CreateJobTemplate stores a new job template
+func CreateJobTemplate(db *sqlx.DB, jt JobTemplate) error {
+	jobStr, err := json.Marshal(jt.Job)
+	if err != nil {
+		ctx.WithError(err).Error("failed to serialise job template")
+		return err
+	}
+	now := time.Now().UTC()
+	query := fmt.Sprintf(`INSERT INTO %s (
+		name, description,
+		job,
+		creation_time,
+		last_updated
+	) VALUES ($1, $2, $3, $4, $4)
+	ON CONFLICT DO NOTHING`,
+		pq.QuoteIdentifier(common.JobTemplatesTable))
+	res, err := db.Exec(query, jt.Name, jt.Description, jobStr, now)
+	if err != nil {
+		ctx.WithError(err).Error("failed to insert job template")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return err
+	}
+	if n == 0 {
+		return ErrTemplateExists
+	}
+	return nil
+}
+
+func scanJobTemplate(row interface {
+	Scan(dest ...interface{}) error
+}) (JobTemplate, error) {
+	var (
+		jt          JobTemplate
+		description sql.NullString
+		job         types.JSONText
+	)
+	err := row.Scan(&jt.Name, &description, &job,
+		&jt.CreationTime, &jt.LastUpdated)
+	if err != nil {
+		return jt, err
+	}
+	jt.Description = description.String
+	err = job.Unmarshal(&jt.Job)
+	if err != nil {
+		ctx.WithError(err).Error("failed to unmarshal job template JSON")
+		return jt, err
+	}
+	return jt, nil
+}
+
+// GetJobTemplate returns the job template with the given name
+func GetJobTemplate(db *sqlx.DB, name string) (JobTemplate, error) {
+	query := fmt.Sprintf(`SELECT
+		name, description,
+		job,
+		creation_time,
+		last_updated
+		FROM %s
+		WHERE name = $1`,
+		pq.QuoteIdentifier(common.JobTemplatesTable))
+	jt, err := scanJobTemplate(db.QueryRow(query, name))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return jt, ErrTemplateNotFound
+		}
+		ctx.WithError(err).Error("failed to get job template")
+		return jt, err
+	}
+	return jt, nil
+}
+
+// ListJobTemplates lists all the job templates
+func ListJobTemplates(db *sqlx.DB) ([]JobTemplate, error) {
+	templates := []JobTemplate{}
+	query := fmt.Sprintf(`SELECT
+		name, description,
+		job,
+		creation_time,
+		last_updated
+		FROM %s
+		ORDER BY name`,
+		pq.QuoteIdentifier(common.JobTemplatesTable))
+	rows, err := db.Query(query)
+	if err != nil {
+		ctx.WithError(err).Error("failed to list job templates")
+		return templates, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		jt, err := scanJobTemplate(rows)
+		if err != nil {
+			ctx.WithError(err).Error("failed to iterate over job templates")
+			return templates, err
+		}
+		templates = append(templates, jt)
+	}
+	return templates, nil
+}
+
+// DeleteJobTemplate deletes the job template with the given name
+func DeleteJobTemplate(db *sqlx.DB, name string) error {
+	query := fmt.Sprintf(`DELETE FROM %s WHERE name = $1`,
+		pq.QuoteIdentifier(common.JobTemplatesTable))
+	res, err := db.Exec(query, name)
+	if err != nil {
+		ctx.WithError(err).Error("failed to delete job template")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return err
+	}
+	if n == 0 {
+		return ErrTemplateNotFound
+	}
+	return nil
+}
+
+// InstantiateJobTemplate creates a new job from the template with the
+// overrides merged into it. The actor of the audit entry owns the new job.
+func InstantiateJobTemplate(db *sqlx.DB, store sched.Store, name string, overrides map[string]interface{}, s *sched.Scheduler, audit middleware.AuditEntry) (string, error) {
+	jt, err := GetJobTemplate(db, name)
+	if err != nil {
+		return "", err
+	}
+	jd, err := jobDataFromMap(mergePatch(jt.Job, overrides))
+	if err != nil {
+		return "", err
+	}
+	jd.Owner = audit.Actor
+	return AddJob(store, jd, s, audit)
+}
+
+// bindOverrides reads the optional JSON object of overrides in the body
+func bindOverrides(c *gin.Context) (map[string]interface{}, error) {
+	overrides := make(map[string]interface{})
+	body, err := c.GetRawData()
+	if err != nil {
+		return overrides, err
+	}
+	if len(body) == 0 {
+		return overrides, nil
+	}
+	err = json.Unmarshal(body, &overrides)
+	return overrides, err
+}
+
+// CloneJobHandler creates a copy of a job with the overrides in the body.
+// Operators can only clone the jobs they can read.
+func CloneJobHandler(c *gin.Context) {
+	store := c.MustGet("Store").(sched.Store)
+	scheduler := c.MustGet("Scheduler").(*sched.Scheduler)
+
+	if !authorizeJob(c, store, c.Param("job_id"), false) {
+		return
+	}
+	overrides, err := bindOverrides(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid request"})
+		return
+	}
+	jobID, err := CloneJob(store, c.Param("job_id"), overrides, scheduler,
+		middleware.RequestAuditEntry(c))
+	if err != nil {
+		if err == ErrJobNotFound {
+			c.JSON(http.StatusNotFound,
+				gin.H{"error": "job not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+	middleware.MarkAudited(c)
+	c.JSON(http.StatusOK,
+		gin.H{"id": jobID})
+}
+
+// ListJobTemplatesHandler lists the job templates
+func ListJobTemplatesHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	templates, err := ListJobTemplates(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"templates": templates})
+}
+
+// GetJobTemplateHandler returns a job template
+func GetJobTemplateHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	jt, err := GetJobTemplate(db, c.Param("name"))
+	if err != nil {
+		if err == ErrTemplateNotFound {
+			c.JSON(http.StatusNotFound,
+				gin.H{"error": "job template not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	c.JSON(http.StatusOK, jt)
+}
+
+// AddJobTemplateHandler stores a new job template
+func AddJobTemplateHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	var jt JobTemplate
+	err := c.BindJSON(&jt)
+	if err != nil {
+		ctx.WithError(err).Error("invalid request")
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid request"})
+		return
+	}
+	err = CreateJobTemplate(db, jt)
+	if err != nil {
+		if err == ErrTemplateExists {
+			c.JSON(http.StatusConflict,
+				gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"name": jt.Name})
+}
+
+// DeleteJobTemplateHandler deletes a job template
+func DeleteJobTemplateHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	err := DeleteJobTemplate(db, c.Param("name"))
+	if err != nil {
+		if err == ErrTemplateNotFound {
+			c.JSON(http.StatusNotFound,
+				gin.H{"error": "job template not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"status": "deleted"})
+}
+
+// InstantiateJobTemplateHandler creates a job from a template with the
+// overrides in the body
+func InstantiateJobTemplateHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+	store := c.MustGet("Store").(sched.Store)
+	scheduler := c.MustGet("Scheduler").(*sched.Scheduler)
+
+	overrides, err := bindOverrides(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid request"})
+		return
+	}
+	jobID, err := InstantiateJobTemplate(db, store, c.Param("name"),
+		overrides, scheduler, middleware.RequestAuditEntry(c))
+	if err != nil {
+		if err == ErrTemplateNotFound {
+			c.JSON(http.StatusNotFound,
+				gin.H{"error": "job template not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+	middleware.MarkAudited(c)
+	c.JSON(http.StatusOK,
+		gin.H{"id": jobID})
+}
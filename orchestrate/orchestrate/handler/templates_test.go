@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ooni/orchestra/common/middleware"
+	"github.com/ooni/orchestra/orchestrate/orchestrate/sched"
+)
+
+func TestMergePatch(t *testing.T) {
+	target := map[string]interface{}{
+		"comment": "weekly run",
+		"delay":   float64(0),
+		"target": map[string]interface{}{
+			"countries": []interface{}{"IT"},
+			"platforms": []interface{}{"android"},
+		},
+	}
+	patch := map[string]interface{}{
+		"delay": nil,
+		"target": map[string]interface{}{
+			"countries": []interface{}{"DE", "FR"},
+		},
+	}
+	expected := map[string]interface{}{
+		"comment": "weekly run",
+		"target": map[string]interface{}{
+			"countries": []interface{}{"DE", "FR"},
+			"platforms": []interface{}{"android"},
+		},
+	}
+	merged := mergePatch(target, patch)
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("unexpected merge result: %v", merged)
+	}
+	if _, ok := target["delay"]; !ok {
+		t.Error("mergePatch modified the target")
+	}
+}
+
+func TestCloneJob(t *testing.T) {
+	store := sched.NewMemoryStore()
+	jobID, err := AddJob(store, JobData{
+		Schedule: "R/2030-01-01T00:00:00Z/P1D",
+		Comment:  "weekly run",
+		TaskData: &sched.TaskData{
+			TestName:  "web_connectivity",
+			Arguments: map[string]interface{}{"urls": []interface{}{"http://example.com"}},
+		},
+		Target: Target{Countries: []string{"IT"}},
+	}, nil, middleware.AuditEntry{})
+	if err != nil {
+		t.Fatalf("failed to add job: %s", err)
+	}
+
+	cloneID, err := CloneJob(store, jobID, map[string]interface{}{
+		"target": map[string]interface{}{"countries": []interface{}{"DE"}},
+	}, nil, middleware.AuditEntry{})
+	if err != nil {
+		t.Fatalf("failed to clone job: %s", err)
+	}
+	clone, err := store.GetJobSpec(cloneID)
+	if err != nil {
+		t.Fatalf("failed to get clone: %s", err)
+	}
+	if !reflect.DeepEqual(clone.Countries, []string{"DE"}) {
+		t.Errorf("override was not applied: %v", clone.Countries)
+	}
+	if clone.TaskData == nil || clone.TaskData.TestName != "web_connectivity" {
+		t.Errorf("task was not cloned: %v", clone.TaskData)
+	}
+
+	_, err = CloneJob(store, jobID, map[string]interface{}{
+		"schedule": "invalid",
+	}, nil, middleware.AuditEntry{})
+	if err == nil {
+		t.Error("expected an invalid schedule override to be refused")
+	}
+	_, err = CloneJob(store, jobID, map[string]interface{}{
+		"comment": nil,
+	}, nil, middleware.AuditEntry{})
+	if err == nil {
+		t.Error("expected a missing comment to be refused")
+	}
+}
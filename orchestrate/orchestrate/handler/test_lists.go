@@ -15,6 +15,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	"github.com/ooni/orchestra/common"
+	"github.com/ooni/orchestra/common/middleware"
 	"github.com/spf13/viper"
 )
 
@@ -188,7 +189,7 @@ func URLsHandler(c *gin.Context) {
 		metadata["count"] = len(urls)
 		metadata["pages"] = 1
 	}
-	c.JSON(http.StatusOK,
+	middleware.SignedJSON(c, http.StatusOK,
 		gin.H{
 			"metadata": metadata,
 			"results":  urls,
@@ -291,5 +292,5 @@ func TorTargetsHandler(c *gin.Context) {
 			finalBridgeMap[k] = v
 		}
 	}
-	c.JSON(http.StatusOK, finalBridgeMap)
+	middleware.SignedJSON(c, http.StatusOK, finalBridgeMap)
 }
@@ -0,0 +1,14 @@
+//go:build !pkcs11
+// +build !pkcs11
+
+package orchestrate
+
+import (
+	"crypto"
+	"errors"
+)
+
+// loadHSMKey fails as ooni-orchestrate was built without the pkcs11 tag
+func loadHSMKey(label string) (crypto.Signer, error) {
+	return nil, errors.New("an HSM key is configured but ooni-orchestrate was built without pkcs11 support")
+}
@@ -0,0 +1,18 @@
+//go:build pkcs11
+// +build pkcs11
+
+package orchestrate
+
+import (
+	"crypto"
+
+	"github.com/ooni/orchestra/common/keystore"
+	"github.com/spf13/viper"
+)
+
+// loadHSMKey returns the key with the label stored in the HSM configured
+// with auth.hsm-library
+func loadHSMKey(label string) (crypto.Signer, error) {
+	return keystore.NewSigner(viper.GetString("auth.hsm-library"), label,
+		viper.GetString("auth.hsm-pin"))
+}
@@ -1,6 +1,7 @@
 package orchestrate
 
 import (
+	"crypto"
 	"fmt"
 	"html/template"
 	"net/http"
@@ -50,6 +51,34 @@ func loadTemplates(list ...string) multitemplate.Render {
 	return r
 }
 
+// initResponseSigner returns the signer of the rendezvous responses, using
+// the key stored in the HSM with the label rendezvous.hsm-key-label or the
+// PEM key in rendezvous.signing-key. It returns nil when neither is set.
+func initResponseSigner() (*middleware.ResponseSigner, error) {
+	var (
+		key crypto.Signer
+		err error
+	)
+	if label := viper.GetString("rendezvous.hsm-key-label"); label != "" {
+		key, err = loadHSMKey(label)
+	} else if path := viper.GetString("rendezvous.signing-key"); path != "" {
+		key, err = middleware.LoadPrivateKey(path)
+	} else {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, err := middleware.NewResponseSigner(key)
+	if err != nil {
+		return nil, err
+	}
+	if viper.IsSet("rendezvous.signature-validity") {
+		signer.Validity = viper.GetDuration("rendezvous.signature-validity")
+	}
+	return signer, nil
+}
+
 // SetupRouter will create a gin.Engine
 func SetupRouter(dbURL string) *gin.Engine {
 	var (
@@ -62,7 +91,7 @@ func SetupRouter(dbURL string) *gin.Engine {
 		return nil
 	}
 
-	authMiddleware, err := middleware.InitAuthMiddleware(dbMiddleware.DB)
+	authMiddleware, err := middleware.InitAuthMiddleware(dbMiddleware.DB, nil)
 	if err != nil {
 		ctx.WithError(err).Error("failed to initialise authMiddlewareDevice")
 		return nil
@@ -73,8 +102,18 @@ func SetupRouter(dbURL string) *gin.Engine {
 		return nil
 	}
 
+	responseSigner, err := initResponseSigner()
+	if err != nil {
+		ctx.WithError(err).Error("failed to initialise the response signer")
+		return nil
+	}
+
 	router := gin.Default()
 	router.Use(schedMiddleware.MiddlewareFunc())
+	if responseSigner != nil {
+		router.Use(responseSigner.MiddlewareFunc())
+		router.GET("/api/v1/signing-keys", responseSigner.JWKSHandler)
+	}
 	router.Use(dbMiddleware.MiddlewareFunc())
 	router.Use(cors.New(middleware.CorsConfig()))
 	router.HTMLRender = loadTemplates("home.tmpl")
@@ -85,7 +124,8 @@ func SetupRouter(dbURL string) *gin.Engine {
 			"componentDescription": LongDescription,
 		})
 	})
-	err = apiv1.BindAPI(router, authMiddleware)
+	router.GET("/.well-known/jwks.json", authMiddleware.JWKSHandler)
+	err = apiv1.BindAPI(router, authMiddleware, dbMiddleware.DB)
 	if err != nil {
 		ctx.WithError(err).Error("failed to BinAPI")
 		return nil
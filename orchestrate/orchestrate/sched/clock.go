@@ -0,0 +1,133 @@
+package sched
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Timer is a timer created by a Clock
+type Timer interface {
+	// Stop prevents the timer from firing. It returns false if the timer
+	// already fired or was already stopped.
+	Stop() bool
+}
+
+// Clock is the source of time of the scheduler. It is replaced by a
+// FakeClock in tests and simulations.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now().UTC()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// RealClock is the Clock backed by the system time
+var RealClock Clock = realClock{}
+
+// FakeClock is a Clock whose time only moves when Advance is called. Timers
+// fire synchronously from within Advance, in the order of their deadline.
+type FakeClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	seq    int64
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	// seq keeps timers with the same deadline in creation order
+	seq int64
+	f   func()
+}
+
+// NewFakeClock creates a FakeClock set to the given time
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now.UTC()}
+}
+
+// Now returns the current fake time
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// AfterFunc calls f once the clock is advanced by at least d
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.seq++
+	t := &fakeTimer{
+		clock:    c,
+		deadline: c.now.Add(d),
+		seq:      c.seq,
+		f:        f,
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Stop removes the timer from the clock
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// next removes and returns the earliest timer due by the given time
+func (c *FakeClock) next(until time.Time) *fakeTimer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.timers) == 0 {
+		return nil
+	}
+	sort.Slice(c.timers, func(i, j int) bool {
+		if c.timers[i].deadline.Equal(c.timers[j].deadline) {
+			return c.timers[i].seq < c.timers[j].seq
+		}
+		return c.timers[i].deadline.Before(c.timers[j].deadline)
+	})
+	t := c.timers[0]
+	if t.deadline.After(until) {
+		return nil
+	}
+	c.timers = c.timers[1:]
+	if t.deadline.After(c.now) {
+		c.now = t.deadline
+	}
+	return t
+}
+
+// Advance moves the clock forward by d, firing every timer that becomes due
+// along the way. Timers created while firing are also fired if they are due
+// before the new time.
+func (c *FakeClock) Advance(d time.Duration) {
+	until := c.Now().Add(d)
+	for {
+		t := c.next(until)
+		if t == nil {
+			break
+		}
+		t.f()
+	}
+	c.lock.Lock()
+	c.now = until
+	c.lock.Unlock()
+}
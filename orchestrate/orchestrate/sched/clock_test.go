@@ -0,0 +1,79 @@
+package sched
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+
+	var fired []int
+	c.AfterFunc(2*time.Hour, func() { fired = append(fired, 2) })
+	c.AfterFunc(time.Hour, func() {
+		fired = append(fired, 1)
+		// Timers created while firing run if they are due
+		c.AfterFunc(30*time.Minute, func() { fired = append(fired, 3) })
+	})
+	stopped := c.AfterFunc(time.Minute, func() { fired = append(fired, 0) })
+	if !stopped.Stop() {
+		t.Error("expected Stop to return true on a pending timer")
+	}
+
+	c.Advance(100 * time.Minute)
+	if len(fired) != 2 || fired[0] != 1 || fired[1] != 3 {
+		t.Errorf("unexpected timers fired: %v", fired)
+	}
+	if !c.Now().Equal(start.Add(100 * time.Minute)) {
+		t.Errorf("unexpected time after advance: %s", c.Now())
+	}
+	c.Advance(time.Hour)
+	if len(fired) != 3 || fired[2] != 2 {
+		t.Errorf("unexpected timers fired: %v", fired)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	hourly, err := ParseSchedule("R/2018-01-01T10:00:00Z/2018-01-01T14:00:00Z/PT1H")
+	if err != nil {
+		t.Fatalf("failed to parse schedule: %s", err)
+	}
+	daily, err := ParseSchedule("R3/2018-01-01T12:30:00Z/P1D")
+	if err != nil {
+		t.Fatalf("failed to parse schedule: %s", err)
+	}
+	jobs := []*Job{
+		NewJob("hourly", "", hourly, 0),
+		NewJob("daily", "", daily, 0),
+	}
+
+	runs := Simulate(jobs, start, 7*24*time.Hour)
+	expected := []struct {
+		id string
+		at string
+	}{
+		{"hourly", "2018-01-01T10:00:00Z"},
+		{"hourly", "2018-01-01T11:00:00Z"},
+		{"hourly", "2018-01-01T12:00:00Z"},
+		{"daily", "2018-01-01T12:30:00Z"},
+		{"hourly", "2018-01-01T13:00:00Z"},
+		{"daily", "2018-01-02T12:30:00Z"},
+		{"daily", "2018-01-03T12:30:00Z"},
+	}
+	if len(runs) != len(expected) {
+		t.Fatalf("expected %d runs (got: %v)", len(expected), runs)
+	}
+	for i, e := range expected {
+		if runs[i].JobID != e.id || runs[i].Time.Format(ISOUTCTimeLayout) != e.at {
+			t.Errorf("run %d: expected %s at %s (got: %s at %s)", i, e.id, e.at,
+				runs[i].JobID, runs[i].Time.Format(ISOUTCTimeLayout))
+		}
+	}
+	for _, j := range jobs {
+		if !j.IsDone {
+			t.Errorf("expected %s to be done", j.ID)
+		}
+	}
+}
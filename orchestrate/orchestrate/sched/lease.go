@@ -0,0 +1,56 @@
+package sched
+
+import (
+	"errors"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultTaskLeaseDuration is for how long a probe holds an accepted task
+// before it goes back to the queue, unless the lease is extended
+const DefaultTaskLeaseDuration = 15 * time.Minute
+
+// leaseExpiryInterval is how often the scheduler returns expired leases to
+// the queue
+const leaseExpiryInterval = time.Minute
+
+// ErrNoTaskAvailable when the probe has no ready task to lease
+var ErrNoTaskAvailable = errors.New("no task available")
+
+// TaskLeaseDuration returns the configured duration of a task lease
+func TaskLeaseDuration() time.Duration {
+	if viper.IsSet("core.task-lease-duration") {
+		return viper.GetDuration("core.task-lease-duration")
+	}
+	return DefaultTaskLeaseDuration
+}
+
+// LeaseTask atomically claims the oldest ready task of the probe uID and
+// marks it as accepted until the lease expires. Concurrent callers never
+// receive the same task.
+func LeaseTask(uID string, now time.Time, leaseDuration time.Duration, store Store) (TaskData, time.Time, error) {
+	leaseExpiry := now.Add(leaseDuration)
+	task, err := store.LeaseTask(uID, now, leaseExpiry)
+	return task, leaseExpiry, err
+}
+
+// ExtendTaskLease pushes forward the lease expiry of a task accepted by uID
+func ExtendTaskLease(tID string, uID string, now time.Time, leaseDuration time.Duration, store Store) (time.Time, error) {
+	leaseExpiry := now.Add(leaseDuration)
+	ok, err := store.ExtendTaskLease(tID, uID, now, leaseExpiry)
+	if err != nil {
+		return leaseExpiry, err
+	}
+	if !ok {
+		return leaseExpiry, explainFailedUpdate(tID, uID, store)
+	}
+	return leaseExpiry, nil
+}
+
+// ExpireTaskLeases puts every accepted task whose lease has expired back
+// into the ready state, so that it can be leased again. It returns the
+// number of tasks that were returned to the queue.
+func ExpireTaskLeases(now time.Time, store Store) (int64, error) {
+	return store.ExpireTaskLeases(now)
+}
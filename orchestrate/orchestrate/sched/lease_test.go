@@ -0,0 +1,83 @@
+package sched
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var dummyProbeID = "12345678-1234-5678-1234-567812345678"
+
+func TestLeaseTask(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	store := NewPostgresStore(sqlx.NewDb(mockDB, "sqlmock"))
+
+	rows := sqlmock.NewRows([]string{"id", "test_name", "arguments", "state"}).
+		AddRow("task-1", "web_connectivity",
+			[]byte(`{"urls": ["http://example.com/"]}`), "accepted")
+
+	mock.ExpectQuery("^UPDATE (.+) FOR UPDATE SKIP LOCKED").
+		WithArgs(dummyProbeID, sqlmock.AnyArg(), sqlmock.AnyArg(),
+			pq.Array([]string{"ready", "notified"})).
+		WillReturnRows(rows)
+
+	before := time.Now().UTC()
+	task, leaseExpiry, err := LeaseTask(dummyProbeID, time.Now().UTC(), time.Minute, store)
+	if err != nil {
+		t.Fatalf("error in calling LeaseTask: %s", err)
+	}
+	if task.ID != "task-1" {
+		t.Errorf("unexpected task id: %s", task.ID)
+	}
+	if _, ok := task.Arguments["urls"]; !ok {
+		t.Errorf("task arguments were not unmarshalled: %v", task.Arguments)
+	}
+	if leaseExpiry.Before(before.Add(time.Minute)) {
+		t.Errorf("lease expires too early: %s", leaseExpiry)
+	}
+}
+
+func TestLeaseTaskEmptyQueue(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	store := NewPostgresStore(sqlx.NewDb(mockDB, "sqlmock"))
+
+	mock.ExpectQuery("^UPDATE (.+) FOR UPDATE SKIP LOCKED").
+		WillReturnError(sql.ErrNoRows)
+
+	_, _, err = LeaseTask(dummyProbeID, time.Now().UTC(), time.Minute, store)
+	if err != ErrNoTaskAvailable {
+		t.Errorf("expected ErrNoTaskAvailable (got: %v)", err)
+	}
+}
+
+func TestExpireTaskLeases(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	store := NewPostgresStore(sqlx.NewDb(mockDB, "sqlmock"))
+
+	mock.ExpectExec("^UPDATE (.+) SET state = 'ready'(.+) WHERE state = 'accepted' AND lease_expiry < \\$1").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	n, err := ExpireTaskLeases(time.Now().UTC(), store)
+	if err != nil {
+		t.Fatalf("error in calling ExpireTaskLeases: %s", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 expired leases (got: %d)", n)
+	}
+}
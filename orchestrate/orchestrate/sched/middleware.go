@@ -5,9 +5,9 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
-// GinSchedMiddleware a database aware middleware.
-// It will set the DB property, that can be accessed via:
-// db := c.MustGet("DB").(*sqlx.DB)
+// GinSchedMiddleware a scheduler aware middleware.
+// It will set the Scheduler and Store properties, that can be accessed via:
+// store := c.MustGet("Store").(sched.Store)
 type GinSchedMiddleware struct {
 	db        *sqlx.DB
 	scheduler *Scheduler
@@ -17,6 +17,7 @@ type GinSchedMiddleware struct {
 func (mw *GinSchedMiddleware) MiddlewareFunc() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Set("Scheduler", mw.scheduler)
+		c.Set("Store", mw.scheduler.Store())
 		c.Next()
 	}
 }
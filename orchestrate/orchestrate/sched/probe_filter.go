@@ -0,0 +1,253 @@
+package sched
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/ooni/orchestra/common"
+)
+
+// ProbeFilter selects probes with a valid push token. Every non empty field
+// restricts the selection and the empty filter matches every probe.
+type ProbeFilter struct {
+	Countries []string `json:"countries,omitempty"`
+	Platforms []string `json:"platforms,omitempty"`
+	// ASNs are either in the AS1234 or in the 1234 form
+	ASNs []string `json:"asns,omitempty"`
+	// MinVersion and MaxVersion are an inclusive range of software versions
+	MinVersion string `json:"min_version,omitempty"`
+	MaxVersion string `json:"max_version,omitempty"`
+	// LastSeen only matches the probes that updated their metadata within
+	// this duration. In JSON it is written like 720h.
+	LastSeen time.Duration `json:"-"`
+}
+
+// MarshalJSON writes LastSeen as a duration string
+func (f ProbeFilter) MarshalJSON() ([]byte, error) {
+	type plain ProbeFilter
+	v := struct {
+		plain
+		LastSeen string `json:"last_seen,omitempty"`
+	}{plain: plain(f)}
+	if f.LastSeen != 0 {
+		v.LastSeen = f.LastSeen.String()
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON reads LastSeen from a duration string
+func (f *ProbeFilter) UnmarshalJSON(b []byte) error {
+	type plain ProbeFilter
+	var v struct {
+		plain
+		LastSeen string `json:"last_seen"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*f = ProbeFilter(v.plain)
+	if v.LastSeen != "" {
+		d, err := time.ParseDuration(v.LastSeen)
+		if err != nil {
+			return fmt.Errorf("invalid last_seen %s", v.LastSeen)
+		}
+		f.LastSeen = d
+	}
+	return nil
+}
+
+// ErrInvalidVersion the version is not a dotted list of numbers
+var ErrInvalidVersion = errors.New("invalid version")
+
+// IsEmpty returns true if the filter matches every probe
+func (f ProbeFilter) IsEmpty() bool {
+	return len(f.Countries) == 0 && len(f.Platforms) == 0 &&
+		len(f.ASNs) == 0 && f.MinVersion == "" && f.MaxVersion == "" &&
+		f.LastSeen == 0
+}
+
+// normalizeASN returns the ASN in the AS1234 form used by the probes
+func normalizeASN(asn string) string {
+	asn = strings.ToUpper(strings.TrimSpace(asn))
+	if !strings.HasPrefix(asn, "AS") {
+		asn = "AS" + asn
+	}
+	return asn
+}
+
+// parseVersion splits a version like 2.0.1-beta into its numeric parts and
+// its pre-release suffix
+func parseVersion(v string) ([]int, string, error) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	var pre string
+	if i := strings.IndexAny(v, "-+"); i != -1 {
+		v, pre = v[:i], v[i+1:]
+	}
+	if v == "" {
+		return nil, "", ErrInvalidVersion
+	}
+	var parts []int
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, "", ErrInvalidVersion
+		}
+		parts = append(parts, n)
+	}
+	return parts, pre, nil
+}
+
+// CompareVersions returns -1, 0 or 1 if the version a is lower, equal or
+// greater than b. Missing parts count as zero and a pre-release is lower
+// than the release itself, so 2.0-rc1 < 2.0.0 == 2.0.
+func CompareVersions(a string, b string) (int, error) {
+	pa, preA, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	pb, preB, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na = pa[i]
+		}
+		if i < len(pb) {
+			nb = pb[i]
+		}
+		if na < nb {
+			return -1, nil
+		}
+		if na > nb {
+			return 1, nil
+		}
+	}
+	switch {
+	case preA == preB:
+		return 0, nil
+	case preA == "":
+		return 1, nil
+	case preB == "":
+		return -1, nil
+	case preA < preB:
+		return -1, nil
+	}
+	return 1, nil
+}
+
+// Validate returns an error if the version range is not valid
+func (f ProbeFilter) Validate() error {
+	for _, v := range []string{f.MinVersion, f.MaxVersion} {
+		if v == "" {
+			continue
+		}
+		if _, _, err := parseVersion(v); err != nil {
+			return fmt.Errorf("invalid version %s", v)
+		}
+	}
+	if f.LastSeen < 0 {
+		return errors.New("last seen must be positive")
+	}
+	return nil
+}
+
+// matchesVersion returns true if the version is in the range of the
+// filter. Unparsable versions never match a range.
+func (f ProbeFilter) matchesVersion(v string) bool {
+	if f.MinVersion != "" {
+		c, err := CompareVersions(v, f.MinVersion)
+		if err != nil || c < 0 {
+			return false
+		}
+	}
+	if f.MaxVersion != "" {
+		c, err := CompareVersions(v, f.MaxVersion)
+		if err != nil || c > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// condition returns the SQL condition selecting the probes matching the
+// filter, except for the version range which is checked by matchesVersion.
+// Its parameters are appended to args.
+func (f ProbeFilter) condition(now time.Time, args []interface{}) (string, []interface{}) {
+	var conds []string
+	if len(f.Countries) > 0 {
+		args = append(args, pq.StringArray(common.MapToUppercase(f.Countries)))
+		conds = append(conds, fmt.Sprintf("probe_cc = ANY($%d)", len(args)))
+	}
+	if len(f.Platforms) > 0 {
+		args = append(args, pq.StringArray(f.Platforms))
+		conds = append(conds, fmt.Sprintf("platform = ANY($%d)", len(args)))
+	}
+	if len(f.ASNs) > 0 {
+		asns := make([]string, len(f.ASNs))
+		for i, asn := range f.ASNs {
+			asns[i] = normalizeASN(asn)
+		}
+		args = append(args, pq.StringArray(asns))
+		conds = append(conds, fmt.Sprintf("probe_asn = ANY($%d)", len(args)))
+	}
+	if f.LastSeen > 0 {
+		args = append(args, now.Add(-f.LastSeen))
+		conds = append(conds, fmt.Sprintf("last_updated >= $%d", len(args)))
+	}
+	if len(conds) == 0 {
+		return "true", args
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// query returns the parameterized query selecting the probes matching the
+// filter, except for the version range which is checked by matchesVersion
+func (f ProbeFilter) query(now time.Time) (string, []interface{}) {
+	cond, args := f.condition(now, nil)
+	query := fmt.Sprintf(`SELECT
+		id, token, platform,
+		COALESCE(probe_cc, ''),
+		COALESCE(software_version, '')
+		FROM %s
+		WHERE is_token_expired = false AND token != ''
+		AND %s
+		ORDER BY id`,
+		pq.QuoteIdentifier(common.ActiveProbesTable), cond)
+	return query, args
+}
+
+// ListProbes returns the probes matching the filter
+func (s *PostgresStore) ListProbes(f ProbeFilter) ([]Probe, error) {
+	var probes []Probe
+	if err := f.Validate(); err != nil {
+		return probes, err
+	}
+	query, args := f.query(time.Now().UTC())
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		ctx.WithError(err).Error("failed to find probes")
+		return probes, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p Probe
+		err = rows.Scan(&p.ID, &p.Token, &p.Platform, &p.ProbeCC,
+			&p.SoftwareVersion)
+		if err != nil {
+			ctx.WithError(err).Error("failed to iterate over probes")
+			return probes, err
+		}
+		if !f.matchesVersion(p.SoftwareVersion) {
+			continue
+		}
+		probes = append(probes, p)
+	}
+	return probes, nil
+}
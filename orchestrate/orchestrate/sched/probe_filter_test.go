@@ -0,0 +1,81 @@
+package sched
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"2.0.0", "2.0.0", 0},
+		{"2.0", "2.0.0", 0},
+		{"2.0.1", "2.0.0", 1},
+		{"2.10.0", "2.9.0", 1},
+		{"1.9", "2.0.0", -1},
+		{"2.0.0-rc.1", "2.0.0", -1},
+		{"2.0.0-rc.2", "2.0.0-rc.1", 1},
+		{"v2.1.0", "2.0.5", 1},
+	}
+	for _, tt := range tests {
+		c, err := CompareVersions(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("failed to compare %s and %s: %s", tt.a, tt.b, err)
+			continue
+		}
+		if c != tt.expected {
+			t.Errorf("%s vs %s: expected %d (got: %d)", tt.a, tt.b, tt.expected, c)
+		}
+	}
+	if _, err := CompareVersions("2.x", "2.0"); err != ErrInvalidVersion {
+		t.Errorf("expected an invalid version error (got: %v)", err)
+	}
+}
+
+func TestListProbes(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	store := NewPostgresStore(sqlx.NewDb(mockDB, "sqlmock"))
+
+	rows := sqlmock.NewRows([]string{"id", "token", "platform", "probe_cc",
+		"software_version"}).
+		AddRow("probe-1", "t1", "android", "IT", "2.0.0").
+		AddRow("probe-2", "t2", "android", "IT", "1.9.3").
+		AddRow("probe-3", "t3", "android", "IT", "").
+		AddRow("probe-4", "t4", "android", "IT", "2.1.0-beta")
+	mock.ExpectQuery("^SELECT (.+) FROM (.+) AND probe_cc = ANY\\(\\$1\\) AND probe_asn = ANY\\(\\$2\\) AND last_updated >= \\$3").
+		WithArgs(pq.StringArray([]string{"IT"}),
+			pq.StringArray([]string{"AS1234", "AS5678"}), sqlmock.AnyArg()).
+		WillReturnRows(rows)
+
+	probes, err := store.ListProbes(ProbeFilter{
+		Countries:  []string{"it"},
+		ASNs:       []string{"AS1234", "5678"},
+		MinVersion: "2.0",
+		LastSeen:   30 * 24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("error in calling ListProbes: %s", err)
+	}
+	if len(probes) != 2 || probes[0].ID != "probe-1" || probes[1].ID != "probe-4" {
+		t.Errorf("unexpected probes: %v", probes)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	_, err = store.ListProbes(ProbeFilter{MaxVersion: "latest"})
+	if err == nil {
+		t.Error("expected an invalid version range to be refused")
+	}
+}
@@ -2,7 +2,6 @@ package sched
 
 import (
 	"bytes"
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,10 +14,6 @@ import (
 
 	"github.com/apex/log"
 	"github.com/jmoiron/sqlx"
-	"github.com/jmoiron/sqlx/types"
-	"github.com/lib/pq"
-	"github.com/ooni/orchestra/common"
-	"github.com/satori/go.uuid"
 	"github.com/spf13/viper"
 )
 
@@ -40,6 +35,8 @@ type TaskData struct {
 	TestName  string                 `json:"test_name" binding:"required"`
 	Arguments map[string]interface{} `json:"arguments"`
 	State     string
+	// ProbeID is the probe the task is assigned to
+	ProbeID string `json:"-"`
 }
 
 // JobTarget the target of a job
@@ -73,10 +70,16 @@ type Job struct {
 
 	NextRunAt time.Time
 	TimesRun  int64
+	// Version changes every time the definition of the job is updated
+	Version int64
 
 	lock     sync.RWMutex
-	jobTimer *time.Timer
+	jobTimer Timer
+	clock    Clock
 	IsDone   bool
+	// stopped is set when the job is removed from the scheduler, for
+	// example because it was paused or deleted
+	stopped bool
 }
 
 // NewJob create a new job
@@ -90,218 +93,62 @@ func NewJob(jID string, comment string, schedule Schedule, delay int64) *Job {
 		lock:      sync.RWMutex{},
 		IsDone:    false,
 		NextRunAt: schedule.StartTime,
+		clock:     RealClock,
 	}
 }
 
-// CreateTask creates a new task and stores it in the JobDB
-func (j *Job) CreateTask(cID string, t *TaskData, jDB *JobDB) (string, error) {
-	tx, err := jDB.db.Begin()
-	if err != nil {
-		ctx.WithError(err).Error("failed to open createTask transaction")
-		return "", err
-	}
-
-	var taskID = uuid.NewV4().String()
-	{
-		query := fmt.Sprintf(`INSERT INTO %s (
-			id, probe_id,
-			job_id, test_name,
-			arguments,
-			state,
-			progress,
-			creation_time,
-			notification_time,
-			accept_time,
-			done_time,
-			last_updated
-		) VALUES (
-			$1, $2,
-			$3, $4,
-			$5,
-			$6,
-			$7,
-			$8,
-			$9,
-			$10,
-			$11,
-			$12)`,
-			pq.QuoteIdentifier(common.TasksTable))
-		stmt, err := tx.Prepare(query)
-		if err != nil {
-			ctx.WithError(err).Error("failed to prepare task create query")
-			return "", err
-		}
-		defer stmt.Close()
-
-		taskArgsStr, err := json.Marshal(t.Arguments)
-		ctx.Debugf("task args: %v", t.Arguments)
-		if err != nil {
-			ctx.WithError(err).Error("failed to serialise task arguments in createTask")
-			return "", err
-		}
-		now := time.Now().UTC()
-		_, err = stmt.Exec(taskID, cID,
-			j.ID, t.TestName,
-			taskArgsStr,
-			"ready",
-			0,
-			now,
-			nil,
-			nil,
-			nil,
-			now)
-		if err != nil {
-			tx.Rollback()
-			ctx.WithError(err).Error("failed to insert into tasks table")
-			return "", err
-		}
-		if err = tx.Commit(); err != nil {
-			ctx.WithError(err).Error("failed to commit transaction in tasks table, rolling back")
-			return "", err
-		}
+// now returns the current time of the clock of the job
+func (j *Job) now() time.Time {
+	if j.clock == nil {
+		return RealClock.Now()
 	}
+	return j.clock.Now()
+}
 
-	return taskID, nil
+// CreateTask creates a new task and stores it in the Store
+func (j *Job) CreateTask(cID string, t *TaskData, store Store) (string, error) {
+	return store.CreateTask(j.ID, cID, t, j.now())
 }
 
 // GetTargets returns all the targets for the job
-func (j *Job) GetTargets(jDB *JobDB) []*JobTarget {
+func (j *Job) GetTargets(store Store) []*JobTarget {
 	var (
-		err             error
-		query           string
-		targetCountries []string
-		targetPlatforms []string
-		targets         []*JobTarget
-
-		taskNo    sql.NullInt64
-		alertNo   sql.NullInt64
-		rows      *sql.Rows
+		targets   []*JobTarget
 		taskData  *TaskData
 		alertData *AlertData
 	)
 	ctx.Debug("getting targets")
 
-	query = fmt.Sprintf(`SELECT
-		target_countries,
-		target_platforms,
-		task_no,
-		alert_no
-		FROM %s
-		WHERE id = $1`,
-		pq.QuoteIdentifier(common.JobsTable))
-
-	err = jDB.db.QueryRow(query, j.ID).Scan(
-		pq.Array(&targetCountries),
-		pq.Array(&targetPlatforms),
-		&taskNo,
-		&alertNo)
+	spec, err := store.GetJobSpec(j.ID)
 	if err != nil {
 		ctx.WithError(err).Error("failed to obtain targets")
-		if err == sql.ErrNoRows {
+		if err == ErrJobNotFound {
 			panic("could not find job with ID")
 		}
 		panic("other error in query")
 	}
-
-	if alertNo.Valid {
-		var (
-			alertExtra types.JSONText
-		)
-		ad := AlertData{}
-		query := fmt.Sprintf(`SELECT
-			message,
-			extra
-			FROM %s
-			WHERE alert_no = $1`,
-			pq.QuoteIdentifier(common.JobAlertsTable))
-		err = jDB.db.QueryRow(query, alertNo.Int64).Scan(
-			&ad.Message,
-			&alertExtra)
-		if err != nil {
-			ctx.WithError(err).Errorf("failed to get alert_no %d", alertNo.Int64)
-			panic("failed to get alert_no")
-		}
-		err = alertExtra.Unmarshal(&ad.Extra)
-		if err != nil {
-			ctx.WithError(err).Error("failed to unmarshal json for alert")
-			panic("invalid JSON in database")
-		}
-		alertData = &ad
-	} else if taskNo.Valid {
-		var (
-			taskArgs types.JSONText
-		)
-		td := TaskData{}
-		query := fmt.Sprintf(`SELECT
-			test_name,
-			arguments
-			FROM %s
-			WHERE task_no = $1`,
-			pq.QuoteIdentifier(common.JobTasksTable))
-		err = jDB.db.QueryRow(query, taskNo.Int64).Scan(
-			&td.TestName,
-			&taskArgs)
-		if err != nil {
-			ctx.WithError(err).Errorf("failed to get task_no %d", taskNo.Int64)
-			panic("failed to get task_no")
-		}
-		err = taskArgs.Unmarshal(&td.Arguments)
-		if err != nil {
-			ctx.WithError(err).Error("failed to unmarshal json for task")
-			panic("invalid JSON in database")
-		}
-		taskData = &td
+	if spec.AlertData != nil {
+		alertData = spec.AlertData
+	} else if spec.TaskData != nil {
+		taskData = spec.TaskData
 	} else {
 		panic("inconsistent database missing task_no or alert_no")
 	}
 
-	// XXX this is really ghetto. There is probably a much better way of doing
-	// it.
-	query = fmt.Sprintf("SELECT id, token, platform FROM %s",
-		pq.QuoteIdentifier(common.ActiveProbesTable))
-	query += " WHERE is_token_expired = false AND token != ''"
-	if len(targetCountries) > 0 && len(targetPlatforms) > 0 {
-		query += " AND probe_cc = ANY($1) AND platform = ANY($2)"
-		rows, err = jDB.db.Query(query,
-			pq.Array(targetCountries),
-			pq.Array(targetPlatforms))
-	} else if len(targetCountries) > 0 || len(targetPlatforms) > 0 {
-		if len(targetCountries) > 0 {
-			query += " AND probe_cc = ANY($1)"
-			rows, err = jDB.db.Query(query, pq.Array(targetCountries))
-		} else {
-			query += " AND platform = ANY($1)"
-			rows, err = jDB.db.Query(query, pq.Array(targetPlatforms))
-		}
-	} else {
-		rows, err = jDB.db.Query(query)
-	}
-
+	probes, err := store.ListTargetProbes(spec.Countries, spec.Platforms, spec.Groups)
 	if err != nil {
-		ctx.WithError(err).Errorf("failed to find targets '%s'", query)
 		return targets
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var (
-			clientID string
-			taskID   string
-			token    string
-			plat     string
-		)
-		err = rows.Scan(&clientID, &token, &plat)
-		if err != nil {
-			ctx.WithError(err).Error("failed to iterate over targets")
-			return targets
-		}
+	for _, p := range probes {
+		var taskID string
 		if taskData != nil {
-			taskID, err = j.CreateTask(clientID, taskData, jDB)
+			taskID, err = j.CreateTask(p.ID, taskData, store)
 			if err != nil {
 				ctx.WithError(err).Error("failed to create task")
 				return targets
 			}
 		}
-		targets = append(targets, NewJobTarget(clientID, token, plat, &taskID, taskData, alertData))
+		targets = append(targets, NewJobTarget(p.ID, p.Token, p.Platform, &taskID, taskData, alertData))
 	}
 	return targets
 }
@@ -310,7 +157,7 @@ func (j *Job) GetTargets(jDB *JobDB) []*JobTarget {
 func (j *Job) GetWaitDuration() time.Duration {
 	var waitDuration time.Duration
 	ctx.Debugf("calculating wait duration. ran already %d", j.TimesRun)
-	now := time.Now().UTC()
+	now := j.now()
 	if j.IsDone {
 		panic("IsDone should be false")
 	}
@@ -329,17 +176,23 @@ func (j *Job) GetWaitDuration() time.Duration {
 }
 
 // WaitAndRun will wait on the job and then run it when it's time
-func (j *Job) WaitAndRun(jDB *JobDB) {
+func (j *Job) WaitAndRun(store Store) {
 	ctx.Debugf("running job: \"%s\"", j.Comment)
 
 	j.lock.Lock()
 	defer j.lock.Unlock()
 
+	if j.stopped {
+		return
+	}
 	waitDuration := j.GetWaitDuration()
 
 	ctx.Debugf("will wait for: \"%s\"", waitDuration)
-	jobRun := func() { j.Run(jDB) }
-	j.jobTimer = time.AfterFunc(waitDuration, jobRun)
+	jobRun := func() { j.Run(store) }
+	if j.clock == nil {
+		j.clock = RealClock
+	}
+	j.jobTimer = j.clock.AfterFunc(waitDuration, jobRun)
 }
 
 // NotifyReq is the reuqest for sending this particular notification message
@@ -487,8 +340,14 @@ func NotifyGorush(bu string, jt *JobTarget) error {
 			"payload": jt.AlertData.Extra,
 		}
 	} else if jt.TaskData != nil {
+		var (
+			notificationType = "run_task"
+		)
+		if jt.TaskData.State == "cancelled" {
+			notificationType = "cancel_task"
+		}
 		notification.Data = map[string]interface{}{
-			"type": "run_task",
+			"type": notificationType,
 			"payload": map[string]string{
 				"task_id": *jt.TaskID,
 			},
@@ -533,99 +392,25 @@ var ErrTaskNotFound = errors.New("task not found")
 var ErrAccessDenied = errors.New("access denied")
 
 // GetTask returns the specified task with the ID
-func GetTask(tID string, uID string, db *sqlx.DB) (TaskData, error) {
-	var (
-		err      error
-		probeID  string
-		taskArgs types.JSONText
-	)
-	task := TaskData{}
-	query := fmt.Sprintf(`SELECT
-		id,
-		probe_id,
-		test_name,
-		arguments,
-		COALESCE(state, 'ready')
-		FROM %s
-		WHERE id = $1`,
-		pq.QuoteIdentifier(common.TasksTable))
-	err = db.QueryRow(query, tID).Scan(
-		&task.ID,
-		&probeID,
-		&task.TestName,
-		&taskArgs,
-		&task.State)
+func GetTask(tID string, uID string, store Store) (TaskData, error) {
+	task, err := store.GetTask(tID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return task, ErrTaskNotFound
-		}
-		ctx.WithError(err).Error("failed to get task")
 		return task, err
 	}
-	if probeID != uID {
-		return task, ErrAccessDenied
-	}
-	err = taskArgs.Unmarshal(&task.Arguments)
-	if err != nil {
-		ctx.WithError(err).Error("failed to unmarshal json")
-		return task, err
+	if task.ProbeID != uID {
+		return TaskData{}, ErrAccessDenied
 	}
 	return task, nil
 }
 
-// SetTaskState sets the state of the task
-func SetTaskState(tID string, uID string,
-	state string, validStates []string,
-	updateTimeCol string,
-	db *sqlx.DB) error {
-	var err error
-	task, err := GetTask(tID, uID, db)
-	if err != nil {
-		return err
-	}
-	stateConsistent := false
-	for _, s := range validStates {
-		if task.State == s {
-			stateConsistent = true
-			break
-		}
-	}
-	if !stateConsistent {
-		return ErrInconsistentState
-	}
-
-	query := fmt.Sprintf(`UPDATE %s SET
-		state = $2,
-		%s = $3,
-		last_updated = $3
-		WHERE id = $1`,
-		pq.QuoteIdentifier(common.TasksTable),
-		updateTimeCol)
-
-	_, err = db.Exec(query, tID, state, time.Now().UTC())
-	if err != nil {
-		ctx.WithError(err).Error("failed to get task")
-		return err
-	}
-	return nil
+// ListReadyTasks lists the ready tasks of the probe created since the given
+// time
+func ListReadyTasks(uID string, since time.Time, store Store) ([]TaskData, error) {
+	return store.ListReadyTasks(uID, since)
 }
 
-// SetTokenExpired marks the token of the uID as expired
-func SetTokenExpired(db *sqlx.DB, uID string) error {
-	query := fmt.Sprintf(`UPDATE %s SET
-		is_token_expired = true
-		WHERE id = $1`,
-		pq.QuoteIdentifier(common.ActiveProbesTable))
-	_, err := db.Exec(query, uID)
-	if err != nil {
-		ctx.WithError(err).Error("failed to set token as expired")
-		return err
-	}
-	return nil
-}
-
-// Notify send a notification for the given JobTarget
-func Notify(jt *JobTarget, jDB *JobDB) error {
+// push sends the push notification for the given JobTarget
+func push(jt *JobTarget, store Store) error {
 	var err error
 	if jt.Platform != "android" && jt.Platform != "ios" {
 		ctx.Debugf("we don't support notifying to %s", jt.Platform)
@@ -643,20 +428,28 @@ func Notify(jt *JobTarget, jDB *JobDB) error {
 	}
 
 	if err == ErrExpiredToken {
-		err = SetTokenExpired(jDB.db, jt.ClientID)
-		if err != nil {
-			return err
-		}
-	} else if err != nil {
+		return store.SetTokenExpired(jt.ClientID)
+	}
+	return err
+}
+
+// Notify send a notification for the given JobTarget
+func Notify(jt *JobTarget, now time.Time, store Store) error {
+	if jt.Platform != "android" && jt.Platform != "ios" {
+		ctx.Debugf("we don't support notifying to %s", jt.Platform)
+		return nil
+	}
+	err := push(jt, store)
+	if err != nil {
 		return err
 	}
 	if jt.TaskData != nil {
-		err = SetTaskState(jt.TaskData.ID,
+		err = SetTaskState(*jt.TaskID,
 			jt.ClientID,
 			"notified",
-			[]string{"ready"},
 			"notification_time",
-			jDB.db)
+			now,
+			store)
 		if err != nil {
 			ctx.WithError(err).Error("failed to update task state")
 			return err
@@ -666,23 +459,37 @@ func Notify(jt *JobTarget, jDB *JobDB) error {
 }
 
 // Run the given job
-func (j *Job) Run(jDB *JobDB) {
+func (j *Job) Run(store Store) {
 	j.lock.Lock()
 	defer j.lock.Unlock()
 
+	if j.stopped {
+		ctx.Debugf("job %s was stopped", j.ID)
+		return
+	}
+
 	if !j.ShouldRun() {
+		if j.IsDone {
+			// The schedule ended while we were waiting to run
+			ctx.Debugf("job %s reached its end time", j.ID)
+			err := j.Save(store)
+			if err != nil {
+				ctx.Error("failed to save job state to DB")
+			}
+			return
+		}
 		ctx.Error("inconsitency in should run detected..")
 		return
 	}
 
-	targets := j.GetTargets(jDB)
-	lastRunAt := time.Now().UTC()
+	targets := j.GetTargets(store)
+	lastRunAt := j.now()
 	for _, t := range targets {
 		// XXX
 		// In here shall go logic to connect to notification server and notify
 		// them of the task
 		ctx.Debugf("notifying %s", t.ClientID)
-		err := Notify(t, jDB)
+		err := Notify(t, lastRunAt, store)
 		if err != nil {
 			ctx.WithError(err).Errorf("failed to notify %s",
 				t.ClientID)
@@ -690,60 +497,48 @@ func (j *Job) Run(jDB *JobDB) {
 	}
 
 	ctx.Debugf("successfully ran at %s", lastRunAt)
-	// XXX maybe move these elsewhere
+	j.advance(lastRunAt)
+	err := j.Save(store)
+	if err != nil {
+		ctx.Error("failed to save job state to DB")
+	}
+	if j.ShouldWait() {
+		go j.WaitAndRun(store)
+	}
+}
+
+// advance updates the bookkeeping of the job after it ran at lastRunAt,
+// either marking it as done or computing when it should run next
+func (j *Job) advance(lastRunAt time.Time) {
 	j.TimesRun = j.TimesRun + 1
 	if j.Schedule.Repeat != -1 && j.TimesRun >= j.Schedule.Repeat {
 		j.IsDone = true
 	} else {
-		d := j.Schedule.Duration.ToDuration()
+		d := j.Schedule.Duration.ToDurationFrom(lastRunAt)
 		ctx.Debugf("adding %s", d)
 		j.NextRunAt = lastRunAt.Add(d)
+		if j.Schedule.HasEnded(j.NextRunAt) {
+			j.IsDone = true
+		}
 	}
 	ctx.Debugf("next run will be at %s", j.NextRunAt)
 	ctx.Debugf("times run %d", j.TimesRun)
-	err := j.Save(jDB)
-	if err != nil {
-		ctx.Error("failed to save job state to DB")
-	}
-	if j.ShouldWait() {
-		go j.WaitAndRun(jDB)
-	}
 }
 
-// Save the job to the job database
-func (j *Job) Save(jDB *JobDB) error {
-	tx, err := jDB.db.Begin()
-	if err != nil {
-		ctx.WithError(err).Error("failed to open transaction")
-		return err
-	}
-	query := fmt.Sprintf(`UPDATE %s SET
-		times_run = $2,
-		next_run_at = $3,
-		is_done = $4
-		WHERE id = $1`,
-		pq.QuoteIdentifier(common.JobsTable))
-
-	stmt, err := tx.Prepare(query)
-	if err != nil {
-		ctx.WithError(err).Error("failed to prepare update jobs query")
-		return err
-	}
-	_, err = stmt.Exec(j.ID,
-		j.TimesRun,
-		j.NextRunAt.UTC(),
-		j.IsDone)
+// Save the job to the Store
+func (j *Job) Save(store Store) error {
+	return store.SaveJob(j)
+}
 
-	if err != nil {
-		tx.Rollback()
-		ctx.WithError(err).Error("failed to jobs table, rolling back")
-		return errors.New("failed to update jobs table")
-	}
-	if err = tx.Commit(); err != nil {
-		ctx.WithError(err).Error("failed to commit transaction, rolling back")
-		return err
+// Stop prevents any further run of the job. Unlike IsDone it is not saved
+// to the store, so that the job can be resumed later.
+func (j *Job) Stop() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.stopped = true
+	if j.jobTimer != nil {
+		j.jobTimer.Stop()
 	}
-	return nil
 }
 
 // ShouldWait returns true if the job is not done
@@ -757,11 +552,16 @@ func (j *Job) ShouldWait() bool {
 // ShouldRun checks if we should run this job
 func (j *Job) ShouldRun() bool {
 	ctx.Debugf("should run? ran already %d", j.TimesRun)
-	now := time.Now().UTC()
+	now := j.now()
 	if j.IsDone {
 		ctx.Debug("isDone => false")
 		return false
 	}
+	if j.Schedule.HasEnded(now) {
+		ctx.Debug("ended => false")
+		j.IsDone = true
+		return false
+	}
 	if now.Before(j.Schedule.StartTime) {
 		ctx.Debug("before => false")
 		return false
@@ -779,109 +579,194 @@ func (j *Job) ShouldRun() bool {
 	return false
 }
 
-// JobDB keep track of the Job database
-type JobDB struct {
-	db *sqlx.DB
-}
-
-// GetAll returns a list of all jobs in the database
-func (db *JobDB) GetAll() ([]*Job, error) {
-	allJobs := []*Job{}
-	query := fmt.Sprintf(`SELECT
-		id, comment,
-		schedule, delay,
-		times_run,
-		next_run_at,
-		is_done
-		FROM %s
-		WHERE state = 'active'`,
-		pq.QuoteIdentifier(common.JobsTable))
-	rows, err := db.db.Query(query)
-	if err != nil {
-		ctx.WithError(err).Error("failed to list jobs")
-		return allJobs, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var (
-			j            Job
-			schedule     string
-			nextRunAtStr string
-		)
-		err := rows.Scan(&j.ID,
-			&j.Comment,
-			&schedule,
-			&j.Delay,
-			&j.TimesRun,
-			&nextRunAtStr,
-			&j.IsDone)
-		if err != nil {
-			ctx.WithError(err).Error("failed to iterate over jobs")
-			return allJobs, err
-		}
-		j.NextRunAt, err = time.Parse(ISOUTCTimeLayout, nextRunAtStr)
-		if err != nil {
-			ctx.WithError(err).Error("invalid time string")
-			return allJobs, err
-		}
-		j.Schedule, err = ParseSchedule(schedule)
-		if err != nil {
-			ctx.WithError(err).Error("invalid schedule")
-			return allJobs, err
-		}
-		j.lock = sync.RWMutex{}
-		allJobs = append(allJobs, &j)
-	}
-	return allJobs, nil
-}
-
 // Scheduler is the datastructure for the scheduler
 type Scheduler struct {
-	jobDB       JobDB
+	store       Store
+	lock        sync.Mutex
 	runningJobs map[string]*Job
-	stopped     chan os.Signal
+	clock       Clock
+	// stopped is closed by Stop to end the periodic refreshes
+	stopped chan struct{}
+	timers  map[string]Timer
 }
 
 // NewScheduler creates a new instance of the scheduler
 func NewScheduler(db *sqlx.DB) *Scheduler {
+	return NewSchedulerWithClock(db, RealClock)
+}
+
+// NewSchedulerWithClock creates a new instance of the scheduler that uses
+// the given clock to time jobs
+func NewSchedulerWithClock(db *sqlx.DB, clock Clock) *Scheduler {
+	return NewSchedulerWithStore(NewPostgresStore(db), clock)
+}
+
+// NewSchedulerWithStore creates a new instance of the scheduler that keeps
+// its jobs and tasks in the given store
+func NewSchedulerWithStore(store Store, clock Clock) *Scheduler {
 	return &Scheduler{
-		stopped:     make(chan os.Signal),
+		stopped:     make(chan struct{}),
+		timers:      make(map[string]Timer),
 		runningJobs: make(map[string]*Job),
-		jobDB:       JobDB{db: db}}
+		store:       store,
+		clock:       clock}
+}
+
+// Store returns the store of the scheduler
+func (s *Scheduler) Store() Store {
+	return s.store
 }
 
-// DeleteJob will remove the job by removing it from the running jobs
+// Now returns the current time on the clock of the scheduler. It falls back
+// to the wall clock when there is no scheduler, as in the CLI.
+func (s *Scheduler) Now() time.Time {
+	if s == nil || s.clock == nil {
+		return time.Now().UTC()
+	}
+	return s.clock.Now().UTC()
+}
+
+// DeleteJob will stop the job and remove it from the running jobs
 func (s *Scheduler) DeleteJob(jobID string) error {
+	s.lock.Lock()
 	job, ok := s.runningJobs[jobID]
+	delete(s.runningJobs, jobID)
+	s.lock.Unlock()
 	if !ok {
 		return errors.New("Job is not part of the running jobs")
 	}
-	job.IsDone = true
-	delete(s.runningJobs, jobID)
+	job.Stop()
 	return nil
 }
 
-// RunJob checks if we should wait on the job and if not will run it
+// RunJob adds the job to the running jobs and, unless it is done, waits to
+// run it. Jobs that are already running are ignored.
 func (s *Scheduler) RunJob(j *Job) {
+	s.lock.Lock()
+	if _, ok := s.runningJobs[j.ID]; ok {
+		s.lock.Unlock()
+		return
+	}
+	s.runningJobs[j.ID] = j
+	s.lock.Unlock()
+
+	j.clock = s.clock
 	if j.ShouldWait() {
-		j.WaitAndRun(&s.jobDB)
+		j.WaitAndRun(s.store)
+	}
+}
+
+// RefreshJobs brings the running jobs in line with the active jobs of the
+// store. This picks up the jobs that were added, updated, paused, resumed or
+// deleted without going through this scheduler, for example from the
+// command line. Updated jobs are stopped and run again with their new
+// definition.
+func (s *Scheduler) RefreshJobs() error {
+	activeJobs, err := s.store.ListActiveJobs()
+	if err != nil {
+		ctx.WithError(err).Error("failed to list all jobs")
+		return err
+	}
+	active := make(map[string]*Job)
+	for _, j := range activeJobs {
+		active[j.ID] = j
+	}
+
+	var stale []string
+	s.lock.Lock()
+	for jobID, running := range s.runningJobs {
+		j, ok := active[jobID]
+		if !ok || j.Version != running.Version {
+			stale = append(stale, jobID)
+		}
+	}
+	s.lock.Unlock()
+	for _, jobID := range stale {
+		ctx.Infof("stopping job %s", jobID)
+		s.DeleteJob(jobID)
+	}
+	for _, j := range activeJobs {
+		s.RunJob(j)
+	}
+	return nil
+}
+
+// DefaultJobRefreshInterval is how often the jobs are reloaded when
+// core.job-refresh-interval is not set
+const DefaultJobRefreshInterval = time.Minute
+
+// JobRefreshInterval returns how often the scheduler reloads the jobs from
+// the store
+func JobRefreshInterval() time.Duration {
+	if viper.IsSet("core.job-refresh-interval") {
+		return viper.GetDuration("core.job-refresh-interval")
 	}
+	return DefaultJobRefreshInterval
 }
 
 // Start the scheduler
 func (s *Scheduler) Start() {
 	ctx.Debug("starting scheduler")
-	// XXX currently when jobs are deleted the allJobs list will not be
-	// updated. We should find a way to check this and stop triggering a job in
-	// case it gets deleted.
-	allJobs, err := s.jobDB.GetAll()
+	err := s.RefreshJobs()
 	if err != nil {
-		ctx.WithError(err).Error("failed to list all jobs")
 		return
 	}
-	for _, j := range allJobs {
-		s.runningJobs[j.ID] = j
-		s.RunJob(j)
+	s.every("refresh-jobs", JobRefreshInterval(), func() {
+		s.RefreshJobs()
+	})
+	s.every("expire-task-leases", leaseExpiryInterval, s.expireTaskLeases)
+}
+
+// Stop ends the periodic refreshes started by Start. The running jobs are
+// left untouched.
+func (s *Scheduler) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	select {
+	case <-s.stopped:
+		return
+	default:
+	}
+	close(s.stopped)
+	for name, t := range s.timers {
+		t.Stop()
+		delete(s.timers, name)
+	}
+}
+
+// every calls f every d on the clock of the scheduler until it is stopped
+func (s *Scheduler) every(name string, d time.Duration, f func()) {
+	var tick func()
+	schedule := func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		select {
+		case <-s.stopped:
+			return
+		default:
+		}
+		s.timers[name] = s.clock.AfterFunc(d, tick)
+	}
+	tick = func() {
+		select {
+		case <-s.stopped:
+			return
+		default:
+		}
+		f()
+		schedule()
+	}
+	schedule()
+}
+
+// expireTaskLeases returns the tasks whose lease has expired to the queue
+func (s *Scheduler) expireTaskLeases() {
+	n, err := s.store.ExpireTaskLeases(s.Now())
+	if err != nil {
+		return
+	}
+	if n > 0 {
+		ctx.Infof("returned %d tasks with expired leases to the queue", n)
 	}
 }
 
@@ -0,0 +1,171 @@
+package sched
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShouldRunAfterEndTime(t *testing.T) {
+	now := time.Now().UTC()
+	j := NewJob("job-1", "comment", Schedule{
+		Repeat:    -1,
+		StartTime: now.Add(-2 * time.Hour),
+		EndTime:   now.Add(-time.Hour),
+		Duration:  ScheduleDuration{Minutes: 10},
+	}, 0)
+	j.NextRunAt = now.Add(-time.Minute)
+	if j.ShouldRun() {
+		t.Error("job should not run after its end time")
+	}
+	if !j.IsDone {
+		t.Error("job should be marked as done after its end time")
+	}
+}
+
+func TestRefreshUpdatedJob(t *testing.T) {
+	store := NewMemoryStore()
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := store.CreateJob(JobSpec{
+		ID:        "job-1",
+		Schedule:  "R/2018-01-01T01:00:00Z/P1D",
+		AlertData: &AlertData{Message: "hello"},
+		NextRunAt: start.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("failed to create job: %s", err)
+	}
+	clock := NewFakeClock(start)
+	s := NewSchedulerWithStore(store, clock)
+	if err = s.RefreshJobs(); err != nil {
+		t.Fatalf("failed to refresh jobs: %s", err)
+	}
+
+	// The job is updated without going through the scheduler, as the
+	// jobs sync command does
+	err = store.UpdateJob(JobSpec{
+		ID:        "job-1",
+		Schedule:  "R/2018-01-01T00:30:00Z/PT1H",
+		AlertData: &AlertData{Message: "hello"},
+		NextRunAt: start.Add(30 * time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("failed to update job: %s", err)
+	}
+	if err = s.RefreshJobs(); err != nil {
+		t.Fatalf("failed to refresh jobs: %s", err)
+	}
+	j := s.runningJobs["job-1"]
+	if j == nil {
+		t.Fatal("expected job-1 to be running")
+	}
+	if j.Schedule.Duration.Hours != 1 || !j.NextRunAt.Equal(start.Add(30*time.Minute)) {
+		t.Errorf("the running job kept its old schedule: %v %s",
+			j.Schedule, j.NextRunAt)
+	}
+
+	clock.Advance(30 * time.Minute)
+	jobs, err := store.ListActiveJobs()
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("failed to list jobs: %v %s", jobs, err)
+	}
+	if jobs[0].TimesRun != 1 || !jobs[0].NextRunAt.Equal(start.Add(90*time.Minute)) {
+		t.Errorf("the job did not run on its new schedule: %d %s",
+			jobs[0].TimesRun, jobs[0].NextRunAt)
+	}
+}
+
+func TestRefreshJobs(t *testing.T) {
+	store := NewMemoryStore()
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := store.CreateJob(JobSpec{
+		ID:        "job-1",
+		Schedule:  "R/2018-01-01T01:00:00Z/P1D",
+		AlertData: &AlertData{Message: "hello"},
+		NextRunAt: start.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("failed to create job: %s", err)
+	}
+	clock := NewFakeClock(start)
+	s := NewSchedulerWithStore(store, clock)
+	timesRun := func() int64 {
+		jobs, err := store.ListActiveJobs()
+		if err != nil || len(jobs) != 1 {
+			t.Fatalf("failed to list jobs: %v %s", jobs, err)
+		}
+		return jobs[0].TimesRun
+	}
+
+	if err = s.RefreshJobs(); err != nil {
+		t.Fatalf("failed to refresh jobs: %s", err)
+	}
+	if _, ok := s.runningJobs["job-1"]; !ok {
+		t.Fatal("expected job-1 to be running")
+	}
+
+	store.SetJobState("job-1", "paused")
+	if err = s.RefreshJobs(); err != nil {
+		t.Fatalf("failed to refresh jobs: %s", err)
+	}
+	if _, ok := s.runningJobs["job-1"]; ok {
+		t.Fatal("expected job-1 to be stopped")
+	}
+	clock.Advance(2 * time.Hour)
+
+	store.SetJobState("job-1", "active")
+	if n := timesRun(); n != 0 {
+		t.Errorf("paused job should not run (ran %d times)", n)
+	}
+	if err = s.RefreshJobs(); err != nil {
+		t.Fatalf("failed to refresh jobs: %s", err)
+	}
+	clock.Advance(time.Second)
+	if n := timesRun(); n != 1 {
+		t.Errorf("resumed job should run once (ran %d times)", n)
+	}
+}
+
+func TestSchedulerStop(t *testing.T) {
+	store := NewMemoryStore()
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	s := NewSchedulerWithStore(store, clock)
+	createJob := func(jobID string) {
+		err := store.CreateJob(JobSpec{
+			ID:        jobID,
+			Schedule:  "R/2018-02-01T00:00:00Z/P1D",
+			AlertData: &AlertData{Message: "hello"},
+			NextRunAt: start.Add(31 * 24 * time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("failed to create job: %s", err)
+		}
+	}
+	isRunning := func(jobID string) bool {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		_, ok := s.runningJobs[jobID]
+		return ok
+	}
+
+	s.Start()
+	createJob("job-1")
+	clock.Advance(DefaultJobRefreshInterval)
+	if !isRunning("job-1") {
+		t.Fatal("expected job-1 to be picked up by the periodic refresh")
+	}
+
+	s.Stop()
+	createJob("job-2")
+	clock.Advance(DefaultJobRefreshInterval)
+	if isRunning("job-2") {
+		t.Error("expected no refresh after Stop")
+	}
+	clock.lock.Lock()
+	pending := len(clock.timers)
+	clock.lock.Unlock()
+	// Only the timer of job-1 is left
+	if pending != 1 {
+		t.Errorf("expected the timer of job-1 only, got %d", pending)
+	}
+}
@@ -0,0 +1,61 @@
+package sched
+
+import (
+	"time"
+)
+
+// SimulatedRun is a run of a job that happened during a simulation
+type SimulatedRun struct {
+	JobID    string
+	Comment  string
+	Time     time.Time
+	TimesRun int64
+}
+
+// Simulate replays the jobs on a fake clock going from start to start+d and
+// returns the runs in the order they happened. Jobs are only timed: no
+// tasks are created, nobody is notified and nothing is written to the
+// database.
+func Simulate(jobs []*Job, start time.Time, d time.Duration) []SimulatedRun {
+	var (
+		runs     []SimulatedRun
+		schedule func(j *Job)
+	)
+	clock := NewFakeClock(start)
+
+	schedule = func(j *Job) {
+		if !j.ShouldWait() {
+			return
+		}
+		j.jobTimer = clock.AfterFunc(j.GetWaitDuration(), func() {
+			if !j.ShouldRun() {
+				if !j.IsDone {
+					ctx.Errorf("inconsistency in should run detected for %s", j.ID)
+				}
+				return
+			}
+			now := clock.Now()
+			j.advance(now)
+			runs = append(runs, SimulatedRun{
+				JobID:    j.ID,
+				Comment:  j.Comment,
+				Time:     now,
+				TimesRun: j.TimesRun,
+			})
+			if !j.IsDone && !j.NextRunAt.After(now) {
+				// A zero duration would make the job run forever at the
+				// same instant
+				ctx.Errorf("job %s does not advance, stopping it", j.ID)
+				return
+			}
+			schedule(j)
+		})
+	}
+
+	for _, j := range jobs {
+		j.clock = clock
+		schedule(j)
+	}
+	clock.Advance(d)
+	return runs
+}
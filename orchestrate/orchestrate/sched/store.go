@@ -0,0 +1,157 @@
+package sched
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ooni/orchestra/common/middleware"
+)
+
+// ErrJobNotFound did not found the job in the store
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrProbeGroupNotFound did not find the probe group in the store
+var ErrProbeGroupNotFound = errors.New("probe group not found")
+
+// JobStates are all the states a job can be in
+var JobStates = []string{"active", "paused", "deleted", "done"}
+
+// JobSpec is what is stored about a job. Name is optional and unique among
+// the jobs that are not deleted. Owner is the username of the account that
+// created the job, empty for the jobs created from the command line.
+type JobSpec struct {
+	ID           string
+	Name         string
+	CampaignID   string
+	Owner        string
+	Comment      string
+	Schedule     string
+	Delay        int64
+	Countries    []string
+	Platforms    []string
+	Groups       []string
+	TaskData     *TaskData
+	AlertData    *AlertData
+	State        string
+	EndTime      *time.Time
+	CreationTime time.Time
+	NextRunAt    time.Time
+}
+
+// updatedRun returns when a job runs next after its definition changed to
+// spec and whether it is done, given how many times it already ran and
+// when it would have run next. A job that already ran keeps its place in
+// the schedule, so that the update does not repeat the runs it made, and
+// the new start time can only move its next run later. A job that never
+// ran follows the new start time, even when it is earlier.
+func updatedRun(spec JobSpec, timesRun int64, nextRunAt time.Time) (time.Time, bool, error) {
+	schedule, err := ParseSchedule(spec.Schedule)
+	if err != nil {
+		return nextRunAt, false, err
+	}
+	if spec.EndTime != nil {
+		schedule.EndTime = spec.EndTime.UTC()
+	}
+	next := spec.NextRunAt
+	if timesRun > 0 && nextRunAt.After(next) {
+		next = nextRunAt
+	}
+	isDone := schedule.HasEnded(next) ||
+		(schedule.Repeat != -1 && timesRun >= schedule.Repeat)
+	return next, isDone, nil
+}
+
+// Probe is a probe that can be the target of a job
+type Probe struct {
+	ID           string
+	Token        string
+	Platform     string
+	ProbeCC      string
+	TokenExpired bool
+	// SoftwareVersion is only set by PostgresStore
+	SoftwareVersion string
+}
+
+// CancelledTask is a task cancelled by CancelJobTasks and the probe it was
+// assigned to
+type CancelledTask struct {
+	TaskID string
+	Probe  Probe
+}
+
+// TaskStateUpdate describes a conditional change of the state of a task
+type TaskStateUpdate struct {
+	State string
+	// From are the states the task must be in for the update to apply
+	From []string
+	// TimeColumn is the timestamp set to Time along with the state, one of
+	// notification_time, accept_time or done_time
+	TimeColumn  string
+	Time        time.Time
+	LeaseExpiry *time.Time
+}
+
+// Store is where the scheduler keeps jobs, tasks, probes and alerts.
+// PostgresStore is used in production and MemoryStore in tests.
+type Store interface {
+	// CreateJob stores a new active job
+	CreateJob(spec JobSpec) error
+	// UpdateJob changes the definition of the job with spec.ID and bumps
+	// its version. Its name, owner, state and run count are kept, and its
+	// next run and whether it is done follow updatedRun.
+	UpdateJob(spec JobSpec) error
+	// GetJobSpec returns the job, including its task or alert
+	GetJobSpec(jobID string) (JobSpec, error)
+	// SetJobState changes the state of the job, for example to deleted
+	SetJobState(jobID string, state string) error
+	// ListNamedJobs returns the jobs that have a name and are not deleted
+	ListNamedJobs() ([]JobSpec, error)
+	// ListActiveJobs returns the jobs the scheduler should be running
+	ListActiveJobs() ([]*Job, error)
+	// SaveJob stores the run bookkeeping of the job. Nothing is stored
+	// when the job was updated since it was loaded.
+	SaveJob(j *Job) error
+	// RecordAudit appends the entry to the audit log
+	RecordAudit(e middleware.AuditEntry) error
+	// CampaignExists returns true if there is a campaign with the given ID
+	CampaignExists(campaignID string) (bool, error)
+
+	// ListTargetProbes returns the probes with a valid push token in one of
+	// the countries, on one of the platforms and in one of the probe groups.
+	// Empty lists match every probe.
+	ListTargetProbes(countries []string, platforms []string, groups []string) ([]Probe, error)
+	// ResolveProbeGroups returns the IDs of the probes in at least one of the
+	// groups, or ErrProbeGroupNotFound if one of them does not exist
+	ResolveProbeGroups(groups []string) (map[string]bool, error)
+	// SetTokenExpired marks the push token of the probe as expired
+	SetTokenExpired(probeID string) error
+
+	// CreateTask stores a new ready task and returns its ID
+	CreateTask(jobID string, probeID string, td *TaskData, now time.Time) (string, error)
+	// GetTask returns the task with ProbeID set, or ErrTaskNotFound
+	GetTask(taskID string) (TaskData, error)
+	// ListReadyTasks returns the ready tasks of the probe created since the
+	// given time
+	ListReadyTasks(probeID string, since time.Time) ([]TaskData, error)
+	// UpdateTaskState applies the update if the task belongs to the probe
+	// and is in one of the update.From states. It returns false if no task
+	// was updated.
+	UpdateTaskState(taskID string, probeID string, update TaskStateUpdate) (bool, error)
+	// LeaseTask atomically moves the oldest ready task of the probe to the
+	// accepted state, or returns ErrNoTaskAvailable
+	LeaseTask(probeID string, now time.Time, leaseExpiry time.Time) (TaskData, error)
+	// ExtendTaskLease moves the lease expiry of a task accepted by the
+	// probe. It returns false if no task was updated.
+	ExtendTaskLease(taskID string, probeID string, now time.Time, leaseExpiry time.Time) (bool, error)
+	// ExpireTaskLeases puts the accepted tasks whose lease expired before
+	// now back in the ready state
+	ExpireTaskLeases(now time.Time) (int64, error)
+	// CancelJobTasks cancels all the tasks of the job that are in one of the
+	// from states
+	CancelJobTasks(jobID string, from []string, now time.Time) ([]CancelledTask, error)
+}
+
+var (
+	_ Store = &PostgresStore{}
+	_ Store = &MemoryStore{}
+)
@@ -0,0 +1,475 @@
+package sched
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ooni/orchestra/common/middleware"
+	"github.com/satori/go.uuid"
+)
+
+type memoryJob struct {
+	spec     JobSpec
+	timesRun int64
+	isDone   bool
+	version  int64
+}
+
+type memoryTask struct {
+	data             TaskData
+	jobID            string
+	creationTime     time.Time
+	notificationTime *time.Time
+	acceptTime       *time.Time
+	doneTime         *time.Time
+	leaseExpiry      *time.Time
+	lastUpdated      time.Time
+}
+
+// MemoryStore is a Store that keeps everything in memory. It is meant for
+// tests and simulations.
+type MemoryStore struct {
+	lock      sync.Mutex
+	jobs      map[string]*memoryJob
+	tasks     map[string]*memoryTask
+	probes    map[string]*Probe
+	groups    map[string][]string
+	campaigns map[string]bool
+	audit     []middleware.AuditEntry
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		jobs:      make(map[string]*memoryJob),
+		tasks:     make(map[string]*memoryTask),
+		probes:    make(map[string]*Probe),
+		groups:    make(map[string][]string),
+		campaigns: make(map[string]bool),
+	}
+}
+
+// AddCampaign adds a campaign that jobs can be part of
+func (s *MemoryStore) AddCampaign(campaignID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.campaigns[campaignID] = true
+}
+
+// CampaignExists returns true if the campaign was added
+func (s *MemoryStore) CampaignExists(campaignID string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.campaigns[campaignID], nil
+}
+
+// SetProbeGroup adds or replaces a probe group with the given members.
+// Saved filters are not supported by MemoryStore.
+func (s *MemoryStore) SetProbeGroup(name string, probeIDs []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.groups[name] = probeIDs
+}
+
+// AddProbe adds or replaces a probe
+func (s *MemoryStore) AddProbe(p Probe) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.probes[p.ID] = &p
+}
+
+// GetProbe returns a copy of the probe with the given ID
+func (s *MemoryStore) GetProbe(probeID string) (Probe, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	p, ok := s.probes[probeID]
+	if !ok {
+		return Probe{}, false
+	}
+	return *p, true
+}
+
+func stringInSlice(s string, slice []string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateJob stores a new active job
+func (s *MemoryStore) CreateJob(spec JobSpec) error {
+	if spec.TaskData == nil && spec.AlertData == nil {
+		return errors.New("task or alert must be defined")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	spec.State = "active"
+	s.jobs[spec.ID] = &memoryJob{spec: spec}
+	return nil
+}
+
+// UpdateJob changes the definition of the job in place
+func (s *MemoryStore) UpdateJob(spec JobSpec) error {
+	if spec.TaskData == nil && spec.AlertData == nil {
+		return errors.New("task or alert must be defined")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	mj, ok := s.jobs[spec.ID]
+	if !ok || mj.spec.State == "deleted" {
+		return ErrJobNotFound
+	}
+	spec.Name = mj.spec.Name
+	spec.Owner = mj.spec.Owner
+	spec.State = mj.spec.State
+	spec.CreationTime = mj.spec.CreationTime
+	nextRunAt, isDone, err := updatedRun(spec, mj.timesRun, mj.spec.NextRunAt)
+	if err != nil {
+		return err
+	}
+	spec.NextRunAt = nextRunAt
+	mj.spec = spec
+	mj.isDone = isDone
+	mj.version++
+	return nil
+}
+
+// GetJobSpec returns the job, including its task or alert
+func (s *MemoryStore) GetJobSpec(jobID string) (JobSpec, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	j, ok := s.jobs[jobID]
+	if !ok {
+		return JobSpec{}, ErrJobNotFound
+	}
+	return j.spec, nil
+}
+
+// SetJobState changes the state of the job
+func (s *MemoryStore) SetJobState(jobID string, state string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	j, ok := s.jobs[jobID]
+	if !ok {
+		return ErrJobNotFound
+	}
+	j.spec.State = state
+	return nil
+}
+
+// ListNamedJobs returns the jobs that have a name and are not deleted
+func (s *MemoryStore) ListNamedJobs() ([]JobSpec, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var specs []JobSpec
+	for _, mj := range s.jobs {
+		if mj.spec.Name == "" || mj.spec.State == "deleted" {
+			continue
+		}
+		specs = append(specs, mj.spec)
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Name < specs[j].Name
+	})
+	return specs, nil
+}
+
+// ListActiveJobs returns the jobs the scheduler should be running
+func (s *MemoryStore) ListActiveJobs() ([]*Job, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	allJobs := []*Job{}
+	for _, mj := range s.jobs {
+		if mj.spec.State != "active" {
+			continue
+		}
+		schedule, err := ParseSchedule(mj.spec.Schedule)
+		if err != nil {
+			return allJobs, err
+		}
+		if mj.spec.EndTime != nil {
+			schedule.EndTime = mj.spec.EndTime.UTC()
+		}
+		j := NewJob(mj.spec.ID, mj.spec.Comment, schedule, mj.spec.Delay)
+		j.TimesRun = mj.timesRun
+		j.NextRunAt = mj.spec.NextRunAt
+		j.IsDone = mj.isDone
+		j.Version = mj.version
+		allJobs = append(allJobs, j)
+	}
+	sort.Slice(allJobs, func(i, j int) bool {
+		return allJobs[i].ID < allJobs[j].ID
+	})
+	return allJobs, nil
+}
+
+// SaveJob stores the run bookkeeping of the job
+func (s *MemoryStore) SaveJob(j *Job) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	mj, ok := s.jobs[j.ID]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if mj.version != j.Version {
+		// The job was updated since it was loaded
+		return nil
+	}
+	mj.timesRun = j.TimesRun
+	mj.spec.NextRunAt = j.NextRunAt.UTC()
+	mj.isDone = j.IsDone
+	return nil
+}
+
+// RecordAudit appends the entry to the audit log
+func (s *MemoryStore) RecordAudit(e middleware.AuditEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	e.ID = int64(len(s.audit) + 1)
+	s.audit = append(s.audit, e)
+	return nil
+}
+
+// AuditEntries returns the audit log, the oldest entry first
+func (s *MemoryStore) AuditEntries() []middleware.AuditEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]middleware.AuditEntry{}, s.audit...)
+}
+
+// ResolveProbeGroups returns the IDs of the probes in the groups
+func (s *MemoryStore) ResolveProbeGroups(groups []string) (map[string]bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.resolveProbeGroups(groups)
+}
+
+func (s *MemoryStore) resolveProbeGroups(groups []string) (map[string]bool, error) {
+	members := make(map[string]bool)
+	for _, name := range groups {
+		probeIDs, ok := s.groups[name]
+		if !ok {
+			return members, ErrProbeGroupNotFound
+		}
+		for _, id := range probeIDs {
+			members[id] = true
+		}
+	}
+	return members, nil
+}
+
+// ListTargetProbes returns the probes with a valid push token matching the
+// countries, platforms and groups
+func (s *MemoryStore) ListTargetProbes(countries []string, platforms []string, groups []string) ([]Probe, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var probes []Probe
+	members, err := s.resolveProbeGroups(groups)
+	if err != nil {
+		return probes, err
+	}
+	for _, p := range s.probes {
+		if len(groups) > 0 && !members[p.ID] {
+			continue
+		}
+		if p.TokenExpired || p.Token == "" {
+			continue
+		}
+		if len(countries) > 0 && !stringInSlice(p.ProbeCC, countries) {
+			continue
+		}
+		if len(platforms) > 0 && !stringInSlice(p.Platform, platforms) {
+			continue
+		}
+		probes = append(probes, *p)
+	}
+	sort.Slice(probes, func(i, j int) bool {
+		return probes[i].ID < probes[j].ID
+	})
+	return probes, nil
+}
+
+// SetTokenExpired marks the token of the probe as expired
+func (s *MemoryStore) SetTokenExpired(probeID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if p, ok := s.probes[probeID]; ok {
+		p.TokenExpired = true
+	}
+	return nil
+}
+
+// CreateTask stores a new ready task and returns its ID
+func (s *MemoryStore) CreateTask(jobID string, probeID string, td *TaskData, now time.Time) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	taskID := uuid.NewV4().String()
+	s.tasks[taskID] = &memoryTask{
+		data: TaskData{
+			ID:        taskID,
+			ProbeID:   probeID,
+			TestName:  td.TestName,
+			Arguments: td.Arguments,
+			State:     "ready",
+		},
+		jobID:        jobID,
+		creationTime: now,
+		lastUpdated:  now,
+	}
+	return taskID, nil
+}
+
+// GetTask returns the task with the given ID
+func (s *MemoryStore) GetTask(taskID string) (TaskData, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	t, ok := s.tasks[taskID]
+	if !ok {
+		return TaskData{}, ErrTaskNotFound
+	}
+	return t.data, nil
+}
+
+// sortedTasks returns the tasks matching the filter ordered by creation time
+func (s *MemoryStore) sortedTasks(filter func(*memoryTask) bool) []*memoryTask {
+	var tasks []*memoryTask
+	for _, t := range s.tasks {
+		if filter(t) {
+			tasks = append(tasks, t)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].creationTime.Equal(tasks[j].creationTime) {
+			return tasks[i].data.ID < tasks[j].data.ID
+		}
+		return tasks[i].creationTime.Before(tasks[j].creationTime)
+	})
+	return tasks
+}
+
+// ListReadyTasks returns the ready tasks of the probe created since the
+// given time
+func (s *MemoryStore) ListReadyTasks(probeID string, since time.Time) ([]TaskData, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var tasks []TaskData
+	for _, t := range s.sortedTasks(func(t *memoryTask) bool {
+		return t.data.ProbeID == probeID && t.data.State == "ready" &&
+			!t.creationTime.Before(since)
+	}) {
+		tasks = append(tasks, t.data)
+	}
+	return tasks, nil
+}
+
+// UpdateTaskState applies the update if the task is in one of the from
+// states
+func (s *MemoryStore) UpdateTaskState(taskID string, probeID string, update TaskStateUpdate) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	t, ok := s.tasks[taskID]
+	if !ok || t.data.ProbeID != probeID || !stringInSlice(t.data.State, update.From) {
+		return false, nil
+	}
+	ts := update.Time
+	switch update.TimeColumn {
+	case "notification_time":
+		t.notificationTime = &ts
+	case "accept_time":
+		t.acceptTime = &ts
+	case "done_time":
+		t.doneTime = &ts
+	default:
+		return false, errors.New("invalid time column")
+	}
+	t.data.State = update.State
+	t.leaseExpiry = update.LeaseExpiry
+	t.lastUpdated = update.Time
+	return true, nil
+}
+
+// LeaseTask claims the oldest ready task of the probe
+func (s *MemoryStore) LeaseTask(probeID string, now time.Time, leaseExpiry time.Time) (TaskData, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	from := sourceStates("accepted")
+	tasks := s.sortedTasks(func(t *memoryTask) bool {
+		return t.data.ProbeID == probeID && stringInSlice(t.data.State, from)
+	})
+	if len(tasks) == 0 {
+		return TaskData{}, ErrNoTaskAvailable
+	}
+	t := tasks[0]
+	t.data.State = "accepted"
+	t.acceptTime = &now
+	t.leaseExpiry = &leaseExpiry
+	t.lastUpdated = now
+	return t.data, nil
+}
+
+// ExtendTaskLease pushes forward the lease expiry of a task accepted by the
+// probe
+func (s *MemoryStore) ExtendTaskLease(taskID string, probeID string, now time.Time, leaseExpiry time.Time) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	t, ok := s.tasks[taskID]
+	if !ok || t.data.ProbeID != probeID || t.data.State != "accepted" {
+		return false, nil
+	}
+	t.leaseExpiry = &leaseExpiry
+	t.lastUpdated = now
+	return true, nil
+}
+
+// ExpireTaskLeases puts the accepted tasks whose lease expired back in the
+// ready state
+func (s *MemoryStore) ExpireTaskLeases(now time.Time) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var count int64
+	for _, t := range s.tasks {
+		if t.data.State != "accepted" || t.leaseExpiry == nil ||
+			!t.leaseExpiry.Before(now) {
+			continue
+		}
+		t.data.State = "ready"
+		t.acceptTime = nil
+		t.leaseExpiry = nil
+		t.lastUpdated = now
+		count++
+	}
+	return count, nil
+}
+
+// CancelJobTasks cancels the tasks of the job that are in one of the from
+// states
+func (s *MemoryStore) CancelJobTasks(jobID string, from []string, now time.Time) ([]CancelledTask, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var cancelled []CancelledTask
+	for _, t := range s.sortedTasks(func(t *memoryTask) bool {
+		return t.jobID == jobID && stringInSlice(t.data.State, from)
+	}) {
+		t.data.State = "cancelled"
+		t.doneTime = &now
+		t.leaseExpiry = nil
+		t.lastUpdated = now
+
+		ct := CancelledTask{
+			TaskID: t.data.ID,
+			Probe:  Probe{ID: t.data.ProbeID, TokenExpired: true},
+		}
+		if p, ok := s.probes[t.data.ProbeID]; ok {
+			ct.Probe = *p
+		}
+		cancelled = append(cancelled, ct)
+	}
+	return cancelled, nil
+}
@@ -0,0 +1,136 @@
+package sched
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreJobRun(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddProbe(Probe{ID: "probe-it", Token: "t1", Platform: "cli", ProbeCC: "IT"})
+	store.AddProbe(Probe{ID: "probe-de", Token: "t2", Platform: "cli", ProbeCC: "DE"})
+	store.AddProbe(Probe{ID: "probe-expired", Token: "t3", Platform: "cli",
+		ProbeCC: "IT", TokenExpired: true})
+
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	schedule, err := ParseSchedule("R2/2018-01-01T00:00:00Z/P1D")
+	if err != nil {
+		t.Fatalf("failed to parse schedule: %s", err)
+	}
+	err = store.CreateJob(JobSpec{
+		ID:        "job-1",
+		Schedule:  "R2/2018-01-01T00:00:00Z/P1D",
+		Countries: []string{"IT"},
+		TaskData:  &TaskData{TestName: "web_connectivity"},
+		NextRunAt: start,
+	})
+	if err != nil {
+		t.Fatalf("failed to create job: %s", err)
+	}
+
+	clock := NewFakeClock(start)
+	j := NewJob("job-1", "", schedule, 0)
+	j.clock = clock
+	j.Run(store)
+
+	tasks, err := ListReadyTasks("probe-it", start, store)
+	if err != nil {
+		t.Fatalf("failed to list tasks: %s", err)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("expected 1 task for probe-it (got: %d)", len(tasks))
+	}
+	other, _ := ListReadyTasks("probe-de", start, store)
+	if len(other) != 0 {
+		t.Errorf("expected no task for probe-de (got: %d)", len(other))
+	}
+
+	jobs, err := store.ListActiveJobs()
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("failed to list active jobs: %v %v", jobs, err)
+	}
+	if jobs[0].TimesRun != 1 || !jobs[0].NextRunAt.Equal(start.Add(24*time.Hour)) {
+		t.Errorf("job bookkeeping was not saved: %d %s",
+			jobs[0].TimesRun, jobs[0].NextRunAt)
+	}
+
+	task, _, err := LeaseTask("probe-it", time.Now().UTC(), time.Minute, store)
+	if err != nil {
+		t.Fatalf("failed to lease task: %s", err)
+	}
+	if task.ID != tasks[0].ID {
+		t.Errorf("leased the wrong task: %s", task.ID)
+	}
+	if err = SetTaskState(task.ID, "probe-de", "done", "done_time", time.Now().UTC(), store); err != ErrAccessDenied {
+		t.Errorf("expected ErrAccessDenied (got: %v)", err)
+	}
+	if err = SetTaskState(task.ID, "probe-it", "done", "done_time", time.Now().UTC(), store); err != nil {
+		t.Errorf("failed to mark task as done: %s", err)
+	}
+	if err = SetTaskState(task.ID, "probe-it", "done", "done_time", time.Now().UTC(), store); err != ErrInconsistentState {
+		t.Errorf("expected ErrInconsistentState (got: %v)", err)
+	}
+}
+
+func TestMemoryStoreExpireAndCancel(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now().UTC()
+	for i := 0; i < 2; i++ {
+		_, err := store.CreateTask("job-1", "probe-1",
+			&TaskData{TestName: "web_connectivity"}, now)
+		if err != nil {
+			t.Fatalf("failed to create task: %s", err)
+		}
+	}
+	leased, err := store.LeaseTask("probe-1", now, now.Add(-time.Second))
+	if err != nil {
+		t.Fatalf("failed to lease task: %s", err)
+	}
+	n, err := store.ExpireTaskLeases(now)
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 expired lease (got: %d, %v)", n, err)
+	}
+	task, _ := store.GetTask(leased.ID)
+	if task.State != "ready" {
+		t.Errorf("expected expired task to be ready (got: %s)", task.State)
+	}
+
+	count, err := CancelJobTasks("job-1", false, time.Now().UTC(), store)
+	if err != nil || count != 2 {
+		t.Errorf("expected 2 cancelled tasks (got: %d, %v)", count, err)
+	}
+	if _, _, err = LeaseTask("probe-1", time.Now().UTC(), time.Minute, store); err != ErrNoTaskAvailable {
+		t.Errorf("expected ErrNoTaskAvailable (got: %v)", err)
+	}
+}
+
+func TestMemoryStoreGroupTargets(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddProbe(Probe{ID: "probe-it", Token: "t1", Platform: "cli", ProbeCC: "IT"})
+	store.AddProbe(Probe{ID: "probe-de", Token: "t2", Platform: "cli", ProbeCC: "DE"})
+	store.AddProbe(Probe{ID: "probe-fr", Token: "t3", Platform: "android", ProbeCC: "FR"})
+	store.SetProbeGroup("cohort", []string{"probe-it", "probe-fr"})
+
+	err := store.CreateJob(JobSpec{
+		ID:       "job-1",
+		Schedule: "R1/2018-01-01T00:00:00Z/P1D",
+		Groups:   []string{"cohort"},
+		TaskData: &TaskData{TestName: "web_connectivity"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create job: %s", err)
+	}
+	j := NewJob("job-1", "", Schedule{}, 0)
+	targets := j.GetTargets(store)
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 targets (got: %d)", len(targets))
+	}
+
+	probes, err := store.ListTargetProbes(nil, []string{"android"}, []string{"cohort"})
+	if err != nil || len(probes) != 1 || probes[0].ID != "probe-fr" {
+		t.Errorf("expected only probe-fr (got: %v, %v)", probes, err)
+	}
+	if _, err = store.ResolveProbeGroups([]string{"missing"}); err != ErrProbeGroupNotFound {
+		t.Errorf("expected ErrProbeGroupNotFound (got: %v)", err)
+	}
+}
@@ -0,0 +1,1090 @@
+package sched
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+	"github.com/ooni/orchestra/common"
+	"github.com/ooni/orchestra/common/middleware"
+	"github.com/satori/go.uuid"
+)
+
+// PostgresStore is the Store backed by the orchestra postgres database
+type PostgresStore struct {
+	db *sqlx.DB
+}
+
+// NewPostgresStore creates a Store using the given database
+func NewPostgresStore(db *sqlx.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// DB returns the underlying database
+func (s *PostgresStore) DB() *sqlx.DB {
+	return s.db
+}
+
+// insertJobAction stores the task or the alert of the job and returns its
+// number
+func insertJobAction(tx *sql.Tx, spec JobSpec) (sql.NullInt64, sql.NullInt64, error) {
+	var (
+		taskNo  sql.NullInt64
+		alertNo sql.NullInt64
+	)
+	if spec.AlertData != nil {
+		query := fmt.Sprintf(`INSERT INTO %s (
+			alert_no,
+			message,
+			extra
+		) VALUES (DEFAULT, $1, $2)
+		RETURNING alert_no;`,
+			pq.QuoteIdentifier(common.JobAlertsTable))
+		stmt, err := tx.Prepare(query)
+		if err != nil {
+			ctx.WithError(err).Error("failed to prepare jobs-alerts query")
+			return taskNo, alertNo, err
+		}
+		defer stmt.Close()
+
+		alertExtraStr, err := json.Marshal(spec.AlertData.Extra)
+		if err != nil {
+			ctx.WithError(err).Error("failed to serialise alert args")
+			return taskNo, alertNo, err
+		}
+		err = stmt.QueryRow(spec.AlertData.Message, alertExtraStr).Scan(&alertNo)
+		if err != nil {
+			ctx.WithError(err).Error("failed to insert into job-alerts table")
+			return taskNo, alertNo, err
+		}
+	} else if spec.TaskData != nil {
+		query := fmt.Sprintf(`INSERT INTO %s (
+			task_no,
+			test_name,
+			arguments
+		) VALUES (DEFAULT, $1, $2)
+		RETURNING task_no;`,
+			pq.QuoteIdentifier(common.JobTasksTable))
+		stmt, err := tx.Prepare(query)
+		if err != nil {
+			ctx.WithError(err).Error("failed to prepare jobs-tasks query")
+			return taskNo, alertNo, err
+		}
+		defer stmt.Close()
+
+		taskArgsStr, err := json.Marshal(spec.TaskData.Arguments)
+		if err != nil {
+			ctx.WithError(err).Error("failed to serialise task args")
+			return taskNo, alertNo, err
+		}
+		err = stmt.QueryRow(spec.TaskData.TestName, taskArgsStr).Scan(&taskNo)
+		if err != nil {
+			ctx.WithError(err).Error("failed to insert into job-tasks table")
+			return taskNo, alertNo, err
+		}
+	} else {
+		return taskNo, alertNo, errors.New("task or alert must be defined")
+	}
+	return taskNo, alertNo, nil
+}
+
+// CreateJob stores a new active job
+func (s *PostgresStore) CreateJob(spec JobSpec) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		ctx.WithError(err).Error("failed to open transaction")
+		return err
+	}
+
+	taskNo, alertNo, err := insertJobAction(tx, spec)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (
+		id, comment,
+		schedule, delay,
+		target_countries,
+		target_platforms,
+		creation_time,
+		times_run,
+		next_run_at,
+		is_done,
+		state,
+		task_no,
+		alert_no,
+		end_time,
+		name,
+		campaign_id,
+		target_groups,
+		owner
+	) VALUES (
+		$1, $2,
+		$3, $4,
+		$5,
+		$6,
+		$7,
+		$8,
+		$9,
+		$10,
+		$11,
+		$12,
+		$13,
+		$14,
+		$15,
+		$16,
+		$17,
+		$18)`,
+		pq.QuoteIdentifier(common.JobsTable))
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("failed to prepare jobs query")
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(spec.ID, spec.Comment,
+		spec.Schedule, spec.Delay,
+		pq.Array(spec.Countries),
+		pq.Array(spec.Platforms),
+		spec.CreationTime,
+		0,
+		spec.NextRunAt,
+		false,
+		"active",
+		taskNo,
+		alertNo,
+		spec.EndTime,
+		sql.NullString{String: spec.Name, Valid: spec.Name != ""},
+		sql.NullString{String: spec.CampaignID, Valid: spec.CampaignID != ""},
+		pq.Array(spec.Groups),
+		sql.NullString{String: spec.Owner, Valid: spec.Owner != ""})
+	if err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("failed to insert into jobs table")
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		ctx.WithError(err).Error("failed to commit transaction, rolling back")
+		return err
+	}
+	return nil
+}
+
+// UpdateJob changes the definition of the job in place
+func (s *PostgresStore) UpdateJob(spec JobSpec) error {
+	var (
+		taskNo       sql.NullInt64
+		alertNo      sql.NullInt64
+		timesRun     int64
+		nextRunAtStr string
+	)
+	tx, err := s.db.Begin()
+	if err != nil {
+		ctx.WithError(err).Error("failed to open transaction")
+		return err
+	}
+
+	query := fmt.Sprintf(`SELECT
+		task_no, alert_no,
+		times_run,
+		next_run_at
+		FROM %s
+		WHERE id = $1 AND state != 'deleted'
+		FOR UPDATE`,
+		pq.QuoteIdentifier(common.JobsTable))
+	err = tx.QueryRow(query, spec.ID).Scan(&taskNo, &alertNo,
+		&timesRun, &nextRunAtStr)
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return ErrJobNotFound
+		}
+		ctx.WithError(err).Error("failed to lookup job")
+		return err
+	}
+	nextRunAt, err := time.Parse(ISOUTCTimeLayout, nextRunAtStr)
+	if err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("invalid time string")
+		return err
+	}
+	nextRunAt, isDone, err := updatedRun(spec, timesRun, nextRunAt)
+	if err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("invalid schedule")
+		return err
+	}
+
+	newTaskNo, newAlertNo, err := updateJobAction(tx, spec, taskNo, alertNo)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	query = fmt.Sprintf(`UPDATE %s SET
+		comment = $2,
+		schedule = $3, delay = $4,
+		target_countries = $5,
+		target_platforms = $6,
+		target_groups = $7,
+		task_no = $8,
+		alert_no = $9,
+		end_time = $10,
+		campaign_id = $11,
+		next_run_at = $12,
+		is_done = $13,
+		version = version + 1
+		WHERE id = $1`,
+		pq.QuoteIdentifier(common.JobsTable))
+	_, err = tx.Exec(query, spec.ID, spec.Comment,
+		spec.Schedule, spec.Delay,
+		pq.Array(spec.Countries),
+		pq.Array(spec.Platforms),
+		pq.Array(spec.Groups),
+		newTaskNo,
+		newAlertNo,
+		spec.EndTime,
+		sql.NullString{String: spec.CampaignID, Valid: spec.CampaignID != ""},
+		nextRunAt,
+		isDone)
+	if err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("failed to update jobs table")
+		return err
+	}
+
+	// The task or alert the job no longer uses is removed
+	if taskNo.Valid && !newTaskNo.Valid {
+		query = fmt.Sprintf(`DELETE FROM %s WHERE task_no = $1`,
+			pq.QuoteIdentifier(common.JobTasksTable))
+		_, err = tx.Exec(query, taskNo)
+	} else if alertNo.Valid && !newAlertNo.Valid {
+		query = fmt.Sprintf(`DELETE FROM %s WHERE alert_no = $1`,
+			pq.QuoteIdentifier(common.JobAlertsTable))
+		_, err = tx.Exec(query, alertNo)
+	}
+	if err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("failed to delete the previous job action")
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		ctx.WithError(err).Error("failed to commit transaction, rolling back")
+		return err
+	}
+	return nil
+}
+
+// updateJobAction stores the task or the alert of the updated job in the
+// row of the previous one when the job keeps the same kind of action, and
+// in a new row otherwise. It returns the numbers the job now refers to.
+func updateJobAction(tx *sql.Tx, spec JobSpec, taskNo sql.NullInt64, alertNo sql.NullInt64) (sql.NullInt64, sql.NullInt64, error) {
+	if spec.AlertData != nil && alertNo.Valid {
+		alertExtraStr, err := json.Marshal(spec.AlertData.Extra)
+		if err != nil {
+			ctx.WithError(err).Error("failed to serialise alert args")
+			return taskNo, alertNo, err
+		}
+		query := fmt.Sprintf(`UPDATE %s SET
+			message = $2,
+			extra = $3
+			WHERE alert_no = $1`,
+			pq.QuoteIdentifier(common.JobAlertsTable))
+		_, err = tx.Exec(query, alertNo, spec.AlertData.Message, alertExtraStr)
+		if err != nil {
+			ctx.WithError(err).Error("failed to update job-alerts table")
+			return taskNo, alertNo, err
+		}
+		return sql.NullInt64{}, alertNo, nil
+	} else if spec.AlertData == nil && spec.TaskData != nil && taskNo.Valid {
+		taskArgsStr, err := json.Marshal(spec.TaskData.Arguments)
+		if err != nil {
+			ctx.WithError(err).Error("failed to serialise task args")
+			return taskNo, alertNo, err
+		}
+		query := fmt.Sprintf(`UPDATE %s SET
+			test_name = $2,
+			arguments = $3
+			WHERE task_no = $1`,
+			pq.QuoteIdentifier(common.JobTasksTable))
+		_, err = tx.Exec(query, taskNo, spec.TaskData.TestName, taskArgsStr)
+		if err != nil {
+			ctx.WithError(err).Error("failed to update job-tasks table")
+			return taskNo, alertNo, err
+		}
+		return taskNo, sql.NullInt64{}, nil
+	}
+	return insertJobAction(tx, spec)
+}
+
+// GetJobSpec returns the job, including its task or alert
+func (s *PostgresStore) GetJobSpec(jobID string) (JobSpec, error) {
+	var (
+		err     error
+		taskNo  sql.NullInt64
+		alertNo sql.NullInt64
+		endTime pq.NullTime
+	)
+	spec := JobSpec{ID: jobID}
+
+	query := fmt.Sprintf(`SELECT
+		COALESCE(name, ''),
+		COALESCE(campaign_id::text, ''),
+		COALESCE(owner, ''),
+		comment,
+		schedule, delay,
+		target_countries,
+		target_platforms,
+		target_groups,
+		COALESCE(state, 'active'),
+		creation_time,
+		end_time,
+		task_no,
+		alert_no
+		FROM %s
+		WHERE id = $1`,
+		pq.QuoteIdentifier(common.JobsTable))
+	err = s.db.QueryRow(query, jobID).Scan(
+		&spec.Name,
+		&spec.CampaignID,
+		&spec.Owner,
+		&spec.Comment,
+		&spec.Schedule, &spec.Delay,
+		pq.Array(&spec.Countries),
+		pq.Array(&spec.Platforms),
+		pq.Array(&spec.Groups),
+		&spec.State,
+		&spec.CreationTime,
+		&endTime,
+		&taskNo,
+		&alertNo)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return spec, ErrJobNotFound
+		}
+		ctx.WithError(err).Error("failed to get job")
+		return spec, err
+	}
+	if endTime.Valid {
+		spec.EndTime = &endTime.Time
+	}
+
+	if alertNo.Valid {
+		var (
+			alertExtra types.JSONText
+		)
+		ad := AlertData{}
+		query := fmt.Sprintf(`SELECT
+			message,
+			extra
+			FROM %s
+			WHERE alert_no = $1`,
+			pq.QuoteIdentifier(common.JobAlertsTable))
+		err = s.db.QueryRow(query, alertNo.Int64).Scan(
+			&ad.Message,
+			&alertExtra)
+		if err != nil {
+			ctx.WithError(err).Errorf("failed to get alert_no %d", alertNo.Int64)
+			return spec, err
+		}
+		err = alertExtra.Unmarshal(&ad.Extra)
+		if err != nil {
+			ctx.WithError(err).Error("failed to unmarshal json for alert")
+			return spec, err
+		}
+		spec.AlertData = &ad
+	} else if taskNo.Valid {
+		var (
+			taskArgs types.JSONText
+		)
+		td := TaskData{}
+		query := fmt.Sprintf(`SELECT
+			test_name,
+			arguments
+			FROM %s
+			WHERE task_no = $1`,
+			pq.QuoteIdentifier(common.JobTasksTable))
+		err = s.db.QueryRow(query, taskNo.Int64).Scan(
+			&td.TestName,
+			&taskArgs)
+		if err != nil {
+			ctx.WithError(err).Errorf("failed to get task_no %d", taskNo.Int64)
+			return spec, err
+		}
+		err = taskArgs.Unmarshal(&td.Arguments)
+		if err != nil {
+			ctx.WithError(err).Error("failed to unmarshal json for task")
+			return spec, err
+		}
+		spec.TaskData = &td
+	}
+	return spec, nil
+}
+
+// SetJobState changes the state of the job
+func (s *PostgresStore) SetJobState(jobID string, state string) error {
+	query := fmt.Sprintf(`UPDATE %s SET
+		state = $2
+		WHERE id = $1`,
+		pq.QuoteIdentifier(common.JobsTable))
+	res, err := s.db.Exec(query, jobID, state)
+	if err != nil {
+		ctx.WithError(err).Error("failed to set job state")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return err
+	}
+	if n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// ListNamedJobs returns the jobs that have a name and are not deleted
+func (s *PostgresStore) ListNamedJobs() ([]JobSpec, error) {
+	var (
+		jobIDs []string
+		specs  []JobSpec
+	)
+	query := fmt.Sprintf(`SELECT id FROM %s
+		WHERE name IS NOT NULL AND state IS DISTINCT FROM 'deleted'
+		ORDER BY name`,
+		pq.QuoteIdentifier(common.JobsTable))
+	err := s.db.Select(&jobIDs, query)
+	if err != nil {
+		ctx.WithError(err).Error("failed to list named jobs")
+		return specs, err
+	}
+	for _, jobID := range jobIDs {
+		spec, err := s.GetJobSpec(jobID)
+		if err != nil {
+			return specs, err
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// ListActiveJobs returns the jobs the scheduler should be running
+func (s *PostgresStore) ListActiveJobs() ([]*Job, error) {
+	allJobs := []*Job{}
+	query := fmt.Sprintf(`SELECT
+		id, comment,
+		schedule, delay,
+		times_run,
+		next_run_at,
+		is_done,
+		end_time,
+		version
+		FROM %s
+		WHERE state = 'active'`,
+		pq.QuoteIdentifier(common.JobsTable))
+	rows, err := s.db.Query(query)
+	if err != nil {
+		ctx.WithError(err).Error("failed to list jobs")
+		return allJobs, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			j            Job
+			schedule     string
+			nextRunAtStr string
+			endTime      pq.NullTime
+		)
+		err := rows.Scan(&j.ID,
+			&j.Comment,
+			&schedule,
+			&j.Delay,
+			&j.TimesRun,
+			&nextRunAtStr,
+			&j.IsDone,
+			&endTime,
+			&j.Version)
+		if err != nil {
+			ctx.WithError(err).Error("failed to iterate over jobs")
+			return allJobs, err
+		}
+		j.NextRunAt, err = time.Parse(ISOUTCTimeLayout, nextRunAtStr)
+		if err != nil {
+			ctx.WithError(err).Error("invalid time string")
+			return allJobs, err
+		}
+		j.Schedule, err = ParseSchedule(schedule)
+		if err != nil {
+			ctx.WithError(err).Error("invalid schedule")
+			return allJobs, err
+		}
+		if endTime.Valid {
+			j.Schedule.EndTime = endTime.Time.UTC()
+		}
+		j.lock = sync.RWMutex{}
+		allJobs = append(allJobs, &j)
+	}
+	return allJobs, nil
+}
+
+// CampaignExists returns true if there is a campaign with the given ID
+func (s *PostgresStore) CampaignExists(campaignID string) (bool, error) {
+	var exists bool
+	query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE id = $1)`,
+		pq.QuoteIdentifier(common.CampaignsTable))
+	err := s.db.QueryRow(query, campaignID).Scan(&exists)
+	if err != nil {
+		ctx.WithError(err).Error("failed to lookup campaign")
+		return false, err
+	}
+	return exists, nil
+}
+
+// RecordAudit appends the entry to the audit log
+func (s *PostgresStore) RecordAudit(e middleware.AuditEntry) error {
+	return middleware.WriteAuditEntry(s.db, e)
+}
+
+// SaveJob stores the run bookkeeping of the job
+func (s *PostgresStore) SaveJob(j *Job) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		ctx.WithError(err).Error("failed to open transaction")
+		return err
+	}
+	query := fmt.Sprintf(`UPDATE %s SET
+		times_run = $2,
+		next_run_at = $3,
+		is_done = $4
+		WHERE id = $1 AND version = $5`,
+		pq.QuoteIdentifier(common.JobsTable))
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("failed to prepare update jobs query")
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.Exec(j.ID,
+		j.TimesRun,
+		j.NextRunAt.UTC(),
+		j.IsDone,
+		j.Version)
+
+	if err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("failed to jobs table, rolling back")
+		return errors.New("failed to update jobs table")
+	}
+	if err = tx.Commit(); err != nil {
+		ctx.WithError(err).Error("failed to commit transaction, rolling back")
+		return err
+	}
+	return nil
+}
+
+// ListTargetProbes returns the probes with a valid push token matching the
+// countries, platforms and groups
+func (s *PostgresStore) ListTargetProbes(countries []string, platforms []string, groups []string) ([]Probe, error) {
+	var (
+		probes  []Probe
+		args    []interface{}
+		filters []ProbeFilter
+		err     error
+	)
+	columns := `id, token, platform,
+		COALESCE(probe_cc, ''),
+		COALESCE(software_version, '')`
+	where := "is_token_expired = false AND token != ''"
+	if len(countries) > 0 {
+		args = append(args, pq.Array(countries))
+		where += fmt.Sprintf(" AND probe_cc = ANY($%d)", len(args))
+	}
+	if len(platforms) > 0 {
+		args = append(args, pq.Array(platforms))
+		where += fmt.Sprintf(" AND platform = ANY($%d)", len(args))
+	}
+	// matches are whether the probe is a member of the groups and whether
+	// it matches each saved filter, apart from the version range
+	var matches []string
+	if len(groups) > 0 {
+		filters, err = s.probeGroupFilters(groups)
+		if err != nil {
+			return probes, err
+		}
+		args = append(args, pq.StringArray(groups))
+		matches = append(matches, fmt.Sprintf(
+			"id IN (SELECT probe_id FROM %s WHERE group_name = ANY($%d))",
+			pq.QuoteIdentifier(common.ProbeGroupMembersTable), len(args)))
+		now := time.Now().UTC()
+		for _, f := range filters {
+			var cond string
+			cond, args = f.condition(now, args)
+			matches = append(matches, "("+cond+")")
+		}
+		columns += ", " + strings.Join(matches, ", ")
+		where += " AND (" + strings.Join(matches, " OR ") + ")"
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s",
+		columns,
+		pq.QuoteIdentifier(common.ActiveProbesTable),
+		where)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		ctx.WithError(err).Errorf("failed to find targets '%s'", query)
+		return probes, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p Probe
+		matched := make([]bool, len(matches))
+		dest := []interface{}{&p.ID, &p.Token, &p.Platform, &p.ProbeCC,
+			&p.SoftwareVersion}
+		for i := range matched {
+			dest = append(dest, &matched[i])
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			ctx.WithError(err).Error("failed to iterate over targets")
+			return probes, err
+		}
+		if len(matched) > 0 && !matched[0] {
+			inFilter := false
+			for i, f := range filters {
+				if matched[i+1] && f.matchesVersion(p.SoftwareVersion) {
+					inFilter = true
+					break
+				}
+			}
+			if !inFilter {
+				continue
+			}
+		}
+		probes = append(probes, p)
+	}
+	return probes, nil
+}
+
+// probeGroupFilters returns the saved filters of the groups, or
+// ErrProbeGroupNotFound if one of them does not exist
+func (s *PostgresStore) probeGroupFilters(groups []string) ([]ProbeFilter, error) {
+	var filters []ProbeFilter
+	query := fmt.Sprintf(`SELECT name, filter FROM %s WHERE name = ANY($1)`,
+		pq.QuoteIdentifier(common.ProbeGroupsTable))
+	rows, err := s.db.Query(query, pq.StringArray(groups))
+	if err != nil {
+		ctx.WithError(err).Error("failed to get probe groups")
+		return filters, err
+	}
+	defer rows.Close()
+	found := make(map[string]bool)
+	for rows.Next() {
+		var (
+			name   string
+			filter types.NullJSONText
+		)
+		err = rows.Scan(&name, &filter)
+		if err != nil {
+			ctx.WithError(err).Error("failed to iterate over probe groups")
+			return filters, err
+		}
+		found[name] = true
+		if filter.Valid {
+			var f ProbeFilter
+			err = filter.Unmarshal(&f)
+			if err != nil {
+				ctx.WithError(err).Errorf("invalid filter for probe group %s", name)
+				return filters, err
+			}
+			filters = append(filters, f)
+		}
+	}
+	for _, name := range groups {
+		if !found[name] {
+			return filters, ErrProbeGroupNotFound
+		}
+	}
+	return filters, nil
+}
+
+// ResolveProbeGroups returns the IDs of the probes that are members of the
+// groups or that match their saved filter
+func (s *PostgresStore) ResolveProbeGroups(groups []string) (map[string]bool, error) {
+	members := make(map[string]bool)
+	filters, err := s.probeGroupFilters(groups)
+	if err != nil {
+		return members, err
+	}
+
+	var probeIDs []string
+	query := fmt.Sprintf(`SELECT DISTINCT probe_id FROM %s
+		WHERE group_name = ANY($1)`,
+		pq.QuoteIdentifier(common.ProbeGroupMembersTable))
+	err = s.db.Select(&probeIDs, query, pq.StringArray(groups))
+	if err != nil {
+		ctx.WithError(err).Error("failed to list probe group members")
+		return members, err
+	}
+	for _, id := range probeIDs {
+		members[id] = true
+	}
+	for _, f := range filters {
+		probes, err := s.ListProbes(f)
+		if err != nil {
+			return members, err
+		}
+		for _, p := range probes {
+			members[p.ID] = true
+		}
+	}
+	return members, nil
+}
+
+// SetTokenExpired marks the token of the probe as expired
+func (s *PostgresStore) SetTokenExpired(probeID string) error {
+	query := fmt.Sprintf(`UPDATE %s SET
+		is_token_expired = true
+		WHERE id = $1`,
+		pq.QuoteIdentifier(common.ActiveProbesTable))
+	_, err := s.db.Exec(query, probeID)
+	if err != nil {
+		ctx.WithError(err).Error("failed to set token as expired")
+		return err
+	}
+	return nil
+}
+
+// CreateTask stores a new ready task and returns its ID
+func (s *PostgresStore) CreateTask(jobID string, probeID string, td *TaskData, now time.Time) (string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		ctx.WithError(err).Error("failed to open createTask transaction")
+		return "", err
+	}
+
+	var taskID = uuid.NewV4().String()
+	query := fmt.Sprintf(`INSERT INTO %s (
+		id, probe_id,
+		job_id, test_name,
+		arguments,
+		state,
+		progress,
+		creation_time,
+		notification_time,
+		accept_time,
+		done_time,
+		last_updated
+	) VALUES (
+		$1, $2,
+		$3, $4,
+		$5,
+		$6,
+		$7,
+		$8,
+		$9,
+		$10,
+		$11,
+		$12)`,
+		pq.QuoteIdentifier(common.TasksTable))
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("failed to prepare task create query")
+		return "", err
+	}
+	defer stmt.Close()
+
+	taskArgsStr, err := json.Marshal(td.Arguments)
+	ctx.Debugf("task args: %v", td.Arguments)
+	if err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("failed to serialise task arguments in createTask")
+		return "", err
+	}
+	_, err = stmt.Exec(taskID, probeID,
+		jobID, td.TestName,
+		taskArgsStr,
+		"ready",
+		0,
+		now,
+		nil,
+		nil,
+		nil,
+		now)
+	if err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("failed to insert into tasks table")
+		return "", err
+	}
+	if err = tx.Commit(); err != nil {
+		ctx.WithError(err).Error("failed to commit transaction in tasks table, rolling back")
+		return "", err
+	}
+	return taskID, nil
+}
+
+// GetTask returns the task with the given ID
+func (s *PostgresStore) GetTask(taskID string) (TaskData, error) {
+	var (
+		err      error
+		taskArgs types.JSONText
+	)
+	task := TaskData{}
+	query := fmt.Sprintf(`SELECT
+		id,
+		probe_id,
+		test_name,
+		arguments,
+		COALESCE(state, 'ready')
+		FROM %s
+		WHERE id = $1`,
+		pq.QuoteIdentifier(common.TasksTable))
+	err = s.db.QueryRow(query, taskID).Scan(
+		&task.ID,
+		&task.ProbeID,
+		&task.TestName,
+		&taskArgs,
+		&task.State)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return task, ErrTaskNotFound
+		}
+		ctx.WithError(err).Error("failed to get task")
+		return task, err
+	}
+	err = taskArgs.Unmarshal(&task.Arguments)
+	if err != nil {
+		ctx.WithError(err).Error("failed to unmarshal json")
+		return task, err
+	}
+	return task, nil
+}
+
+// ListReadyTasks returns the ready tasks of the probe created since the
+// given time
+func (s *PostgresStore) ListReadyTasks(probeID string, since time.Time) ([]TaskData, error) {
+	var (
+		err   error
+		tasks []TaskData
+	)
+	query := fmt.Sprintf(`SELECT
+		id,
+		test_name,
+		arguments
+		FROM %s
+		WHERE
+		state = 'ready' AND
+		probe_id = $1 AND creation_time >= $2`,
+		pq.QuoteIdentifier(common.TasksTable))
+
+	rows, err := s.db.Query(query, probeID, since)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return tasks, nil
+		}
+		ctx.WithError(err).Error("failed to get task list")
+		return tasks, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			taskArgs types.JSONText
+			task     TaskData
+		)
+		err = rows.Scan(&task.ID, &task.TestName, &taskArgs)
+		if err != nil {
+			ctx.WithError(err).Error("failed to get task")
+			return tasks, err
+		}
+		err = taskArgs.Unmarshal(&task.Arguments)
+		if err != nil {
+			ctx.WithError(err).Error("failed to unmarshal json")
+			return tasks, err
+		}
+		task.ProbeID = probeID
+		task.State = "ready"
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// UpdateTaskState applies the update with a single conditional UPDATE, so
+// that concurrent requests cannot both perform the same transition
+func (s *PostgresStore) UpdateTaskState(taskID string, probeID string, update TaskStateUpdate) (bool, error) {
+	query := fmt.Sprintf(`UPDATE %s SET
+		state = $2,
+		%s = $3,
+		last_updated = $3,
+		lease_expiry = $4
+		WHERE id = $1
+		AND probe_id = $5
+		AND COALESCE(state, 'ready') = ANY($6)`,
+		pq.QuoteIdentifier(common.TasksTable),
+		pq.QuoteIdentifier(update.TimeColumn))
+
+	res, err := s.db.Exec(query, taskID, update.State, update.Time,
+		update.LeaseExpiry, probeID, pq.Array(update.From))
+	if err != nil {
+		ctx.WithError(err).Error("failed to set task state")
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// LeaseTask claims the oldest ready task of the probe. Concurrent callers
+// never receive the same task.
+func (s *PostgresStore) LeaseTask(probeID string, now time.Time, leaseExpiry time.Time) (TaskData, error) {
+	var (
+		err      error
+		taskArgs types.JSONText
+	)
+	task := TaskData{}
+
+	query := fmt.Sprintf(`UPDATE %s SET
+		state = 'accepted',
+		accept_time = $2,
+		last_updated = $2,
+		lease_expiry = $3
+		WHERE id = (
+			SELECT id FROM %s
+			WHERE probe_id = $1 AND COALESCE(state, 'ready') = ANY($4)
+			ORDER BY creation_time ASC
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, test_name, arguments, state`,
+		pq.QuoteIdentifier(common.TasksTable),
+		pq.QuoteIdentifier(common.TasksTable))
+	err = s.db.QueryRow(query, probeID, now, leaseExpiry,
+		pq.Array(sourceStates("accepted"))).Scan(
+		&task.ID,
+		&task.TestName,
+		&taskArgs,
+		&task.State)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return task, ErrNoTaskAvailable
+		}
+		ctx.WithError(err).Error("failed to lease task")
+		return task, err
+	}
+	err = taskArgs.Unmarshal(&task.Arguments)
+	if err != nil {
+		ctx.WithError(err).Error("failed to unmarshal json")
+		return task, err
+	}
+	task.ProbeID = probeID
+	return task, nil
+}
+
+// ExtendTaskLease pushes forward the lease expiry of a task accepted by the
+// probe
+func (s *PostgresStore) ExtendTaskLease(taskID string, probeID string, now time.Time, leaseExpiry time.Time) (bool, error) {
+	query := fmt.Sprintf(`UPDATE %s SET
+		lease_expiry = $2,
+		last_updated = $3
+		WHERE id = $1
+		AND probe_id = $4
+		AND state = 'accepted'`,
+		pq.QuoteIdentifier(common.TasksTable))
+	res, err := s.db.Exec(query, taskID, leaseExpiry, now, probeID)
+	if err != nil {
+		ctx.WithError(err).Error("failed to extend task lease")
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		ctx.WithError(err).Error("failed to get affected rows")
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ExpireTaskLeases puts the accepted tasks whose lease expired back in the
+// ready state
+func (s *PostgresStore) ExpireTaskLeases(now time.Time) (int64, error) {
+	query := fmt.Sprintf(`UPDATE %s SET
+		state = 'ready',
+		accept_time = NULL,
+		lease_expiry = NULL,
+		last_updated = $1
+		WHERE state = 'accepted' AND lease_expiry < $1`,
+		pq.QuoteIdentifier(common.TasksTable))
+	res, err := s.db.Exec(query, now)
+	if err != nil {
+		ctx.WithError(err).Error("failed to expire task leases")
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CancelJobTasks cancels the tasks of the job and returns them along with
+// the push token of their probe
+func (s *PostgresStore) CancelJobTasks(jobID string, from []string, now time.Time) ([]CancelledTask, error) {
+	var cancelled []CancelledTask
+
+	query := fmt.Sprintf(`WITH cancelled AS (
+			UPDATE %s SET
+			state = 'cancelled',
+			done_time = $2,
+			last_updated = $2,
+			lease_expiry = NULL
+			WHERE job_id = $1
+			AND COALESCE(state, 'ready') = ANY($3)
+			RETURNING id, probe_id
+		)
+		SELECT
+		cancelled.id,
+		cancelled.probe_id,
+		COALESCE(%s.token, ''),
+		COALESCE(%s.platform, ''),
+		COALESCE(%s.is_token_expired, true)
+		FROM cancelled
+		LEFT OUTER JOIN %s ON (%s.id = cancelled.probe_id)`,
+		pq.QuoteIdentifier(common.TasksTable),
+		pq.QuoteIdentifier(common.ActiveProbesTable),
+		pq.QuoteIdentifier(common.ActiveProbesTable),
+		pq.QuoteIdentifier(common.ActiveProbesTable),
+		pq.QuoteIdentifier(common.ActiveProbesTable),
+		pq.QuoteIdentifier(common.ActiveProbesTable))
+	rows, err := s.db.Query(query, jobID, now, pq.Array(from))
+	if err != nil {
+		ctx.WithError(err).Error("failed to cancel job tasks")
+		return cancelled, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t CancelledTask
+		err = rows.Scan(&t.TaskID, &t.Probe.ID, &t.Probe.Token,
+			&t.Probe.Platform, &t.Probe.TokenExpired)
+		if err != nil {
+			ctx.WithError(err).Error("failed to iterate over cancelled tasks")
+			return cancelled, err
+		}
+		cancelled = append(cancelled, t)
+	}
+	if err = rows.Err(); err != nil {
+		ctx.WithError(err).Error("failed to iterate over cancelled tasks")
+		return cancelled, err
+	}
+	return cancelled, nil
+}
@@ -0,0 +1,93 @@
+package sched
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestListTargetProbesInGroup(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	store := NewPostgresStore(sqlx.NewDb(mockDB, "sqlmock"))
+
+	mock.ExpectQuery("^SELECT name, filter FROM \"probe_groups\"").
+		WithArgs(pq.StringArray([]string{"testers", "beta"})).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "filter"}).
+			AddRow("testers", nil).
+			AddRow("beta", []byte(`{"countries":["IT"],"min_version":"2.0"}`)))
+	// The members and the saved filter are matched by the query instead of
+	// being loaded first
+	mock.ExpectQuery("^SELECT (.+) FROM \"active_probes\" (.+) AND platform = ANY\\(\\$1\\) AND \\(id IN \\(SELECT probe_id FROM \"probe_group_members\" WHERE group_name = ANY\\(\\$2\\)\\) OR \\(probe_cc = ANY\\(\\$3\\)\\)\\)$").
+		WithArgs(pq.StringArray([]string{"android"}),
+			pq.StringArray([]string{"testers", "beta"}),
+			pq.StringArray([]string{"IT"})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "token", "platform",
+			"probe_cc", "software_version", "member", "beta"}).
+			AddRow("probe-1", "t1", "android", "DE", "1.0", true, false).
+			AddRow("probe-2", "t2", "android", "IT", "2.1", false, true).
+			AddRow("probe-3", "t3", "android", "IT", "1.9", false, true))
+
+	probes, err := store.ListTargetProbes(nil, []string{"android"}, []string{"testers", "beta"})
+	if err != nil {
+		t.Fatalf("error in calling ListTargetProbes: %s", err)
+	}
+	if len(probes) != 2 || probes[0].ID != "probe-1" || probes[1].ID != "probe-2" {
+		t.Errorf("unexpected probes: %v", probes)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateJobKeepsTaskRow(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	store := NewPostgresStore(sqlx.NewDb(mockDB, "sqlmock"))
+
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery("^SELECT task_no, alert_no, times_run, next_run_at FROM \"jobs\" (.+) FOR UPDATE").
+		WithArgs("job-1").
+		WillReturnRows(sqlmock.NewRows([]string{"task_no", "alert_no",
+			"times_run", "next_run_at"}).
+			AddRow(3, nil, 2, "2018-01-03T00:00:00Z"))
+	// The task is changed in its row instead of a new one being inserted
+	mock.ExpectExec("^UPDATE \"job_tasks\" SET (.+) WHERE task_no = \\$1").
+		WithArgs(sql.NullInt64{Int64: 3, Valid: true}, "web_connectivity",
+			sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Raising the number of repetitions of a done job makes it run again
+	mock.ExpectExec("^UPDATE \"jobs\" SET (.+) next_run_at = \\$12, is_done = \\$13").
+		WithArgs("job-1", "", "R3/2018-01-01T00:00:00Z/P1D", int64(0),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sql.NullInt64{Int64: 3, Valid: true}, sql.NullInt64{},
+			sqlmock.AnyArg(), sqlmock.AnyArg(),
+			start.Add(48*time.Hour), false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err = store.UpdateJob(JobSpec{
+		ID:        "job-1",
+		Schedule:  "R3/2018-01-01T00:00:00Z/P1D",
+		TaskData:  &TaskData{TestName: "web_connectivity"},
+		NextRunAt: start,
+	})
+	if err != nil {
+		t.Fatalf("error in calling UpdateJob: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
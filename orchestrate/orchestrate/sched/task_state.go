@@ -0,0 +1,116 @@
+package sched
+
+import (
+	"time"
+)
+
+// TaskStates are all the states a task can be in
+var TaskStates = []string{"ready", "notified", "accepted", "rejected", "done",
+	"cancelled"}
+
+// TaskTransitions is the task state machine. It maps every state to the
+// states a task is allowed to move to from it.
+var TaskTransitions = map[string][]string{
+	"ready":    {"notified", "accepted", "rejected", "cancelled"},
+	"notified": {"accepted", "rejected", "cancelled"},
+	// accepted goes back to ready when the lease expires
+	"accepted":  {"ready", "rejected", "done", "cancelled"},
+	"rejected":  {},
+	"done":      {},
+	"cancelled": {},
+}
+
+// CanTransition returns true if a task can move from the state from to the
+// state to
+func CanTransition(from string, to string) bool {
+	for _, s := range TaskTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceStates returns the states a task can move to the state to from
+func sourceStates(to string) []string {
+	var states []string
+	for _, from := range TaskStates {
+		if CanTransition(from, to) {
+			states = append(states, from)
+		}
+	}
+	return states
+}
+
+// explainFailedUpdate is called when a conditional update on a task matched
+// no rows to figure out which error to return
+func explainFailedUpdate(tID string, uID string, store Store) error {
+	_, err := GetTask(tID, uID, store)
+	if err != nil {
+		return err
+	}
+	return ErrInconsistentState
+}
+
+// SetTaskState moves the task to the given state, as long as TaskTransitions
+// allows it. The check and the update happen atomically in the store so
+// that concurrent requests cannot both perform the same transition.
+func SetTaskState(tID string, uID string,
+	state string,
+	updateTimeCol string,
+	now time.Time,
+	store Store) error {
+	// Accepted tasks are leased to the probe, every other state clears the
+	// lease
+	var leaseExpiry *time.Time
+	if state == "accepted" {
+		e := now.Add(TaskLeaseDuration())
+		leaseExpiry = &e
+	}
+
+	ok, err := store.UpdateTaskState(tID, uID, TaskStateUpdate{
+		State:       state,
+		From:        sourceStates(state),
+		TimeColumn:  updateTimeCol,
+		Time:        now,
+		LeaseExpiry: leaseExpiry,
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return explainFailedUpdate(tID, uID, store)
+	}
+	return nil
+}
+
+// CancelJobTasks marks every outstanding task of the job as cancelled. When
+// notify is true the probes holding the cancelled tasks are sent a silent
+// push telling them to drop the task. It returns the number of cancelled
+// tasks.
+func CancelJobTasks(jobID string, notify bool, now time.Time, store Store) (int64, error) {
+	cancelled, err := store.CancelJobTasks(jobID, sourceStates("cancelled"), now)
+	if err != nil {
+		return 0, err
+	}
+
+	if notify {
+		// The tasks are already cancelled, so a probe that misses the push
+		// will find out when it next fetches the task
+		for _, ct := range cancelled {
+			if ct.Probe.TokenExpired || ct.Probe.Token == "" {
+				continue
+			}
+			taskID := ct.TaskID
+			td := &TaskData{ID: taskID, State: "cancelled"}
+			t := NewJobTarget(ct.Probe.ID, ct.Probe.Token, ct.Probe.Platform,
+				&taskID, td, nil)
+			err = push(t, store)
+			if err != nil {
+				ctx.WithError(err).Errorf("failed to notify %s of cancelled task",
+					t.ClientID)
+			}
+		}
+	}
+	return int64(len(cancelled)), nil
+}
@@ -0,0 +1,138 @@
+package sched
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestTaskTransitions(t *testing.T) {
+	// Every allowed transition. Anything not listed here must be refused.
+	allowed := map[string]map[string]bool{
+		"ready": {"notified": true, "accepted": true, "rejected": true,
+			"cancelled": true},
+		"notified": {"accepted": true, "rejected": true, "cancelled": true},
+		"accepted": {"ready": true, "rejected": true, "done": true,
+			"cancelled": true},
+	}
+	for state := range TaskTransitions {
+		found := false
+		for _, s := range TaskStates {
+			if s == state {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s is in TaskTransitions but not in TaskStates", state)
+		}
+	}
+	for _, from := range TaskStates {
+		for _, to := range TaskStates {
+			if CanTransition(from, to) != allowed[from][to] {
+				t.Errorf("transition %s -> %s: expected %v (got: %v)",
+					from, to, allowed[from][to], CanTransition(from, to))
+			}
+		}
+	}
+	if CanTransition("invalid", "ready") || CanTransition("ready", "invalid") {
+		t.Error("transitions involving unknown states must be refused")
+	}
+}
+
+func TestSetTaskState(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	store := NewPostgresStore(sqlx.NewDb(mockDB, "sqlmock"))
+
+	mock.ExpectExec("^UPDATE (.+) WHERE id = \\$1 AND probe_id = \\$5 AND COALESCE\\(state, 'ready'\\) = ANY\\(\\$6\\)").
+		WithArgs("task-1", "done", sqlmock.AnyArg(), nil,
+			dummyProbeID, pq.Array([]string{"accepted"})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = SetTaskState("task-1", dummyProbeID, "done", "done_time", time.Now().UTC(), store)
+	if err != nil {
+		t.Errorf("error in calling SetTaskState: %s", err)
+	}
+}
+
+func TestSetTaskStateConflict(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	store := NewPostgresStore(sqlx.NewDb(mockDB, "sqlmock"))
+
+	// The task was accepted by a concurrent request, so the conditional
+	// update matches no rows
+	mock.ExpectExec("^UPDATE (.+)").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"id", "probe_id", "test_name",
+		"arguments", "state"}).
+		AddRow("task-1", dummyProbeID, "web_connectivity", []byte(`{}`),
+			"accepted")
+	mock.ExpectQuery("^SELECT (.+) FROM").
+		WithArgs("task-1").
+		WillReturnRows(rows)
+
+	err = SetTaskState("task-1", dummyProbeID, "accepted", "accept_time", time.Now().UTC(), store)
+	if err != ErrInconsistentState {
+		t.Errorf("expected ErrInconsistentState (got: %v)", err)
+	}
+}
+
+func TestSetTaskStateNotFound(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	store := NewPostgresStore(sqlx.NewDb(mockDB, "sqlmock"))
+
+	mock.ExpectExec("^UPDATE (.+)").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("^SELECT (.+) FROM").
+		WithArgs("task-1").
+		WillReturnError(sql.ErrNoRows)
+
+	err = SetTaskState("task-1", dummyProbeID, "accepted", "accept_time", time.Now().UTC(), store)
+	if err != ErrTaskNotFound {
+		t.Errorf("expected ErrTaskNotFound (got: %v)", err)
+	}
+}
+
+func TestCancelJobTasks(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	store := NewPostgresStore(sqlx.NewDb(mockDB, "sqlmock"))
+
+	rows := sqlmock.NewRows([]string{"id", "probe_id", "token", "platform",
+		"is_token_expired"}).
+		AddRow("task-1", dummyProbeID, "token", "android", false).
+		AddRow("task-2", dummyProbeID, "", "", true)
+	mock.ExpectQuery("^WITH cancelled AS \\( UPDATE (.+) SET state = 'cancelled'").
+		WithArgs("job-1", sqlmock.AnyArg(),
+			pq.Array([]string{"ready", "notified", "accepted"})).
+		WillReturnRows(rows)
+
+	count, err := CancelJobTasks("job-1", false, time.Now().UTC(), store)
+	if err != nil {
+		t.Fatalf("error in calling CancelJobTasks: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 cancelled tasks (got: %d)", count)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
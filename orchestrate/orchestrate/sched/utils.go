@@ -22,8 +22,14 @@ type ScheduleDuration struct {
 	Seconds float64
 }
 
-// ToDuration convert to a time.Duration
+// ToDuration convert to a time.Duration, counting months from now
 func (d *ScheduleDuration) ToDuration() time.Duration {
+	return d.ToDurationFrom(time.Now().UTC())
+}
+
+// ToDurationFrom convert to a time.Duration, counting months from the given
+// time
+func (d *ScheduleDuration) ToDurationFrom(from time.Time) time.Duration {
 	day := float64(time.Hour) * 24.0
 	year := day * 365.0
 
@@ -35,18 +41,17 @@ func (d *ScheduleDuration) ToDuration() time.Duration {
 	tot += time.Duration(int64(float64(time.Minute) * d.Minutes))
 	tot += time.Duration(int64(float64(time.Second) * d.Seconds))
 
-	tot += d.getMonthDuration()
+	tot += d.getMonthDuration(from)
 
 	return tot
 }
 
-func (d *ScheduleDuration) getMonthDuration() time.Duration {
+func (d *ScheduleDuration) getMonthDuration(from time.Time) time.Duration {
 	if d.Months == 0 {
 		return time.Duration(0)
 	}
-	now := time.Now().UTC()
-	currentMonth := int(now.Month())
-	currentYear := int(now.Year())
+	currentMonth := int(from.Month())
+	currentYear := int(from.Year())
 
 	value := time.Duration(0)
 	i := 0
@@ -91,7 +96,19 @@ func IntInSlice(num int, slice []int) bool {
 type Schedule struct {
 	Repeat    int64
 	StartTime time.Time
-	Duration  ScheduleDuration
+	// EndTime is when the schedule stops repeating. It is the zero time when
+	// the schedule is only bounded by Repeat.
+	EndTime  time.Time
+	Duration ScheduleDuration
+}
+
+// HasEnded returns true if the schedule has an end time and it is not
+// after t
+func (s *Schedule) HasEnded(t time.Time) bool {
+	if s.EndTime.IsZero() {
+		return false
+	}
+	return !t.Before(s.EndTime)
 }
 
 func leadingFloat(s string) (float64, string, error) {
@@ -171,19 +188,22 @@ func ParseDuration(s string) (ScheduleDuration, error) {
 	return d, nil
 }
 
-// ParseSchedule parse a schedule string
+// ParseSchedule parse a schedule string. The schedule is either in the
+// R[n]/start/P[duration] form or in the R[n]/start/end/P[duration] form,
+// where the job stops repeating at end.
 func ParseSchedule(s string) (Schedule, error) {
 	var schedule Schedule
 	var err error
 	parts := strings.Split(s, "/")
-	if len(parts) != 3 {
+	if len(parts) != 3 && len(parts) != 4 {
 		return schedule, errors.New("invalid number of parts")
 	}
-	if parts[0][0] != 'R' {
+	var durationPart = parts[len(parts)-1]
+	if len(parts[0]) == 0 || parts[0][0] != 'R' {
 		return schedule, errors.New("first part must start with \"R\"")
 	}
-	if parts[2][0] != 'P' {
-		return schedule, errors.New("third part must start with \"P\"")
+	if len(durationPart) == 0 || durationPart[0] != 'P' {
+		return schedule, errors.New("last part must start with \"P\"")
 	}
 
 	// We use -1 to indicate repeat forever
@@ -207,8 +227,19 @@ func ParseSchedule(s string) (Schedule, error) {
 	}
 	schedule.StartTime = t
 
+	if len(parts) == 4 {
+		schedule.EndTime, err = time.Parse(ISOUTCTimeLayout, parts[2])
+		if err != nil {
+			ctx.WithError(err).Error("invalid end time")
+			return schedule, errors.New("invalid end time")
+		}
+		if !schedule.EndTime.After(schedule.StartTime) {
+			return schedule, errors.New("end time must be after start time")
+		}
+	}
+
 	var d ScheduleDuration
-	d, err = ParseDuration(parts[2][1:])
+	d, err = ParseDuration(durationPart[1:])
 	if err != nil {
 		ctx.WithError(err).Error("invalid duration")
 		return schedule, errors.New("invalid duration")
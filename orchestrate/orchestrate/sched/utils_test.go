@@ -2,6 +2,7 @@ package sched
 
 import (
 	"testing"
+	"time"
 )
 
 func TestParseSchedule(t *testing.T) {
@@ -52,3 +53,28 @@ func TestToDuration(t *testing.T) {
 			d.Hours())
 	}
 }
+
+func TestParseScheduleEndTime(t *testing.T) {
+	s, err := ParseSchedule("R/2018-12-16T16:20:30Z/2018-12-20T00:00:00Z/PT1H")
+	if err != nil {
+		t.Fatalf("failed to parse schedule: %s", err)
+	}
+	expected := time.Date(2018, 12, 20, 0, 0, 0, 0, time.UTC)
+	if !s.EndTime.Equal(expected) {
+		t.Errorf("expected end time %s (got: %s)", expected, s.EndTime)
+	}
+	if s.Duration.Hours != 1.0 {
+		t.Errorf("expected 1.0 hours duration (got: %f)", s.Duration.Hours)
+	}
+	if s.HasEnded(expected.Add(-time.Second)) {
+		t.Error("schedule should not have ended before the end time")
+	}
+	if !s.HasEnded(expected) {
+		t.Error("schedule should have ended at the end time")
+	}
+
+	_, err = ParseSchedule("R/2018-12-16T16:20:30Z/2018-12-01T00:00:00Z/PT1H")
+	if err == nil {
+		t.Error("expected an end time before the start time to be refused")
+	}
+}
@@ -3,6 +3,7 @@ package apiv1
 import (
 	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
 	"github.com/ooni/orchestra/common/middleware"
 	"github.com/ooni/orchestra/registry/registry/handler"
 )
@@ -13,16 +14,29 @@ var ctx = log.WithFields(log.Fields{
 })
 
 // BindAPI bind all the request handlers and middleware
-func BindAPI(router *gin.Engine, authMiddleware *middleware.GinJWTMiddleware) error {
+func BindAPI(router *gin.Engine, authMiddleware *middleware.GinJWTMiddleware, db *sqlx.DB) error {
 	v1 := router.Group("/api/v1")
 
 	v1.POST("/login", authMiddleware.LoginHandler)
+	v1.POST("/refresh", authMiddleware.RefreshHandler)
 	v1.POST("/register", handler.RegisterHandler)
+	v1.POST("/logout",
+		authMiddleware.MiddlewareFunc(middleware.AuthenticatedAuthorizor),
+		authMiddleware.LogoutHandler)
 
 	admin := v1.Group("/admin")
 	admin.Use(authMiddleware.MiddlewareFunc(middleware.AdminAuthorizor))
+	admin.Use(middleware.AuditMiddleware(db))
 	{
 		admin.GET("/clients", handler.ListClientsHandler)
+		admin.DELETE("/clients/:client_id", handler.DeleteClientHandler)
+		admin.GET("/accounts", handler.ListAccountsHandler)
+		admin.POST("/accounts", handler.AddAccountHandler)
+		admin.DELETE("/accounts/:username", handler.DeleteAccountHandler)
+		admin.POST("/accounts/:username/disable", handler.DisableAccountHandler)
+		admin.POST("/accounts/:username/enable", handler.EnableAccountHandler)
+		admin.PUT("/accounts/:username/password", handler.SetAccountPasswordHandler)
+		admin.POST("/accounts/:username/revoke-tokens", authMiddleware.RevokeUserTokensHandler)
 	}
 
 	device := v1.Group("/")
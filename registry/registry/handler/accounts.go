@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/ooni/orchestra/common/middleware"
+)
+
+// NewAccount is the request to create an account
+type NewAccount struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Role     string `json:"role" binding:"required"`
+}
+
+// writeAccountError maps the errors of the account functions to a response
+func writeAccountError(c *gin.Context, err error) {
+	switch err {
+	case middleware.ErrAccountNotFound:
+		c.JSON(http.StatusNotFound,
+			gin.H{"error": err.Error()})
+	case middleware.ErrAccountExists:
+		c.JSON(http.StatusConflict,
+			gin.H{"error": err.Error()})
+	case middleware.ErrInvalidRole, middleware.ErrInvalidUsername,
+		middleware.ErrWeakPassword:
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+	}
+}
+
+// auditAccount writes the audit entry of an account change. The request
+// body may contain a password, so unlike the audit middleware it does not
+// keep a digest of it.
+func auditAccount(c *gin.Context, action string, username string) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	audit := middleware.RequestAuditEntry(c)
+	audit.Action = action
+	audit.TargetID = username
+	audit.BodyDigest = ""
+	middleware.WriteAuditEntry(db, audit)
+	middleware.MarkAudited(c)
+}
+
+// ListAccountsHandler lists the accounts that are not device accounts
+func ListAccountsHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	accounts, err := middleware.ListAccounts(db)
+	if err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK,
+		gin.H{"accounts": accounts})
+}
+
+// AddAccountHandler creates an account
+func AddAccountHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	var req NewAccount
+	err := c.BindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid request"})
+		return
+	}
+	err = middleware.CreateAccount(db, req.Username, req.Password, req.Role)
+	if err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	auditAccount(c, "account.create", req.Username)
+	c.JSON(http.StatusOK,
+		gin.H{"username": req.Username})
+}
+
+// DeleteAccountHandler deletes an account
+func DeleteAccountHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	username := c.Param("username")
+	err := middleware.DeleteAccount(db, username)
+	if err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	middleware.ForgetUserRevocations(c, username)
+	auditAccount(c, "account.delete", username)
+	c.JSON(http.StatusOK,
+		gin.H{"status": "deleted"})
+}
+
+// accountDisabledHandler returns the handler that disables or enables an
+// account
+func accountDisabledHandler(disabled bool) gin.HandlerFunc {
+	action, status := "account.enable", "enabled"
+	if disabled {
+		action, status = "account.disable", "disabled"
+	}
+	return func(c *gin.Context) {
+		db := c.MustGet("DB").(*sqlx.DB)
+
+		username := c.Param("username")
+		err := middleware.SetAccountDisabled(db, username, disabled)
+		if err != nil {
+			writeAccountError(c, err)
+			return
+		}
+		if disabled {
+			middleware.ForgetUserRevocations(c, username)
+		}
+		auditAccount(c, action, username)
+		c.JSON(http.StatusOK,
+			gin.H{"status": status})
+	}
+}
+
+// DisableAccountHandler disables an account, which can no longer login
+var DisableAccountHandler = accountDisabledHandler(true)
+
+// EnableAccountHandler enables a disabled account
+var EnableAccountHandler = accountDisabledHandler(false)
+
+// SetAccountPasswordHandler replaces the password of an account
+func SetAccountPasswordHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	var req struct {
+		Password string `json:"password" binding:"required"`
+	}
+	err := c.BindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest,
+			gin.H{"error": "invalid request"})
+		return
+	}
+	username := c.Param("username")
+	err = middleware.SetAccountPassword(db, username, req.Password)
+	if err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	middleware.ForgetUserRevocations(c, username)
+	auditAccount(c, "account.password", username)
+	c.JSON(http.StatusOK,
+		gin.H{"status": "updated"})
+}
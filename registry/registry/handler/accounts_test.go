@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestAddAccountHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db := sqlx.NewDb(mockDB, "sqlmock")
+
+	addAccount := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set("DB", db)
+		c.Set("userID", "admin")
+		c.Set("role", "admin")
+		c.Request = httptest.NewRequest("POST", "/api/v1/admin/accounts",
+			bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		AddAccountHandler(c)
+		return w
+	}
+
+	mock.ExpectExec("^INSERT INTO \"accounts\"").
+		WithArgs("alice", sqlmock.AnyArg(), "operator").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("^INSERT INTO \"audit_log\"").
+		WithArgs(sqlmock.AnyArg(), "admin", "admin", "account.create", "alice",
+			"", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	w := addAccount(`{"username": "alice", "password": "correct horse battery", "role": "operator"}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 (got: %d %s)", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	if w := addAccount(`{"username": "bob", "password": "short", "role": "operator"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a short password (got: %d)", w.Code)
+	}
+	if w := addAccount(`{"username": "bob", "password": "correct horse battery", "role": "device"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a device account (got: %d)", w.Code)
+	}
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 	common "github.com/ooni/orchestra/common"
+	"github.com/ooni/orchestra/common/middleware"
 )
 
 // ActiveClient metadata about an active client
@@ -237,7 +239,9 @@ func (q ClientsQuery) MakeMetadata(db *sqlx.DB) (Dict, error) {
 	return metadata, err
 }
 
-// ListClientsHandler is the admin handler for listing registered clients
+// ListClientsHandler is the admin handler for listing registered clients.
+// The listing includes the push tokens of the clients, so every listing is
+// recorded in the audit log.
 func ListClientsHandler(c *gin.Context) {
 	var (
 		err          error
@@ -270,6 +274,9 @@ func ListClientsHandler(c *gin.Context) {
 			gin.H{"error": err.Error()})
 		return
 	}
+	audit := middleware.RequestAuditEntry(c)
+	audit.Action = "clients.list"
+	middleware.WriteAuditEntry(db, audit)
 
 	c.JSON(http.StatusOK,
 		gin.H{
@@ -277,3 +284,70 @@ func ListClientsHandler(c *gin.Context) {
 			"metadata": metadata,
 		})
 }
+
+// ErrClientNotFound there is no registered client with the given ID
+var ErrClientNotFound = errors.New("client not found")
+
+// DeleteClient deletes the registered client and its device account and
+// revokes the tokens issued to it. The history of its updates is kept.
+func DeleteClient(db *sqlx.DB, clientID string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		ctx.WithError(err).Error("failed to open transaction")
+		return err
+	}
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id = $1`,
+		pq.QuoteIdentifier(common.ActiveProbesTable))
+	res, err := tx.Exec(query, clientID)
+	if err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("failed to delete client")
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("failed to get affected rows")
+		return err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return ErrClientNotFound
+	}
+	query = fmt.Sprintf(`DELETE FROM %s WHERE username = $1 AND role = 'device'`,
+		pq.QuoteIdentifier(common.AccountsTable))
+	if _, err = tx.Exec(query, clientID); err != nil {
+		tx.Rollback()
+		ctx.WithError(err).Error("failed to delete the client account")
+		return err
+	}
+	if err = middleware.RevokeUserTokens(tx, clientID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		ctx.WithError(err).Error("failed to commit transaction")
+		return err
+	}
+	return nil
+}
+
+// DeleteClientHandler is the admin handler for deleting a registered client
+func DeleteClientHandler(c *gin.Context) {
+	db := c.MustGet("DB").(*sqlx.DB)
+
+	err := DeleteClient(db, c.Param("client_id"))
+	if err == ErrClientNotFound {
+		c.JSON(http.StatusNotFound,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError,
+			gin.H{"error": "server side error"})
+		return
+	}
+	middleware.ForgetUserRevocations(c, c.Param("client_id"))
+	c.JSON(http.StatusOK,
+		gin.H{"status": "deleted"})
+}
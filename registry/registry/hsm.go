@@ -0,0 +1,20 @@
+//go:build !pkcs11
+// +build !pkcs11
+
+package registry
+
+import (
+	"crypto"
+	"errors"
+
+	"github.com/spf13/viper"
+)
+
+// loadSigningKey fails when an HSM is configured, as the registry was built
+// without the pkcs11 tag
+func loadSigningKey() (crypto.Signer, error) {
+	if viper.GetString("auth.hsm-library") != "" {
+		return nil, errors.New("auth.hsm-library is set but ooni-registry was built without pkcs11 support")
+	}
+	return nil, nil
+}
@@ -0,0 +1,25 @@
+//go:build pkcs11
+// +build pkcs11
+
+package registry
+
+import (
+	"crypto"
+
+	"github.com/ooni/orchestra/common/keystore"
+	"github.com/spf13/viper"
+)
+
+// loadSigningKey returns the JWT signing key stored in the HSM configured
+// with auth.hsm-library, or nil when no HSM is configured
+func loadSigningKey() (crypto.Signer, error) {
+	libPath := viper.GetString("auth.hsm-library")
+	if libPath == "" {
+		return nil, nil
+	}
+	label := viper.GetString("auth.hsm-key-label")
+	if label == "" {
+		label = keystore.KeyLabel
+	}
+	return keystore.NewSigner(libPath, label, viper.GetString("auth.hsm-pin"))
+}
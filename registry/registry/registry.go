@@ -50,7 +50,12 @@ func SetupRouter(dbURL string) *gin.Engine {
 		return nil
 	}
 
-	authMiddleware, err := middleware.InitAuthMiddleware(dbMiddleware.DB)
+	signingKey, err := loadSigningKey()
+	if err != nil {
+		ctx.WithError(err).Error("failed to load the HSM signing key")
+		return nil
+	}
+	authMiddleware, err := middleware.InitAuthMiddleware(dbMiddleware.DB, signingKey)
 	if err != nil {
 		ctx.WithError(err).Error("failed to initialise authMiddlewareDevice")
 		return nil
@@ -67,7 +72,8 @@ func SetupRouter(dbURL string) *gin.Engine {
 			"componentDescription": LongDescription,
 		})
 	})
-	err = apiv1.BindAPI(router, authMiddleware)
+	router.GET("/.well-known/jwks.json", authMiddleware.JWKSHandler)
+	err = apiv1.BindAPI(router, authMiddleware, dbMiddleware.DB)
 	if err != nil {
 		ctx.WithError(err).Error("failed to BindAPI")
 		return nil